@@ -1 +1,11 @@
 # Include custom targets and environment variables here
+
+## Benchmark the sync engine against generated directories of up to 100k users.
+.PHONY: bench
+bench:
+	cd server && $(GO) test ./attrsync -run '^$$' -bench BenchmarkEngineRun -benchtime 1x
+
+## Rewrite the expected plans of the sync golden tests after an intended change in behavior.
+.PHONY: golden
+golden:
+	cd server && $(GO) test ./attrsync -run TestGolden -update
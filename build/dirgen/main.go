@@ -0,0 +1,88 @@
+// main generates synthetic user directories for load and scale testing the attribute sync.
+//
+// It writes one file per snapshot of the directory to the output directory, each a day after the
+// previous one and differing from it by the configured churn and turnover. JSON snapshots can be
+// served as is to the HTTP source.
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/mattermost/mattermost-plugin-starter-template/server/dirgen"
+)
+
+func main() {
+	if err := generate(); err != nil {
+		fmt.Printf("Failed: %s\n", err.Error())
+		os.Exit(1)
+	}
+}
+
+func generate() error {
+	var (
+		config    dirgen.Config
+		snapshots int
+		format    string
+		out       string
+	)
+	flag.IntVar(&config.Users, "users", 1000, "number of users in each snapshot")
+	flag.Float64Var(&config.Churn, "churn", 0.05, "fraction of users whose attributes change between snapshots")
+	flag.Float64Var(&config.Turnover, "turnover", 0.01, "fraction of users replaced by new users between snapshots")
+	flag.Uint64Var(&config.Seed, "seed", 1, "seed making the output reproducible")
+	flag.IntVar(&snapshots, "snapshots", 1, "number of snapshots to generate")
+	flag.StringVar(&format, "format", "json", "output format, json or csv")
+	flag.StringVar(&out, "out", "directory", "directory to write the snapshots to")
+	flag.Parse()
+
+	write := dirgen.WriteJSON
+	switch format {
+	case "json":
+	case "csv":
+		write = dirgen.WriteCSV
+	default:
+		return fmt.Errorf("unknown format %q", format)
+	}
+	if config.Users < 1 || snapshots < 1 {
+		return errors.New("users and snapshots must be positive")
+	}
+	if config.Churn < 0 || config.Churn > 1 || config.Turnover < 0 || config.Turnover > 1 {
+		return errors.New("churn and turnover must be between 0 and 1")
+	}
+
+	if err := os.MkdirAll(out, 0o755); err != nil {
+		return fmt.Errorf("failed to create %s: %w", out, err)
+	}
+
+	generator := dirgen.New(config)
+	for i := 1; i <= snapshots; i++ {
+		if i > 1 {
+			changes := generator.Next()
+			fmt.Printf("Snapshot %d: %d changed, %d joined, %d left\n", i, changes.Changed, changes.Joined, changes.Left)
+		}
+
+		path := filepath.Join(out, fmt.Sprintf("snapshot-%03d.%s", i, format))
+		if err := writeSnapshot(path, generator.Users(), write); err != nil {
+			return err
+		}
+		fmt.Printf("Wrote %d users to %s\n", config.Users, path)
+	}
+	return nil
+}
+
+func writeSnapshot(path string, users []dirgen.User, write func(w io.Writer, users []dirgen.User) error) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %w", path, err)
+	}
+	defer file.Close()
+
+	if err = write(file, users); err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	return file.Close()
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+// archiveURL returns the URL of the plugin's archive endpoint.
+func archiveURL(client *model.Client4, pluginID string) string {
+	return client.URL + "/plugins/" + url.PathEscape(pluginID) + "/api/v1/archive"
+}
+
+// exportArchive downloads the plugin's state and mapping configuration to archivePath.
+func exportArchive(ctx context.Context, client *model.Client4, pluginID, archivePath string) error {
+	log.Print("Exporting plugin archive.")
+	resp, err := client.DoAPIRequest(ctx, http.MethodGet, archiveURL(client, pluginID), "", "")
+	if err != nil {
+		return fmt.Errorf("failed to export archive: %w", err)
+	}
+	defer resp.Body.Close()
+
+	archive, err := os.Create(archivePath)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %w", archivePath, err)
+	}
+	defer archive.Close()
+
+	if _, err = io.Copy(archive, resp.Body); err != nil {
+		return fmt.Errorf("failed to write %s: %w", archivePath, err)
+	}
+
+	return archive.Close()
+}
+
+// importArchive uploads the archive at archivePath to the plugin. include optionally restricts
+// the import to "configuration" or "state".
+func importArchive(ctx context.Context, client *model.Client4, pluginID, archivePath, include string) error {
+	archive, err := os.ReadFile(archivePath)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", archivePath, err)
+	}
+
+	archiveURL := archiveURL(client, pluginID)
+	if include != "" {
+		archiveURL += "?include=" + url.QueryEscape(include)
+	}
+
+	log.Print("Importing plugin archive.")
+	resp, err := client.DoAPIRequestBytes(ctx, http.MethodPost, archiveURL, archive, "")
+	if err != nil {
+		if resp != nil && resp.StatusCode == http.StatusConflict {
+			return fmt.Errorf("cannot import archive while a sync is running, retry once it has finished: %w", err)
+		}
+		return fmt.Errorf("failed to import archive: %w", err)
+	}
+	resp.Body.Close()
+
+	return nil
+}
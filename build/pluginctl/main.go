@@ -21,6 +21,8 @@ Usage:
     pluginctl disable <plugin id>
     pluginctl enable <plugin id>
     pluginctl reset <plugin id>
+    pluginctl export <plugin id> <archive path>
+    pluginctl import <plugin id> <archive path> [configuration|state]
 `
 
 func main() {
@@ -57,6 +59,20 @@ func pluginctl() error {
 		return enablePlugin(ctx, client, os.Args[2])
 	case "reset":
 		return resetPlugin(ctx, client, os.Args[2])
+	case "export":
+		if len(os.Args) < 4 {
+			return errors.New("invalid number of arguments")
+		}
+		return exportArchive(ctx, client, os.Args[2], os.Args[3])
+	case "import":
+		if len(os.Args) < 4 {
+			return errors.New("invalid number of arguments")
+		}
+		var include string
+		if len(os.Args) > 4 {
+			include = os.Args[4]
+		}
+		return importArchive(ctx, client, os.Args[2], os.Args[3], include)
 	case "logs":
 		return logs(ctx, client, os.Args[2])
 	case "logs-watch":
@@ -4,6 +4,7 @@ import (
 	"net/http"
 
 	"github.com/gorilla/mux"
+	"github.com/mattermost/mattermost/server/public/model"
 	"github.com/mattermost/mattermost/server/public/plugin"
 )
 
@@ -15,10 +16,30 @@ func (p *Plugin) ServeHTTP(c *plugin.Context, w http.ResponseWriter, r *http.Req
 	// Middleware to require that the user is logged in
 	router.Use(p.MattermostAuthorizationRequired)
 
+	// Scrapers authenticate with a system admin's personal access token.
+	router.Handle("/metrics", p.SystemAdminRequired(http.HandlerFunc(p.ServeMetrics))).Methods(http.MethodGet)
+
 	apiRouter := router.PathPrefix("/api/v1").Subrouter()
 
 	apiRouter.HandleFunc("/hello", p.HelloWorld).Methods(http.MethodGet)
 
+	syncRouter := apiRouter.PathPrefix("/sync").Subrouter()
+	syncRouter.Use(p.SystemAdminRequired)
+	syncRouter.HandleFunc("/status", p.GetSyncStatus).Methods(http.MethodGet)
+	syncRouter.HandleFunc("/runs/{id}", p.GetSyncRun).Methods(http.MethodGet)
+	syncRouter.HandleFunc("/runs/{id}/cancel", p.CancelSyncRun).Methods(http.MethodPost)
+	syncRouter.HandleFunc("/users", p.SyncUsersNow).Methods(http.MethodPost)
+	syncRouter.HandleFunc("/quarantine/{key}", p.ReleaseQuarantinedRecord).Methods(http.MethodDelete)
+
+	archiveRouter := apiRouter.PathPrefix("/archive").Subrouter()
+	archiveRouter.Use(p.SystemAdminRequired)
+	archiveRouter.HandleFunc("", p.ExportArchive).Methods(http.MethodGet)
+	archiveRouter.HandleFunc("", p.ImportArchive).Methods(http.MethodPost)
+
+	usersRouter := apiRouter.PathPrefix("/users").Subrouter()
+	usersRouter.Use(p.SystemAdminRequired)
+	usersRouter.HandleFunc("/{id}/sync-preview", p.GetUserSyncPreview).Methods(http.MethodGet)
+
 	router.ServeHTTP(w, r)
 }
 
@@ -34,6 +55,18 @@ func (p *Plugin) MattermostAuthorizationRequired(next http.Handler) http.Handler
 	})
 }
 
+func (p *Plugin) SystemAdminRequired(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userID := r.Header.Get("Mattermost-User-ID")
+		if !p.client.User.HasPermissionTo(userID, model.PermissionManageSystem) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
 func (p *Plugin) HelloWorld(w http.ResponseWriter, r *http.Request) {
 	if _, err := w.Write([]byte("Hello, world!")); err != nil {
 		p.API.LogError("Failed to write response", "error", err)
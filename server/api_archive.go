@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ExportArchive returns the plugin's stored state and mapping configuration as an archive.
+func (p *Plugin) ExportArchive(w http.ResponseWriter, r *http.Request) {
+	archive, err := p.exportArchive()
+	if err != nil {
+		p.API.LogError("Failed to export archive", "error", err)
+		http.Error(w, "Failed to export archive", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s-%s.json"`, archive.PluginID, archive.ExportedAt.Format("20060102-150405")))
+	p.writeJSON(w, http.StatusOK, archive)
+}
+
+// ImportArchive applies an archive exported from another server. The include query parameter
+// selects the parts to import, "configuration" and "state", and defaults to both.
+func (p *Plugin) ImportArchive(w http.ResponseWriter, r *http.Request) {
+	importConfiguration, importState := true, true
+	if include := r.URL.Query().Get("include"); include != "" {
+		importConfiguration, importState = false, false
+		for _, part := range strings.Split(include, ",") {
+			switch part {
+			case "configuration":
+				importConfiguration = true
+			case "state":
+				importState = true
+			default:
+				http.Error(w, fmt.Sprintf("Unknown archive part %q", part), http.StatusBadRequest)
+				return
+			}
+		}
+	}
+
+	var archive Archive
+	if err := json.NewDecoder(r.Body).Decode(&archive); err != nil {
+		http.Error(w, "Invalid archive", http.StatusBadRequest)
+		return
+	}
+
+	if err := p.validateArchive(&archive, importConfiguration, importState); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid archive: %s", err.Error()), http.StatusBadRequest)
+		return
+	}
+
+	start := time.Now()
+	if err := p.importArchive(&archive, importConfiguration, importState); err != nil {
+		var running syncRunningError
+		if errors.As(err, &running) {
+			http.Error(w, running.Error(), http.StatusConflict)
+			return
+		}
+		p.API.LogError("Failed to import archive", "error", err)
+		http.Error(w, "Failed to import archive", http.StatusInternalServerError)
+		return
+	}
+	p.API.LogInfo("Imported archive",
+		"exported_at", archive.ExportedAt,
+		"configuration", importConfiguration,
+		"state", importState,
+		"duration", time.Since(start).String(),
+	)
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/mattermost/mattermost/server/public/pluginapi"
+	"github.com/pkg/errors"
+
+	"github.com/mattermost/mattermost-plugin-starter-template/server/store/kvstore"
+)
+
+// userPreviewTimeout bounds how long a preview may read the source, which it scans in full to find
+// the user's record.
+const userPreviewTimeout = time.Minute
+
+// SyncStatus is the response of the sync status endpoint.
+type SyncStatus struct {
+	// Running describes the sync in progress, if any.
+	Running  *kvstore.SyncLock      `json:"running"`
+	LastRun  *kvstore.SyncRun       `json:"last_run"`
+	Failures []*kvstore.UserFailure `json:"failures"`
+}
+
+// GetSyncStatus returns the sync in progress, the last sync run and the records currently failing
+// to sync.
+func (p *Plugin) GetSyncStatus(w http.ResponseWriter, r *http.Request) {
+	running, err := p.RunningSync()
+	if err != nil {
+		p.API.LogError("Failed to get the running sync", "error", err)
+		http.Error(w, "Failed to get sync status", http.StatusInternalServerError)
+		return
+	}
+	run, err := p.kvstore.GetLastRun()
+	if err != nil {
+		p.API.LogError("Failed to get last sync run", "error", err)
+		http.Error(w, "Failed to get sync status", http.StatusInternalServerError)
+		return
+	}
+	failures, err := p.kvstore.ListUserFailures()
+	if err != nil {
+		p.API.LogError("Failed to list user failures", "error", err)
+		http.Error(w, "Failed to get sync status", http.StatusInternalServerError)
+		return
+	}
+	if failures == nil {
+		failures = []*kvstore.UserFailure{}
+	}
+
+	p.writeJSON(w, http.StatusOK, SyncStatus{
+		Running:  running,
+		LastRun:  run,
+		Failures: failures,
+	})
+}
+
+// ServeMetrics writes the sync metrics in the Prometheus text exposition format. Apart from the
+// time of the last successful run, they only cover the runs on this node.
+func (p *Plugin) ServeMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	if err := p.metrics.Write(w); err != nil {
+		p.API.LogError("Failed to write metrics", "error", err)
+	}
+}
+
+// GetSyncRun returns a single sync run. Runs holding the sync lock are reported as running.
+func (p *Plugin) GetSyncRun(w http.ResponseWriter, r *http.Request) {
+	runID := mux.Vars(r)["id"]
+	run, err := p.kvstore.GetRun(runID)
+	if err != nil {
+		p.API.LogError("Failed to get sync run", "run_id", runID, "error", err)
+		http.Error(w, "Failed to get sync run", http.StatusInternalServerError)
+		return
+	}
+	if run == nil {
+		run, err = p.runningSyncRun(runID)
+		if err != nil {
+			p.API.LogError("Failed to get the running sync", "run_id", runID, "error", err)
+			http.Error(w, "Failed to get sync run", http.StatusInternalServerError)
+			return
+		}
+	}
+	if run == nil {
+		http.Error(w, "Run not found", http.StatusNotFound)
+		return
+	}
+
+	p.writeJSON(w, http.StatusOK, run)
+}
+
+// CancelSyncRun asks a sync run in progress to stop after its current page. The run may be on
+// another node, so the request is accepted before the run has stopped.
+func (p *Plugin) CancelSyncRun(w http.ResponseWriter, r *http.Request) {
+	runID := mux.Vars(r)["id"]
+	running, err := p.CancelSync(runID, r.Header.Get("Mattermost-User-ID"))
+	if err != nil {
+		p.API.LogError("Failed to cancel sync run", "run_id", runID, "error", err)
+		http.Error(w, "Failed to cancel sync run", http.StatusInternalServerError)
+		return
+	}
+	if running != nil {
+		p.writeJSON(w, http.StatusAccepted, running)
+		return
+	}
+
+	run, err := p.kvstore.GetRun(runID)
+	if err != nil {
+		p.API.LogError("Failed to get sync run", "run_id", runID, "error", err)
+		http.Error(w, "Failed to cancel sync run", http.StatusInternalServerError)
+		return
+	}
+	if run == nil {
+		http.Error(w, "Run not found", http.StatusNotFound)
+		return
+	}
+	http.Error(w, "Run has already finished", http.StatusConflict)
+}
+
+// ReleaseQuarantinedRecord clears the failure state of a record so that it is synced again.
+func (p *Plugin) ReleaseQuarantinedRecord(w http.ResponseWriter, r *http.Request) {
+	key := mux.Vars(r)["key"]
+	failure, err := p.kvstore.GetUserFailure(key)
+	if err != nil {
+		p.API.LogError("Failed to get user failure", "key", key, "error", err)
+		http.Error(w, "Failed to release record", http.StatusInternalServerError)
+		return
+	}
+	if failure == nil {
+		http.Error(w, "Record not found", http.StatusNotFound)
+		return
+	}
+	if err = p.kvstore.DeleteUserFailure(key); err != nil {
+		p.API.LogError("Failed to delete user failure", "key", key, "error", err)
+		http.Error(w, "Failed to release record", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GetUserSyncPreview returns what a sync would change for a single user, without changing anything.
+func (p *Plugin) GetUserSyncPreview(w http.ResponseWriter, r *http.Request) {
+	userID := mux.Vars(r)["id"]
+	ctx, cancel := context.WithTimeout(r.Context(), userPreviewTimeout)
+	defer cancel()
+	preview, err := p.PreviewUserSync(ctx, userID)
+	if err != nil {
+		switch {
+		case errors.Is(err, context.DeadlineExceeded):
+			http.Error(w, "Timed out reading the source", http.StatusGatewayTimeout)
+		case errors.Is(err, pluginapi.ErrNotFound):
+			http.Error(w, "User not found", http.StatusNotFound)
+		case errors.Is(err, errNoSyncSource):
+			http.Error(w, "No sync source is configured", http.StatusConflict)
+		default:
+			p.API.LogError("Failed to preview user sync", "user_id", userID, "error", err)
+			http.Error(w, "Failed to preview sync", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	p.writeJSON(w, http.StatusOK, preview)
+}
+
+// SyncUsersRequest is the body of the manual user sync endpoint.
+type SyncUsersRequest struct {
+	// Users are identified by ID, email or username.
+	Users []string `json:"users"`
+}
+
+// SyncUsersResponse is returned once a manual user sync has started.
+type SyncUsersResponse struct {
+	// RunID identifies the run at /sync/runs/{id}, which reports it as running until it finishes.
+	RunID string `json:"run_id"`
+}
+
+// SyncUsersNow starts syncing the requested users immediately, outside of the schedule. It
+// replies once the run has started; the requester is sent a direct message when it finishes.
+func (p *Plugin) SyncUsersNow(w http.ResponseWriter, r *http.Request) {
+	var request SyncUsersRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if len(request.Users) == 0 {
+		http.Error(w, "No users to sync", http.StatusBadRequest)
+		return
+	}
+
+	runID, err := p.StartUserSync(request.Users, r.Header.Get("Mattermost-User-ID"))
+	if err != nil {
+		var (
+			unknown unknownUsersError
+			running syncRunningError
+		)
+		switch {
+		case errors.As(err, &unknown):
+			http.Error(w, unknown.Error(), http.StatusBadRequest)
+		case errors.As(err, &running):
+			http.Error(w, running.Error(), http.StatusConflict)
+		case errors.Is(err, errNoSyncSource):
+			http.Error(w, "No sync source is configured", http.StatusConflict)
+		default:
+			http.Error(w, "Failed to sync users", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	p.writeJSON(w, http.StatusAccepted, SyncUsersResponse{RunID: runID})
+}
+
+func (p *Plugin) writeJSON(w http.ResponseWriter, statusCode int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		p.API.LogError("Failed to write response", "error", err)
+	}
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/mattermost/mattermost-plugin-starter-template/server/attrsync"
+	"github.com/mattermost/mattermost-plugin-starter-template/server/expr"
+	"github.com/mattermost/mattermost-plugin-starter-template/server/membership"
+	"github.com/mattermost/mattermost-plugin-starter-template/server/store/kvstore"
+)
+
+// archiveFormatVersion is the version of the Archive layout. Bump it when fields change meaning.
+const archiveFormatVersion = 1
+
+// Archive is an export of the plugin's stored state and mapping configuration, to be imported
+// into another server.
+type Archive struct {
+	FormatVersion int       `json:"format_version"`
+	PluginID      string    `json:"plugin_id"`
+	ExportedAt    time.Time `json:"exported_at"`
+
+	Configuration *ArchiveConfiguration `json:"configuration,omitempty"`
+	State         *kvstore.Snapshot     `json:"state,omitempty"`
+}
+
+// ArchiveConfiguration holds the settings deciding what is synced and how. Connection settings
+// and secrets differ between servers and are not archived.
+type ArchiveConfiguration struct {
+	FieldMappings       string `json:"field_mappings"`
+	SourceExcludeFilter string `json:"source_exclude_filter"`
+	MembershipRules     string `json:"membership_rules"`
+}
+
+// validate checks the archived settings as OnConfigurationChange would.
+func (c *ArchiveConfiguration) validate() error {
+	if _, err := attrsync.ParseMappings(c.FieldMappings); err != nil {
+		return errors.Wrap(err, "invalid field mappings")
+	}
+	if c.SourceExcludeFilter != "" {
+		if _, err := expr.Compile(c.SourceExcludeFilter); err != nil {
+			return errors.Wrap(err, "invalid source exclude filter")
+		}
+	}
+	if _, err := membership.ParseRules(c.MembershipRules); err != nil {
+		return errors.Wrap(err, "invalid membership rules")
+	}
+	return nil
+}
+
+// exportArchive archives the stored state and the mapping configuration.
+func (p *Plugin) exportArchive() (*Archive, error) {
+	state, err := p.kvstore.Export()
+	if err != nil {
+		return nil, err
+	}
+	config := p.getConfiguration()
+	return &Archive{
+		FormatVersion: archiveFormatVersion,
+		PluginID:      p.API.GetPluginID(),
+		ExportedAt:    time.Now().UTC(),
+		Configuration: &ArchiveConfiguration{
+			FieldMappings:       config.FieldMappings,
+			SourceExcludeFilter: config.SourceExcludeFilter,
+			MembershipRules:     config.MembershipRules,
+		},
+		State: state,
+	}, nil
+}
+
+// validateArchive checks that the parts of archive selected by importConfiguration and
+// importState can be imported.
+func (p *Plugin) validateArchive(archive *Archive, importConfiguration, importState bool) error {
+	if archive.FormatVersion != archiveFormatVersion {
+		return errors.Errorf("unsupported archive format version %d", archive.FormatVersion)
+	}
+	if archive.PluginID != p.API.GetPluginID() {
+		return errors.Errorf("archive was exported by plugin %s", archive.PluginID)
+	}
+	if importConfiguration {
+		if archive.Configuration == nil {
+			return errors.New("archive has no configuration")
+		}
+		if err := archive.Configuration.validate(); err != nil {
+			return err
+		}
+	}
+	if importState {
+		if archive.State == nil {
+			return errors.New("archive has no state")
+		}
+		if latest := kvstore.LatestSchemaVersion(); archive.State.SchemaVersion > latest {
+			return errors.Errorf("archived state has schema version %d, newer than the supported %d", archive.State.SchemaVersion, latest)
+		}
+	}
+	return nil
+}
+
+// importArchive applies the parts of a validated archive selected by importConfiguration and
+// importState. Imported state replaces the stored state and is migrated to the current schema.
+// State is imported under the sync lock, so it fails with a syncRunningError while a sync runs.
+func (p *Plugin) importArchive(archive *Archive, importConfiguration, importState bool) error {
+	if importState {
+		run := p.newSyncRun(kvstore.SyncTriggerImport)
+		if err := run.lock(); err != nil {
+			return err
+		}
+		defer run.unlock()
+
+		if err := p.kvstore.Import(archive.State); err != nil {
+			return errors.Wrap(err, "failed to import state")
+		}
+		if err := p.migrateKVStore(); err != nil {
+			return err
+		}
+	}
+	if importConfiguration {
+		if err := p.saveMappingConfiguration(archive.Configuration); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// saveMappingConfiguration replaces the mapping settings of the plugin configuration, leaving the
+// other settings alone.
+func (p *Plugin) saveMappingConfiguration(c *ArchiveConfiguration) error {
+	pluginConfig := p.API.GetPluginConfig()
+	if pluginConfig == nil {
+		pluginConfig = map[string]any{}
+	}
+	for key, value := range map[string]string{
+		"FieldMappings":       c.FieldMappings,
+		"SourceExcludeFilter": c.SourceExcludeFilter,
+		"MembershipRules":     c.MembershipRules,
+	} {
+		// The server stores setting keys in lower case.
+		for existing := range pluginConfig {
+			if strings.EqualFold(existing, key) {
+				delete(pluginConfig, existing)
+			}
+		}
+		pluginConfig[strings.ToLower(key)] = value
+	}
+	if appErr := p.API.SavePluginConfig(pluginConfig); appErr != nil {
+		return errors.Wrap(appErr, "failed to save plugin configuration")
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+package attrsync
+
+import (
+	"strings"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/pkg/errors"
+)
+
+// attributePropPrefix namespaces synced attributes within the user's Props.
+const attributePropPrefix = "attr_"
+
+// UserService is the subset of the Mattermost user API the sync engine depends on.
+// *pluginapi.UserService satisfies it.
+type UserService interface {
+	List(options *model.UserGetOptions) ([]*model.User, error)
+	Get(userID string) (*model.User, error)
+	GetByEmail(email string) (*model.User, error)
+	GetByUsername(username string) (*model.User, error)
+	Update(user *model.User) error
+}
+
+// AttributeStore reads and writes the synced attributes of a Mattermost user.
+type AttributeStore interface {
+	GetAttributes(user *model.User) (map[string]string, error)
+	SetAttributes(user *model.User, values map[string]string) error
+}
+
+// writeCalls is the number of Mattermost API calls made by PropsAttributeStore.SetAttributes,
+// which the engine takes from its rate limit for every write.
+const writeCalls = 2
+
+// PropsAttributeStore keeps attributes in the user's Props. The plugin API of the supported
+// server versions does not expose property values, so this is the only writable per-user storage.
+type PropsAttributeStore struct {
+	users UserService
+}
+
+// NewPropsAttributeStore creates an AttributeStore backed by user Props.
+func NewPropsAttributeStore(users UserService) *PropsAttributeStore {
+	return &PropsAttributeStore{
+		users: users,
+	}
+}
+
+// GetAttributes returns the synced attributes currently stored on user.
+func (s *PropsAttributeStore) GetAttributes(user *model.User) (map[string]string, error) {
+	values := map[string]string{}
+	for key, value := range user.Props {
+		if name, ok := strings.CutPrefix(key, attributePropPrefix); ok {
+			values[name] = value
+		}
+	}
+	return values, nil
+}
+
+// SetAttributes writes values onto the user, removing attributes set to an empty value.
+func (s *PropsAttributeStore) SetAttributes(user *model.User, values map[string]string) error {
+	// Re-read the user so that concurrent changes to unrelated fields are not overwritten.
+	current, err := s.users.Get(user.Id)
+	if err != nil {
+		return errors.Wrapf(err, "failed to get user %s", user.Id)
+	}
+
+	for name, value := range values {
+		if value == "" {
+			delete(current.Props, attributePropPrefix+name)
+			continue
+		}
+		current.SetProp(attributePropPrefix+name, value)
+	}
+
+	if err := s.users.Update(current); err != nil {
+		return errors.Wrapf(err, "failed to update user %s", user.Id)
+	}
+	return nil
+}
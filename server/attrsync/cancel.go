@@ -0,0 +1,59 @@
+package attrsync
+
+import (
+	"github.com/pkg/errors"
+
+	"github.com/mattermost/mattermost-plugin-starter-template/server/store/kvstore"
+)
+
+// ErrRunCancelled is returned by runs that stopped because they were asked to, see Canceller.
+var ErrRunCancelled = errors.New("sync run cancelled")
+
+// Canceller lets runs be cancelled from outside of the engine, possibly from another cluster
+// node. Runs check for cancellation between pages and every cancelCheckInterval records within
+// a page, and let the records in flight finish before they stop.
+type Canceller interface {
+	// RunStarted is called once the ID of a run is known, so that it can be cancelled by ID.
+	RunStarted(runID string)
+
+	// CancelRequested reports whether the run with runID has been asked to stop.
+	CancelRequested(runID string) (bool, error)
+}
+
+type nopCanceller struct{}
+
+func (nopCanceller) RunStarted(string)                    {}
+func (nopCanceller) CancelRequested(string) (bool, error) { return false, nil }
+
+// cancelCheckInterval is how many records of a page are dispatched between checks for
+// cancellation. Checking costs a KV read.
+const cancelCheckInterval = 100
+
+// cancelRequested reports whether the run with runID has been asked to stop. Failing to tell is
+// logged rather than failing the run, which is checked again later.
+func (e *Engine) cancelRequested(runID string) bool {
+	cancelled, err := e.canceller.CancelRequested(runID)
+	if err != nil {
+		e.log.Warn("Failed to check whether the sync run was cancelled", "err", err)
+		return false
+	}
+	return cancelled
+}
+
+// checkCancelled returns ErrRunCancelled if the run with runID was asked to stop.
+func (e *Engine) checkCancelled(runID string) error {
+	if e.cancelRequested(runID) {
+		return ErrRunCancelled
+	}
+	return nil
+}
+
+// discardCancelledRun removes the checkpoint of a cancelled run, so that the next run starts over
+// rather than resuming it.
+func (e *Engine) discardCancelledRun(result *Result) {
+	err := e.store.DeleteCheckpoint(&kvstore.SyncCheckpoint{RunID: result.RunID, Source: result.Source})
+	if err != nil {
+		e.log.Warn("Failed to discard the checkpoint of the cancelled sync run", "phase", phaseCommit, "err", err)
+	}
+	e.log.Info("Sync run cancelled", "phase", phaseCommit, "fetched", result.Fetched, "updated", result.Updated)
+}
@@ -0,0 +1,685 @@
+package attrsync
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/pluginapi"
+	"github.com/pkg/errors"
+
+	"github.com/mattermost/mattermost-plugin-starter-template/server/expr"
+	"github.com/mattermost/mattermost-plugin-starter-template/server/store/kvstore"
+)
+
+// Logger is the logging interface used by the engine. *pluginapi.LogService satisfies it.
+type Logger interface {
+	Debug(message string, keyValuePairs ...any)
+	Info(message string, keyValuePairs ...any)
+	Warn(message string, keyValuePairs ...any)
+	Error(message string, keyValuePairs ...any)
+}
+
+// Config holds the dependencies and settings of an Engine.
+type Config struct {
+	Source     Source
+	Store      kvstore.KVStore
+	Users      UserService
+	Attributes AttributeStore
+	Log        Logger
+
+	// Metrics receives measurements of runs. It is optional.
+	Metrics Metrics
+
+	Mappings []FieldMapping
+
+	// Exclude is an optional condition over source records. Records satisfying it are skipped
+	// before they are matched to users.
+	Exclude *expr.Expression
+
+	// FullSyncInterval is how often the full directory is reconciled even when the source supports
+	// incremental fetches. Zero means every run is a full sync.
+	FullSyncInterval time.Duration
+
+	// Concurrency is the number of records synced in parallel. Values below one mean one.
+	Concurrency int
+
+	// APIRateLimit caps the Mattermost API calls made per second across all workers. Zero means
+	// unlimited.
+	APIRateLimit float64
+
+	// Retry controls how transient source and Mattermost API failures are retried. The zero value
+	// means DefaultRetryPolicy.
+	Retry RetryPolicy
+
+	// QuarantineThreshold is the number of consecutive runs a record may fail in before it is
+	// quarantined and skipped. Zero disables quarantine.
+	QuarantineThreshold int
+
+	// Guardrails abort runs whose changes look like the result of a broken source.
+	Guardrails Guardrails
+
+	// Verbosity controls the per-user output logged by runs. The zero value means VerbosityNone.
+	Verbosity Verbosity
+
+	// Canceller lets runs be cancelled while in progress. It is optional.
+	Canceller Canceller
+}
+
+// Engine syncs user attributes from a Source into Mattermost.
+type Engine struct {
+	source     Source
+	store      kvstore.KVStore
+	users      UserService
+	attributes AttributeStore
+	log        Logger
+	metrics    Metrics
+	canceller  Canceller
+
+	mappings         []FieldMapping
+	exclude          *expr.Expression
+	fullSyncInterval time.Duration
+	concurrency      int
+	limiter          *tokenBucket
+
+	retryPolicy         RetryPolicy
+	quarantineThreshold int
+	guardrails          Guardrails
+	verbosity           Verbosity
+
+	// now is overridden in tests.
+	now func() time.Time
+}
+
+// checkpointMaxAge bounds how long an interrupted run may be resumed. Older checkpoints are
+// discarded, as the source's page tokens have likely expired.
+const checkpointMaxAge = 24 * time.Hour
+
+// Result summarizes a sync run.
+type Result struct {
+	RunID     string    `json:"run_id"`
+	Source    string    `json:"source"`
+	Resumed   bool      `json:"resumed"`
+	Full      bool      `json:"full"`
+	StartedAt time.Time `json:"started_at"`
+	kvstore.SyncStats
+
+	// NewUnmappedFields lists the source fields reported for the first time by this run that are
+	// not mapped to any attribute.
+	NewUnmappedFields []string `json:"new_unmapped_fields,omitempty"`
+
+	// NotFound lists the users requested from SyncUsers that the source has no record for.
+	NotFound []string `json:"not_found,omitempty"`
+}
+
+// NewEngine creates an Engine from cfg.
+func NewEngine(cfg Config) *Engine {
+	if cfg.Retry == (RetryPolicy{}) {
+		cfg.Retry = DefaultRetryPolicy
+	}
+	if cfg.Metrics == nil {
+		cfg.Metrics = nopMetrics{}
+	}
+	if cfg.Canceller == nil {
+		cfg.Canceller = nopCanceller{}
+	}
+
+	return &Engine{
+		source:              cfg.Source,
+		store:               cfg.Store,
+		users:               cfg.Users,
+		attributes:          cfg.Attributes,
+		log:                 cfg.Log,
+		metrics:             cfg.Metrics,
+		canceller:           cfg.Canceller,
+		mappings:            cfg.Mappings,
+		exclude:             cfg.Exclude,
+		fullSyncInterval:    cfg.FullSyncInterval,
+		concurrency:         max(cfg.Concurrency, 1),
+		limiter:             newTokenBucket(cfg.APIRateLimit),
+		retryPolicy:         cfg.Retry,
+		quarantineThreshold: cfg.QuarantineThreshold,
+		guardrails:          cfg.Guardrails,
+		verbosity:           cfg.Verbosity,
+		now:                 time.Now,
+	}
+}
+
+// Run performs a single sync and records its outcome. When the source has a stored cursor and a
+// full reconcile is not yet due, only the users changed since the last successful run are
+// requested.
+//
+// A checkpoint is committed after each page. If ctx is cancelled or the node goes away mid-run,
+// the next call to Run resumes from the last committed page instead of starting over.
+//
+// When guardrails are configured, every page is fetched and planned before anything is written,
+// and the run is aborted with ErrGuardrailTripped if the planned changes exceed them.
+//
+// A run cancelled through the Canceller stops with ErrRunCancelled once the records in flight are
+// written. It is not resumed, and the cursor is left where it was.
+//
+// Records that fail to sync are retried on later runs rather than failing the run, and are
+// quarantined once they have failed QuarantineThreshold times. While any record is failing the
+// cursor is not advanced, so that incremental sources return it again. If the run itself fails,
+// the partial result is returned alongside the error.
+//
+// Every line logged during the run carries its run_id and source. An Engine performs one run at a
+// time.
+func (e *Engine) Run(ctx context.Context) (*Result, error) {
+	log := e.log
+	defer func() { e.log = log }()
+
+	start := e.now()
+	result, err := e.run(ctx)
+	if result != nil && errors.Is(err, ErrRunCancelled) {
+		e.discardCancelledRun(result)
+	}
+	if result == nil {
+		e.metrics.ObserveRun(kvstore.RunStatusFailed, e.now().Sub(start))
+		return nil, err
+	}
+
+	run := &kvstore.SyncRun{
+		ID:         result.RunID,
+		Source:     e.source.Name(),
+		Status:     kvstore.RunStatusSucceeded,
+		Full:       result.Full,
+		Resumed:    result.Resumed,
+		Stats:      result.SyncStats,
+		StartedAt:  result.StartedAt,
+		FinishedAt: e.now(),
+	}
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrRunCancelled):
+			run.Status = kvstore.RunStatusCancelled
+		case ctx.Err() != nil:
+			run.Status = kvstore.RunStatusInterrupted
+		case errors.Is(err, ErrGuardrailTripped):
+			run.Status = kvstore.RunStatusAborted
+		default:
+			run.Status = kvstore.RunStatusFailed
+		}
+		run.Error = err.Error()
+	}
+	e.metrics.ObserveRun(run.Status, run.FinishedAt.Sub(start))
+	if saveErr := e.store.SaveRun(run); saveErr != nil {
+		if err != nil {
+			return result, err
+		}
+		return result, saveErr
+	}
+
+	return result, err
+}
+
+func (e *Engine) run(ctx context.Context) (*Result, error) {
+	name := e.source.Name()
+	state, err := e.store.GetSyncCursor(name)
+	if err != nil {
+		return nil, err
+	}
+	checkpoint, err := e.store.GetActiveCheckpoint(name)
+	if err != nil {
+		return nil, err
+	}
+
+	now := e.now()
+	if checkpoint != nil && now.Sub(checkpoint.UpdatedAt) > checkpointMaxAge {
+		e.log.Warn("Discarding stale sync checkpoint", "run_id", checkpoint.RunID, "source", name, "updated_at", checkpoint.UpdatedAt)
+		if err = e.store.DeleteCheckpoint(checkpoint); err != nil {
+			return nil, err
+		}
+		checkpoint = nil
+	}
+
+	resumed := checkpoint != nil
+	if !resumed {
+		cursor := ""
+		if state != nil && !e.fullSyncDue(state, now) {
+			cursor = state.Cursor
+		}
+		checkpoint = &kvstore.SyncCheckpoint{
+			RunID:     model.NewId(),
+			Source:    name,
+			Cursor:    cursor,
+			StartedAt: now,
+		}
+	}
+
+	e.log = withFields(e.log, "run_id", checkpoint.RunID, "source", name)
+	e.canceller.RunStarted(checkpoint.RunID)
+	if resumed {
+		e.log.Info("Resuming interrupted sync run", "phase", phaseFetch, "fetched", checkpoint.Stats.Fetched)
+	} else {
+		e.log.Debug("Starting sync run", "phase", phaseFetch, "incremental", checkpoint.Cursor != "")
+	}
+
+	result := &Result{
+		RunID:     checkpoint.RunID,
+		Source:    name,
+		Resumed:   resumed,
+		StartedAt: checkpoint.StartedAt,
+	}
+
+	tracker, err := e.newFailureTracker(checkpoint.RunID)
+	if err != nil {
+		return result, err
+	}
+
+	// A fresh run with guardrails plans every page before writing anything, so that the whole
+	// change set can be checked. A resumed run already passed the check before it was interrupted.
+	guarded := !resumed && e.guardrails.enabled()
+
+	var (
+		pending    []*pagePlan
+		nextCursor string
+		seenFields = map[string]bool{}
+	)
+	for {
+		var page *FetchResult
+		err = e.retry(ctx, "fetch", func() error {
+			var fetchErr error
+			fetchStart := time.Now()
+			page, fetchErr = e.source.Fetch(ctx, checkpoint.Cursor, checkpoint.PageToken)
+			e.metrics.ObserveFetch(time.Since(fetchStart))
+			return fetchErr
+		})
+		if err != nil {
+			return result, errors.Wrapf(err, "failed to fetch from source %s", name)
+		}
+
+		e.log.Debug("Fetched source page", "phase", phaseFetch, "records", len(page.Records), "incremental", page.Incremental, "last_page", page.NextPageToken == "")
+		checkpoint.Full = !page.Incremental
+		checkpoint.Stats.Fetched += len(page.Records)
+		result.Full = checkpoint.Full
+		for _, record := range page.Records {
+			for field := range record.Fields {
+				seenFields[field] = true
+			}
+		}
+
+		updates, err := e.planPage(ctx, page.Records, tracker, &checkpoint.Stats)
+		result.SyncStats = checkpoint.Stats
+		if err != nil {
+			return result, err
+		}
+		pending = append(pending, &pagePlan{
+			nextPageToken: page.NextPageToken,
+			updates:       updates,
+		})
+		if page.NextPageToken == "" {
+			nextCursor = page.Cursor
+		}
+
+		if guarded {
+			if page.NextPageToken != "" {
+				// Later pages are fetched from the source without committing this one.
+				checkpoint.PageToken = page.NextPageToken
+				if err = e.checkCancelled(checkpoint.RunID); err != nil {
+					return result, err
+				}
+				continue
+			}
+			if err = e.guardrails.check(checkpoint.Full, checkpoint.Stats, pending); err != nil {
+				return result, err
+			}
+		}
+
+		// If interrupted, uncommitted pages are applied again when the run is resumed.
+		err = e.applyPages(ctx, checkpoint, pending, tracker)
+		result.SyncStats = checkpoint.Stats
+		if err != nil {
+			return result, err
+		}
+		pending = nil
+
+		if checkpoint.PageToken == "" {
+			break
+		}
+	}
+
+	next := &kvstore.SyncCursor{
+		Cursor:    nextCursor,
+		UpdatedAt: e.now(),
+	}
+
+	if err = e.checkCancelled(checkpoint.RunID); err != nil {
+		return result, err
+	}
+
+	var since time.Time
+	if state != nil {
+		since = state.EditsCheckedAt
+		next.EditsCheckedAt = state.EditsCheckedAt
+	}
+	// Checking for edits lists every active user, so it only runs along with full syncs, which
+	// read the whole directory anyway. Edits made in between are reverted by the next one.
+	if checkpoint.Full {
+		// Users are listed after this point, so edits made while the check runs are caught next time.
+		checkStarted := e.now()
+		complete, err := e.resetEditedAttributes(ctx, checkpoint.RunID, since, &checkpoint.Stats)
+		result.SyncStats = checkpoint.Stats
+		if err != nil {
+			return result, err
+		}
+		if complete {
+			next.EditsCheckedAt = checkStarted
+		}
+	}
+
+	if checkpoint.Stats.Failed > 0 {
+		// Keep the previous watermark so that the failed records are fetched again.
+		next.Cursor = checkpoint.Cursor
+	}
+	if checkpoint.Full {
+		next.LastFullSync = checkpoint.StartedAt
+	} else if state != nil {
+		next.LastFullSync = state.LastFullSync
+	}
+	if err = e.store.SaveSyncCursor(name, next); err != nil {
+		return result, err
+	}
+	if err = e.store.DeleteCheckpoint(checkpoint); err != nil {
+		return result, err
+	}
+	e.log.Debug("Committed sync cursor", "phase", phaseCommit, "kept_previous", checkpoint.Stats.Failed > 0)
+
+	if result.NewUnmappedFields, err = e.recordSourceFields(name, seenFields); err != nil {
+		return result, err
+	}
+
+	return result, nil
+}
+
+// recordSourceFields adds the fields seen during a run to those known for the source and returns
+// the newly seen ones that are not mapped to an attribute, sorted by name.
+func (e *Engine) recordSourceFields(source string, seen map[string]bool) ([]string, error) {
+	known, err := e.store.GetSourceFields(source)
+	if err != nil {
+		return nil, err
+	}
+	knownSet := make(map[string]bool, len(known))
+	for _, field := range known {
+		knownSet[field] = true
+	}
+	mapped := map[string]bool{}
+	for _, m := range e.mappings {
+		mapped[m.Source] = true
+	}
+
+	var added, unmapped []string
+	for field := range seen {
+		if knownSet[field] {
+			continue
+		}
+		added = append(added, field)
+		if !mapped[field] {
+			unmapped = append(unmapped, field)
+		}
+	}
+	if len(added) == 0 {
+		return nil, nil
+	}
+
+	if err = e.store.SaveSourceFields(source, append(known, added...)); err != nil {
+		return nil, err
+	}
+	sort.Strings(unmapped)
+	return unmapped, nil
+}
+
+// pagePlan holds the planned updates of a fetched page until they are applied.
+type pagePlan struct {
+	nextPageToken string
+	updates       []*plannedUpdate
+}
+
+// applyPages writes the planned updates page by page, committing a checkpoint after each page
+// that is followed by another.
+func (e *Engine) applyPages(ctx context.Context, checkpoint *kvstore.SyncCheckpoint, plans []*pagePlan, tracker *failureTracker) error {
+	for _, plan := range plans {
+		if err := e.applyUpdates(ctx, plan.updates, tracker, &checkpoint.Stats); err != nil {
+			return err
+		}
+
+		checkpoint.PageToken = plan.nextPageToken
+		if plan.nextPageToken == "" {
+			return nil
+		}
+		checkpoint.UpdatedAt = e.now()
+		if err := e.store.SaveCheckpoint(checkpoint); err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return errors.Wrap(err, "sync run interrupted")
+		}
+		if err := e.checkCancelled(checkpoint.RunID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *Engine) fullSyncDue(state *kvstore.SyncCursor, now time.Time) bool {
+	if state.Cursor == "" || e.fullSyncInterval <= 0 {
+		return true
+	}
+	return now.Sub(state.LastFullSync) >= e.fullSyncInterval
+}
+
+// recordOutcome describes what planning or applying a single record did.
+type recordOutcome int
+
+const (
+	outcomeUnmatched recordOutcome = iota
+	outcomeUnchanged
+	outcomePlanned
+	outcomeUpdated
+	outcomeFailed
+	outcomeQuarantined
+	outcomeExcluded
+)
+
+// MatchStrategy names how a source record was matched to a Mattermost user.
+type MatchStrategy string
+
+const (
+	MatchByEmail    MatchStrategy = "email"
+	MatchByUsername MatchStrategy = "username"
+)
+
+// plannedUpdate is a write the engine intends to make for a matched user.
+type plannedUpdate struct {
+	Record    Record
+	User      *model.User
+	MatchedBy MatchStrategy
+
+	// Changes holds the attributes to write. An empty value clears the attribute.
+	Changes map[string]string
+
+	// Owned holds the values of the user's source-owned attributes once the update is applied.
+	Owned map[string]string
+}
+
+// clears reports whether the update removes a value the user currently has.
+func (u *plannedUpdate) clears() bool {
+	for _, value := range u.Changes {
+		if value == "" {
+			return true
+		}
+	}
+	return false
+}
+
+// planRecord matches record to a user and works out which attributes need to change, retrying
+// transient errors and tracking the record's failure state. Failures of the record are reflected
+// in the outcome; only errors that should stop the run are returned. The update is returned
+// whenever a user was matched, but only needs to be applied if the outcome is outcomePlanned.
+func (e *Engine) planRecord(ctx context.Context, record Record, tracker *failureTracker) (*plannedUpdate, recordOutcome, error) {
+	key := RecordKey(record)
+	if e.exclude != nil {
+		excluded, err := e.exclude.Eval(recordValues(record))
+		if err != nil {
+			return nil, outcomeFailed, e.recordFailure(phasePlan, record, "", tracker, errors.Wrap(err, "failed to evaluate the exclude filter"))
+		}
+		if excluded {
+			e.logRecord(phasePlan, record, "", outcomeExcluded, nil)
+			return nil, outcomeExcluded, tracker.succeeded(key)
+		}
+	}
+	if tracker.quarantined(key) {
+		e.logRecord(phasePlan, record, "", outcomeQuarantined, nil)
+		return nil, outcomeQuarantined, nil
+	}
+
+	var update *plannedUpdate
+	err := e.retry(ctx, "plan_record", func() error {
+		var planErr error
+		update, planErr = e.diffRecord(ctx, record)
+		return planErr
+	})
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return nil, 0, ctxErr
+	}
+	if err != nil {
+		userID := ""
+		if update != nil {
+			userID = update.User.Id
+		}
+		return nil, outcomeFailed, e.recordFailure(phasePlan, record, userID, tracker, err)
+	}
+
+	switch {
+	case update == nil:
+		e.logRecord(phasePlan, record, "", outcomeUnmatched, nil)
+		return nil, outcomeUnmatched, tracker.succeeded(key)
+	case len(update.Changes) == 0:
+		e.logRecord(phasePlan, record, update.User.Id, outcomeUnchanged, nil)
+		if err = e.recordOwnedValues(update, false); err != nil {
+			return nil, 0, err
+		}
+		return update, outcomeUnchanged, tracker.succeeded(key)
+	default:
+		return update, outcomePlanned, nil
+	}
+}
+
+// diffRecord computes the update for record. It returns nil if no user matches, and an update
+// with no changes if the user is up to date. On error, the update is returned if a user was
+// matched so that the failure can be attributed to them.
+func (e *Engine) diffRecord(ctx context.Context, record Record) (*plannedUpdate, error) {
+	user, matchedBy, err := e.matchUser(ctx, record)
+	if err != nil || user == nil {
+		return nil, err
+	}
+	update := &plannedUpdate{
+		Record:    record,
+		User:      user,
+		MatchedBy: matchedBy,
+		Changes:   map[string]string{},
+		Owned:     map[string]string{},
+	}
+
+	current, err := e.attributes.GetAttributes(user)
+	if err != nil {
+		return update, errors.Wrapf(err, "failed to get attributes for user %s", user.Id)
+	}
+	values, err := applyMappings(e.mappings, record)
+	if err != nil {
+		// The record's values will not change by retrying.
+		return update, permanent(err)
+	}
+	userOwned := map[string]bool{}
+	for _, m := range e.mappings {
+		userOwned[m.Attribute] = m.userOwned()
+	}
+	for name, value := range values {
+		if userOwned[name] {
+			// User-owned attributes are only seeded, and never cleared.
+			if current[name] == "" && value != "" {
+				update.Changes[name] = value
+			}
+			continue
+		}
+
+		update.Owned[name] = value
+		if current[name] != value {
+			update.Changes[name] = value
+		}
+	}
+	return update, nil
+}
+
+// applyUpdate writes a planned update, retrying transient errors and tracking the record's
+// failure state.
+func (e *Engine) applyUpdate(ctx context.Context, update *plannedUpdate, tracker *failureTracker) (recordOutcome, error) {
+	err := e.retry(ctx, "apply_update", func() error {
+		if err := e.limiter.WaitN(ctx, writeCalls); err != nil {
+			return err
+		}
+		if err := e.attributes.SetAttributes(update.User, update.Changes); err != nil {
+			return errors.Wrapf(err, "failed to set attributes for user %s", update.User.Id)
+		}
+		e.metrics.ObserveWrite()
+		return nil
+	})
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return 0, ctxErr
+	}
+	if err != nil {
+		return outcomeFailed, e.recordFailure(phaseApply, update.Record, update.User.Id, tracker, err)
+	}
+	e.logRecord(phaseApply, update.Record, update.User.Id, outcomeUpdated, update.Changes)
+	if err = e.recordOwnedValues(update, true); err != nil {
+		return 0, err
+	}
+	return outcomeUpdated, tracker.succeeded(RecordKey(update.Record))
+}
+
+func (e *Engine) recordFailure(phase string, record Record, userID string, tracker *failureTracker, syncErr error) error {
+	failure, err := tracker.failed(record, userID, syncErr)
+	if err != nil {
+		return err
+	}
+	if failure.Quarantined {
+		e.log.Warn("Quarantined source record after repeated failures", "phase", phase, "key", failure.Key, "user_id", userID, "failures", failure.Failures, "err", syncErr)
+	} else {
+		e.log.Warn("Failed to sync source record", "phase", phase, "key", failure.Key, "user_id", userID, "failures", failure.Failures, "err", syncErr)
+	}
+	return nil
+}
+
+// matchUser finds the Mattermost user for record by email, falling back to username, and reports
+// which of the two matched. It returns nil if no user matches.
+func (e *Engine) matchUser(ctx context.Context, record Record) (*model.User, MatchStrategy, error) {
+	if record.Email != "" {
+		if err := e.limiter.Wait(ctx); err != nil {
+			return nil, "", err
+		}
+		user, err := e.users.GetByEmail(strings.ToLower(record.Email))
+		if err == nil {
+			return user, MatchByEmail, nil
+		}
+		if !errors.Is(err, pluginapi.ErrNotFound) {
+			return nil, "", errors.Wrapf(err, "failed to look up user by email for record %s", RecordKey(record))
+		}
+	}
+
+	if record.Username != "" {
+		if err := e.limiter.Wait(ctx); err != nil {
+			return nil, "", err
+		}
+		user, err := e.users.GetByUsername(strings.ToLower(record.Username))
+		if err == nil {
+			return user, MatchByUsername, nil
+		}
+		if !errors.Is(err, pluginapi.ErrNotFound) {
+			return nil, "", errors.Wrapf(err, "failed to look up user by username for record %s", RecordKey(record))
+		}
+	}
+
+	return nil, "", nil
+}
@@ -0,0 +1,132 @@
+package attrsync
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/pluginapi"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mattermost/mattermost-plugin-starter-template/server/dirgen"
+	"github.com/mattermost/mattermost-plugin-starter-template/server/fakeapi"
+	"github.com/mattermost/mattermost-plugin-starter-template/server/store/kvstore"
+)
+
+const benchmarkPageSize = 1000
+
+const benchmarkMappings = `[
+	{"source": "department", "attribute": "department"},
+	{"source": "title", "attribute": "title"},
+	{"source": "location", "attribute": "location"},
+	{"source": "manager", "attribute": "manager"},
+	{"source": "cost_center", "attribute": "cost_center"}
+]`
+
+// benchmarkDirectory serves generated snapshots to HTTPSource in pages linked by the Link header,
+// returning only the users updated after the since query parameter when it is set.
+type benchmarkDirectory struct {
+	mu      sync.Mutex
+	entries []map[string]string
+}
+
+func (d *benchmarkDirectory) set(users []dirgen.User) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.entries = d.entries[:0]
+	for _, user := range users {
+		d.entries = append(d.entries, user.Entry())
+	}
+}
+
+func (d *benchmarkDirectory) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	query := r.URL.Query()
+	since := query.Get("since")
+	offset, _ := strconv.Atoi(query.Get("offset"))
+
+	var page []map[string]string
+	matching := 0
+	for _, entry := range d.entries {
+		if since != "" && entry["updated_at"] <= since {
+			continue
+		}
+		if matching >= offset && len(page) < benchmarkPageSize {
+			page = append(page, entry)
+		}
+		matching++
+	}
+	if offset+benchmarkPageSize < matching {
+		query.Set("offset", strconv.Itoa(offset+benchmarkPageSize))
+		w.Header().Set("Link", fmt.Sprintf(`<?%s>; rel="next"`, query.Encode()))
+	}
+	_ = json.NewEncoder(w).Encode(page)
+}
+
+// BenchmarkEngineRun syncs generated directories into the in-memory plugin API. Full runs sync a
+// first snapshot into users without attributes; incremental runs sync the changes of the next
+// snapshot, in which 5% of the users changed and 1% were replaced.
+func BenchmarkEngineRun(b *testing.B) {
+	for _, users := range []int{1_000, 10_000, 100_000} {
+		b.Run(fmt.Sprintf("users=%d/full", users), func(b *testing.B) {
+			benchmarkEngineRun(b, users, false)
+		})
+		b.Run(fmt.Sprintf("users=%d/incremental", users), func(b *testing.B) {
+			benchmarkEngineRun(b, users, true)
+		})
+	}
+}
+
+func benchmarkEngineRun(b *testing.B, users int, incremental bool) {
+	directory := &benchmarkDirectory{}
+	server := httptest.NewServer(directory)
+	defer server.Close()
+
+	mappings, err := ParseMappings(benchmarkMappings)
+	require.NoError(b, err)
+
+	var fetched int
+	for range b.N {
+		b.StopTimer()
+		generator := dirgen.New(dirgen.Config{Users: users, Churn: 0.05, Turnover: 0.01, Seed: 1})
+		api := fakeapi.New()
+		for _, user := range generator.Users() {
+			api.AddUser(&model.User{Username: user.Username, Email: user.Email})
+		}
+		client := pluginapi.NewClient(api, nil)
+		engine := NewEngine(Config{
+			Source:           NewHTTPSource(server.URL, "", "since"),
+			Store:            kvstore.NewKVStore(client),
+			Users:            &client.User,
+			Attributes:       NewPropsAttributeStore(&client.User),
+			Log:              &client.Log,
+			Mappings:         mappings,
+			FullSyncInterval: 24 * time.Hour,
+			Concurrency:      4,
+		})
+
+		directory.set(generator.Users())
+		if incremental {
+			_, err = engine.Run(context.Background())
+			require.NoError(b, err)
+			generator.Next()
+			directory.set(generator.Users())
+		}
+		b.StartTimer()
+
+		result, err := engine.Run(context.Background())
+		require.NoError(b, err)
+		require.Equal(b, incremental, !result.Full)
+		fetched += result.Fetched
+	}
+	b.ReportMetric(float64(fetched)/b.Elapsed().Seconds(), "records/s")
+}
@@ -0,0 +1,993 @@
+package attrsync
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/pluginapi"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mattermost/mattermost-plugin-starter-template/server/expr"
+	"github.com/mattermost/mattermost-plugin-starter-template/server/store/kvstore"
+)
+
+type fakeSource struct {
+	results    []*FetchResult
+	cursors    []string
+	pageTokens []string
+
+	// onFetch, if set, is called before each fetch.
+	onFetch func(pageToken string)
+}
+
+func (s *fakeSource) Name() string { return "fake" }
+
+func (s *fakeSource) Fetch(ctx context.Context, cursor, pageToken string) (*FetchResult, error) {
+	if s.onFetch != nil {
+		s.onFetch(pageToken)
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	s.cursors = append(s.cursors, cursor)
+	s.pageTokens = append(s.pageTokens, pageToken)
+	result := s.results[0]
+	s.results = s.results[1:]
+	return result, nil
+}
+
+type fakeUsers struct {
+	mu    sync.Mutex
+	users map[string]*model.User
+}
+
+func newFakeUsers(users ...*model.User) *fakeUsers {
+	f := &fakeUsers{users: map[string]*model.User{}}
+	for _, u := range users {
+		f.users[u.Id] = u
+	}
+	return f
+}
+
+func (f *fakeUsers) List(options *model.UserGetOptions) ([]*model.User, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	ids := make([]string, 0, len(f.users))
+	for id := range f.users {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	start := min(options.Page*options.PerPage, len(ids))
+	end := min(start+options.PerPage, len(ids))
+	var users []*model.User
+	for _, id := range ids[start:end] {
+		users = append(users, f.users[id].DeepCopy())
+	}
+	return users, nil
+}
+
+func (f *fakeUsers) Get(userID string) (*model.User, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if u, ok := f.users[userID]; ok {
+		return u.DeepCopy(), nil
+	}
+	return nil, pluginapi.ErrNotFound
+}
+
+func (f *fakeUsers) GetByEmail(email string) (*model.User, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, u := range f.users {
+		if u.Email == email {
+			return u.DeepCopy(), nil
+		}
+	}
+	return nil, pluginapi.ErrNotFound
+}
+
+func (f *fakeUsers) GetByUsername(username string) (*model.User, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, u := range f.users {
+		if u.Username == username {
+			return u.DeepCopy(), nil
+		}
+	}
+	return nil, pluginapi.ErrNotFound
+}
+
+func (f *fakeUsers) Update(user *model.User) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.users[user.Id] = user.DeepCopy()
+	return nil
+}
+
+type fakeKVStore struct {
+	kvstore.KVStore
+	mu          sync.Mutex
+	owned       map[string]*kvstore.OwnedValues
+	cursors     map[string]*kvstore.SyncCursor
+	checkpoints map[string]kvstore.SyncCheckpoint
+	runs        map[string]*kvstore.SyncRun
+	failures    map[string]kvstore.UserFailure
+	fields      map[string][]string
+}
+
+func newFakeKVStore() *fakeKVStore {
+	return &fakeKVStore{
+		cursors:     map[string]*kvstore.SyncCursor{},
+		checkpoints: map[string]kvstore.SyncCheckpoint{},
+		runs:        map[string]*kvstore.SyncRun{},
+		failures:    map[string]kvstore.UserFailure{},
+		fields:      map[string][]string{},
+		owned:       map[string]*kvstore.OwnedValues{},
+	}
+}
+
+func (f *fakeKVStore) SaveRun(run *kvstore.SyncRun) error {
+	f.runs[run.ID] = run
+	return nil
+}
+
+func (f *fakeKVStore) ListUserFailures() ([]*kvstore.UserFailure, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var failures []*kvstore.UserFailure
+	for _, failure := range f.failures {
+		failures = append(failures, &failure)
+	}
+	return failures, nil
+}
+
+func (f *fakeKVStore) GetUserFailure(key string) (*kvstore.UserFailure, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	failure, ok := f.failures[key]
+	if !ok {
+		return nil, nil
+	}
+	return &failure, nil
+}
+
+func (f *fakeKVStore) SaveUserFailure(failure *kvstore.UserFailure) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.failures[failure.Key] = *failure
+	return nil
+}
+
+func (f *fakeKVStore) DeleteUserFailure(key string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.failures, key)
+	return nil
+}
+
+func (f *fakeKVStore) GetActiveCheckpoint(source string) (*kvstore.SyncCheckpoint, error) {
+	checkpoint, ok := f.checkpoints[source]
+	if !ok {
+		return nil, nil
+	}
+	return &checkpoint, nil
+}
+
+func (f *fakeKVStore) SaveCheckpoint(checkpoint *kvstore.SyncCheckpoint) error {
+	f.checkpoints[checkpoint.Source] = *checkpoint
+	return nil
+}
+
+func (f *fakeKVStore) DeleteCheckpoint(checkpoint *kvstore.SyncCheckpoint) error {
+	delete(f.checkpoints, checkpoint.Source)
+	return nil
+}
+
+func (f *fakeKVStore) GetSyncCursor(source string) (*kvstore.SyncCursor, error) {
+	return f.cursors[source], nil
+}
+
+func (f *fakeKVStore) SaveSyncCursor(source string, cursor *kvstore.SyncCursor) error {
+	f.cursors[source] = cursor
+	return nil
+}
+
+func (f *fakeKVStore) GetOwnedValues(userID string) (*kvstore.OwnedValues, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.owned[userID], nil
+}
+
+func (f *fakeKVStore) SaveOwnedValues(userID string, values *kvstore.OwnedValues) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.owned[userID] = values
+	return nil
+}
+
+func (f *fakeKVStore) GetSourceFields(source string) ([]string, error) {
+	return f.fields[source], nil
+}
+
+func (f *fakeKVStore) SaveSourceFields(source string, fields []string) error {
+	f.fields[source] = fields
+	return nil
+}
+
+type recordingMetrics struct {
+	mu      sync.Mutex
+	runs    []string
+	records map[string]int
+	writes  int
+	errors  map[string]int
+	fetches int
+}
+
+func newRecordingMetrics() *recordingMetrics {
+	return &recordingMetrics{records: map[string]int{}, errors: map[string]int{}}
+}
+
+func (m *recordingMetrics) ObserveRun(status string, _ time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.runs = append(m.runs, status)
+}
+
+func (m *recordingMetrics) ObserveFetch(time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.fetches++
+}
+
+func (m *recordingMetrics) ObserveRecord(outcome string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.records[outcome]++
+}
+
+func (m *recordingMetrics) ObserveWrite() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.writes++
+}
+
+func (m *recordingMetrics) ObserveError(operation string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.errors[operation]++
+}
+
+type logLine struct {
+	message string
+	fields  map[string]any
+}
+
+type recordingLogger struct {
+	mu    sync.Mutex
+	lines []logLine
+}
+
+func (l *recordingLogger) record(message string, keyValuePairs []any) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	fields := map[string]any{}
+	for i := 0; i+1 < len(keyValuePairs); i += 2 {
+		fields[keyValuePairs[i].(string)] = keyValuePairs[i+1]
+	}
+	l.lines = append(l.lines, logLine{message: message, fields: fields})
+}
+
+func (l *recordingLogger) Debug(message string, keyValuePairs ...any) {
+	l.record(message, keyValuePairs)
+}
+func (l *recordingLogger) Info(message string, keyValuePairs ...any) {
+	l.record(message, keyValuePairs)
+}
+func (l *recordingLogger) Warn(message string, keyValuePairs ...any) {
+	l.record(message, keyValuePairs)
+}
+func (l *recordingLogger) Error(message string, keyValuePairs ...any) {
+	l.record(message, keyValuePairs)
+}
+
+func (l *recordingLogger) find(message string) []logLine {
+	var lines []logLine
+	for _, line := range l.lines {
+		if line.message == message {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+type nopLogger struct{}
+
+func (nopLogger) Debug(string, ...any) {}
+func (nopLogger) Info(string, ...any)  {}
+func (nopLogger) Warn(string, ...any)  {}
+func (nopLogger) Error(string, ...any) {}
+
+func newTestEngine(source Source, store kvstore.KVStore, users UserService, fullSyncInterval time.Duration) *Engine {
+	return NewEngine(Config{
+		Source:     source,
+		Store:      store,
+		Users:      users,
+		Attributes: NewPropsAttributeStore(users),
+		Log:        nopLogger{},
+		Mappings: []FieldMapping{
+			{Source: "dept", Attribute: "department", Transform: "trim"},
+		},
+		FullSyncInterval:    fullSyncInterval,
+		Retry:               RetryPolicy{Attempts: 2},
+		QuarantineThreshold: 2,
+	})
+}
+
+func TestEngineRun(t *testing.T) {
+	t.Run("applies mapped attributes to matched users", func(t *testing.T) {
+		users := newFakeUsers(
+			&model.User{Id: "u1", Email: "alice@example.com", Username: "alice"},
+			&model.User{Id: "u2", Email: "bob@example.com", Username: "bob", Props: model.StringMap{"attr_department": "Sales"}},
+		)
+		source := &fakeSource{results: []*FetchResult{{
+			Records: []Record{
+				{ExternalID: "1", Email: "Alice@Example.com", Fields: map[string]string{"dept": " Engineering "}},
+				{ExternalID: "2", Username: "bob", Fields: map[string]string{"dept": "Sales"}},
+				{ExternalID: "3", Email: "nobody@example.com", Fields: map[string]string{"dept": "Legal"}},
+			},
+			Cursor: "c1",
+		}}}
+		engine := newTestEngine(source, newFakeKVStore(), users, 0)
+
+		result, err := engine.Run(context.Background())
+		require.NoError(t, err)
+		assert.True(t, result.Full)
+		assert.Equal(t, kvstore.SyncStats{Fetched: 3, Matched: 2, Updated: 1, Unmatched: 1}, result.SyncStats)
+		assert.Equal(t, "Engineering", users.users["u1"].Props["attr_department"])
+		assert.Equal(t, "Sales", users.users["u2"].Props["attr_department"])
+	})
+
+	t.Run("only applies conditional mappings to records satisfying them", func(t *testing.T) {
+		users := newFakeUsers(
+			&model.User{Id: "u1", Email: "alice@example.com", Props: model.StringMap{"attr_manager": "carol"}},
+			&model.User{Id: "u2", Email: "bob@example.com"},
+		)
+		source := &fakeSource{results: []*FetchResult{{
+			Records: []Record{
+				{Email: "alice@example.com", Fields: map[string]string{"status": "terminated", "manager": "dave"}},
+				{Email: "bob@example.com", Fields: map[string]string{"status": "active", "manager": "dave"}},
+			},
+		}}}
+		engine := newTestEngine(source, newFakeKVStore(), users, 0)
+		mappings, err := ParseMappings(`[{"source": "manager", "attribute": "manager", "when": "status == 'active'"}]`)
+		require.NoError(t, err)
+		engine.mappings = mappings
+
+		result, err := engine.Run(context.Background())
+		require.NoError(t, err)
+		assert.Equal(t, 1, result.Updated)
+		assert.Equal(t, "carol", users.users["u1"].Props["attr_manager"])
+		assert.Equal(t, "dave", users.users["u2"].Props["attr_manager"])
+	})
+
+	t.Run("skips records matching the exclude filter", func(t *testing.T) {
+		users := newFakeUsers(
+			&model.User{Id: "u1", Email: "alice@example.com"},
+			&model.User{Id: "u2", Email: "svc-build@example.com"},
+			&model.User{Id: "u3", Email: "carol@example.com"},
+		)
+		source := &fakeSource{results: []*FetchResult{{
+			Records: []Record{
+				{Email: "alice@example.com", Fields: map[string]string{"dept": "Engineering", "status": "active"}},
+				{Email: "svc-build@example.com", Fields: map[string]string{"dept": "Engineering", "status": "active"}},
+				{Email: "carol@example.com", Fields: map[string]string{"dept": "Sales", "status": "terminated"}},
+			},
+		}}}
+		engine := newTestEngine(source, newFakeKVStore(), users, 0)
+		exclude, err := expr.Compile(`status == "terminated" OR email matches "^svc-"`)
+		require.NoError(t, err)
+		engine.exclude = exclude
+
+		result, err := engine.Run(context.Background())
+		require.NoError(t, err)
+		assert.Equal(t, kvstore.SyncStats{Fetched: 3, Excluded: 2, Matched: 1, Updated: 1}, result.SyncStats)
+		assert.Equal(t, "Engineering", users.users["u1"].Props["attr_department"])
+		assert.Empty(t, users.users["u2"].Props["attr_department"])
+		assert.Empty(t, users.users["u3"].Props["attr_department"])
+	})
+
+	t.Run("reports metrics", func(t *testing.T) {
+		users := newFakeUsers(&model.User{Id: "u1", Email: "alice@example.com"})
+		source := &fakeSource{results: []*FetchResult{{
+			Records: []Record{
+				{Email: "alice@example.com", Fields: map[string]string{"dept": "Engineering"}},
+				{Email: "nobody@example.com", Fields: map[string]string{"dept": "Legal"}},
+			},
+		}}}
+		engine := newTestEngine(source, newFakeKVStore(), users, 0)
+		metrics := newRecordingMetrics()
+		engine.metrics = metrics
+
+		_, err := engine.Run(context.Background())
+		require.NoError(t, err)
+		assert.Equal(t, []string{kvstore.RunStatusSucceeded}, metrics.runs)
+		assert.Equal(t, 1, metrics.fetches)
+		assert.Equal(t, map[string]int{"updated": 1, "unmatched": 1}, metrics.records)
+		assert.Equal(t, 1, metrics.writes)
+		assert.Empty(t, metrics.errors)
+	})
+
+	t.Run("requests changes since the stored cursor until a full sync is due", func(t *testing.T) {
+		users := newFakeUsers()
+		store := newFakeKVStore()
+		source := &fakeSource{results: []*FetchResult{
+			{Cursor: "c1"},
+			{Cursor: "c2", Incremental: true},
+			{Cursor: "c3"},
+		}}
+		engine := newTestEngine(source, store, users, 24*time.Hour)
+		start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+		engine.now = func() time.Time { return start }
+		result, err := engine.Run(context.Background())
+		require.NoError(t, err)
+		assert.True(t, result.Full)
+
+		engine.now = func() time.Time { return start.Add(time.Hour) }
+		result, err = engine.Run(context.Background())
+		require.NoError(t, err)
+		assert.False(t, result.Full)
+		assert.Equal(t, start, store.cursors["fake"].LastFullSync)
+
+		engine.now = func() time.Time { return start.Add(25 * time.Hour) }
+		result, err = engine.Run(context.Background())
+		require.NoError(t, err)
+		assert.True(t, result.Full)
+
+		assert.Equal(t, []string{"", "c1", ""}, source.cursors)
+		assert.Equal(t, "c3", store.cursors["fake"].Cursor)
+		assert.Equal(t, start.Add(25*time.Hour), store.cursors["fake"].LastFullSync)
+	})
+
+	t.Run("reports unmapped source fields the first time they are seen", func(t *testing.T) {
+		store := newFakeKVStore()
+		source := &fakeSource{results: []*FetchResult{
+			{Records: []Record{{Email: "a@example.com", Fields: map[string]string{"dept": "Sales", "title": "Lead"}}}},
+			{Records: []Record{{Email: "a@example.com", Fields: map[string]string{"dept": "Sales", "title": "Lead", "office": "Berlin"}}}},
+		}}
+		engine := newTestEngine(source, store, newFakeUsers(), 0)
+
+		result, err := engine.Run(context.Background())
+		require.NoError(t, err)
+		assert.Equal(t, []string{"title"}, result.NewUnmappedFields)
+
+		result, err = engine.Run(context.Background())
+		require.NoError(t, err)
+		assert.Equal(t, []string{"office"}, result.NewUnmappedFields)
+		assert.ElementsMatch(t, []string{"dept", "title", "office"}, store.fields["fake"])
+	})
+}
+
+func TestEngineRunResume(t *testing.T) {
+	users := newFakeUsers(
+		&model.User{Id: "u1", Email: "alice@example.com"},
+		&model.User{Id: "u2", Email: "bob@example.com"},
+	)
+	store := newFakeKVStore()
+	source := &fakeSource{results: []*FetchResult{
+		{Records: []Record{{Email: "alice@example.com", Fields: map[string]string{"dept": "Engineering"}}}, NextPageToken: "p2"},
+		{Records: []Record{{Email: "bob@example.com", Fields: map[string]string{"dept": "Sales"}}}, Cursor: "c1"},
+	}}
+	engine := newTestEngine(source, store, users, 0)
+
+	// Simulate a deactivation while the second page is being requested.
+	ctx, cancel := context.WithCancel(context.Background())
+	source.onFetch = func(pageToken string) {
+		if pageToken == "p2" {
+			cancel()
+		}
+	}
+	_, err := engine.Run(ctx)
+	require.ErrorIs(t, err, context.Canceled)
+	require.Contains(t, store.checkpoints, "fake")
+	runID := store.checkpoints["fake"].RunID
+	assert.Equal(t, "p2", store.checkpoints["fake"].PageToken)
+	assert.Nil(t, store.cursors["fake"])
+
+	source.onFetch = nil
+	result, err := engine.Run(context.Background())
+	require.NoError(t, err)
+	assert.True(t, result.Resumed)
+	assert.Equal(t, runID, result.RunID)
+	assert.Equal(t, kvstore.SyncStats{Fetched: 2, Matched: 2, Updated: 2}, result.SyncStats)
+	assert.Equal(t, []string{"", "p2"}, source.pageTokens)
+	assert.Equal(t, "Sales", users.users["u2"].Props["attr_department"])
+	assert.Equal(t, "c1", store.cursors["fake"].Cursor)
+	assert.NotContains(t, store.checkpoints, "fake")
+}
+
+// fakeCanceller cancels every run once cancel is set, or once it has been asked more than
+// cancelAfter times if that is set.
+type fakeCanceller struct {
+	started     []string
+	cancel      bool
+	cancelAfter int
+	checks      int
+}
+
+func (c *fakeCanceller) RunStarted(runID string) {
+	c.started = append(c.started, runID)
+}
+
+func (c *fakeCanceller) CancelRequested(runID string) (bool, error) {
+	c.checks++
+	return c.cancel || (c.cancelAfter > 0 && c.checks > c.cancelAfter), nil
+}
+
+func TestEngineRunCancel(t *testing.T) {
+	newUsers := func() *fakeUsers {
+		return newFakeUsers(
+			&model.User{Id: "u1", Email: "alice@example.com"},
+			&model.User{Id: "u2", Email: "bob@example.com"},
+			&model.User{Id: "u3", Email: "carol@example.com"},
+		)
+	}
+	pages := func() []*FetchResult {
+		return []*FetchResult{
+			{Records: []Record{{Email: "alice@example.com", Fields: map[string]string{"dept": "Engineering"}}}, NextPageToken: "p2"},
+			{Records: []Record{{Email: "bob@example.com", Fields: map[string]string{"dept": "Sales"}}}, NextPageToken: "p3"},
+			{Records: []Record{{Email: "carol@example.com", Fields: map[string]string{"dept": "Legal"}}}, Cursor: "c1"},
+		}
+	}
+
+	t.Run("stops after the current page", func(t *testing.T) {
+		users := newUsers()
+		store := newFakeKVStore()
+		canceller := &fakeCanceller{}
+		source := &fakeSource{results: pages()}
+		source.onFetch = func(pageToken string) {
+			if pageToken == "p2" {
+				canceller.cancel = true
+			}
+		}
+		engine := newTestEngine(source, store, users, 0)
+		engine.canceller = canceller
+
+		result, err := engine.Run(context.Background())
+		require.ErrorIs(t, err, ErrRunCancelled)
+		assert.Equal(t, []string{result.RunID}, canceller.started)
+		assert.Equal(t, kvstore.RunStatusCancelled, store.runs[result.RunID].Status)
+		assert.Equal(t, kvstore.SyncStats{Fetched: 2, Matched: 2, Updated: 2}, result.SyncStats)
+		assert.Equal(t, "Sales", users.users["u2"].Props["attr_department"], "the page being worked on is finished")
+		assert.Empty(t, users.users["u3"].Props["attr_department"])
+		assert.Equal(t, []string{"", "p2"}, source.pageTokens)
+		assert.Empty(t, store.checkpoints, "cancelled runs are not resumed")
+		assert.Nil(t, store.cursors["fake"])
+	})
+
+	t.Run("stops within a single page", func(t *testing.T) {
+		var (
+			users   []*model.User
+			records []Record
+		)
+		for i := range 250 {
+			email := fmt.Sprintf("user%d@example.com", i)
+			users = append(users, &model.User{Id: fmt.Sprintf("u%d", i), Email: email})
+			records = append(records, Record{Email: email, Fields: map[string]string{"dept": "Engineering"}})
+		}
+		fake := newFakeUsers(users...)
+		store := newFakeKVStore()
+		engine := newTestEngine(&fakeSource{results: []*FetchResult{{Records: records, Cursor: "c1"}}}, store, fake, 0)
+		// Planning checks twice, at the 100th and 200th record, before the cancel is seen while
+		// applying.
+		canceller := &fakeCanceller{cancelAfter: 2}
+		engine.canceller = canceller
+
+		result, err := engine.Run(context.Background())
+		require.ErrorIs(t, err, ErrRunCancelled)
+		assert.Equal(t, kvstore.RunStatusCancelled, store.runs[result.RunID].Status)
+		assert.Equal(t, 100, result.Updated, "records already dispatched are written")
+		updated := 0
+		for _, user := range fake.users {
+			if user.Props["attr_department"] != "" {
+				updated++
+			}
+		}
+		assert.Equal(t, 100, updated)
+		assert.Empty(t, store.checkpoints)
+		assert.Nil(t, store.cursors["fake"])
+	})
+
+	t.Run("writes nothing while guardrails are checked", func(t *testing.T) {
+		users := newUsers()
+		store := newFakeKVStore()
+		engine := newTestEngine(&fakeSource{results: pages()}, store, users, 0)
+		engine.canceller = &fakeCanceller{cancel: true}
+		engine.guardrails = Guardrails{MinRecords: 1}
+
+		result, err := engine.Run(context.Background())
+		require.ErrorIs(t, err, ErrRunCancelled)
+		assert.Equal(t, kvstore.RunStatusCancelled, store.runs[result.RunID].Status)
+		assert.Zero(t, result.Updated)
+		for _, user := range users.users {
+			assert.Empty(t, user.Props["attr_department"])
+		}
+	})
+
+	t.Run("manual runs", func(t *testing.T) {
+		users := newUsers()
+		store := newFakeKVStore()
+		engine := newTestEngine(&fakeSource{results: pages()}, store, users, 0)
+		engine.canceller = &fakeCanceller{cancel: true}
+
+		result, err := engine.SyncUsers(context.Background(), []string{"u1"})
+		require.ErrorIs(t, err, ErrRunCancelled)
+		assert.Equal(t, kvstore.RunStatusCancelled, store.runs[result.RunID].Status)
+		assert.Empty(t, users.users["u1"].Props["attr_department"])
+	})
+}
+
+type failingUsers struct {
+	*fakeUsers
+	failEmail string
+	err       error
+
+	// failures is the number of times to fail before succeeding, or negative to always fail.
+	failures int
+	calls    int
+}
+
+func (f *failingUsers) GetByEmail(email string) (*model.User, error) {
+	if email == f.failEmail {
+		f.calls++
+		if f.failures < 0 || f.calls <= f.failures {
+			return nil, f.err
+		}
+	}
+	return f.fakeUsers.GetByEmail(email)
+}
+
+func TestEngineRunConcurrent(t *testing.T) {
+	users := newFakeUsers()
+	var records []Record
+	for i := range 100 {
+		email := fmt.Sprintf("user%d@example.com", i)
+		users.users[fmt.Sprint(i)] = &model.User{Id: fmt.Sprint(i), Email: email}
+		records = append(records, Record{Email: email, Fields: map[string]string{"dept": fmt.Sprint(i % 3)}})
+	}
+	records = append(records, Record{Email: "nobody@example.com"})
+
+	t.Run("syncs every record", func(t *testing.T) {
+		engine := newTestEngine(&fakeSource{results: []*FetchResult{{Records: records}}}, newFakeKVStore(), users, 0)
+		engine.concurrency = 8
+
+		result, err := engine.Run(context.Background())
+		require.NoError(t, err)
+		assert.Equal(t, kvstore.SyncStats{Fetched: 101, Matched: 100, Updated: 100, Unmatched: 1}, result.SyncStats)
+		assert.Equal(t, "2", users.users["5"].Props["attr_department"])
+	})
+
+	t.Run("records failing users without failing the run", func(t *testing.T) {
+		store := newFakeKVStore()
+		failing := &failingUsers{fakeUsers: users, failEmail: "user50@example.com", err: errors.New("connection reset"), failures: -1}
+		engine := newTestEngine(&fakeSource{results: []*FetchResult{{Records: records, Cursor: "c1"}}}, store, failing, 0)
+		engine.concurrency = 8
+
+		result, err := engine.Run(context.Background())
+		require.NoError(t, err)
+		assert.Equal(t, 1, result.Failed)
+		assert.Equal(t, 2, failing.calls)
+		assert.Contains(t, store.failures["email:user50@example.com"].LastError, "connection reset")
+		assert.Empty(t, store.cursors["fake"].Cursor)
+		assert.Equal(t, kvstore.RunStatusSucceeded, store.runs[result.RunID].Status)
+	})
+}
+
+func TestEngineRunFailures(t *testing.T) {
+	newUsers := func() *fakeUsers {
+		return newFakeUsers(&model.User{Id: "u1", Email: "alice@example.com"})
+	}
+	records := []Record{{ExternalID: "1", Email: "alice@example.com", Fields: map[string]string{"dept": "Engineering"}}}
+
+	t.Run("retries transient errors", func(t *testing.T) {
+		users := &failingUsers{fakeUsers: newUsers(), failEmail: "alice@example.com", err: errors.New("connection reset"), failures: 1}
+		engine := newTestEngine(&fakeSource{results: []*FetchResult{{Records: records}}}, newFakeKVStore(), users, 0)
+
+		result, err := engine.Run(context.Background())
+		require.NoError(t, err)
+		assert.Equal(t, 1, result.Updated)
+		assert.Equal(t, 2, users.calls)
+	})
+
+	t.Run("does not retry client errors", func(t *testing.T) {
+		users := &failingUsers{fakeUsers: newUsers(), failEmail: "alice@example.com", failures: -1,
+			err: model.NewAppError("GetUserByEmail", "app.user.invalid", nil, "", http.StatusBadRequest)}
+		engine := newTestEngine(&fakeSource{results: []*FetchResult{{Records: records}}}, newFakeKVStore(), users, 0)
+
+		result, err := engine.Run(context.Background())
+		require.NoError(t, err)
+		assert.Equal(t, 1, result.Failed)
+		assert.Equal(t, 1, users.calls)
+	})
+
+	t.Run("quarantines users failing repeatedly and clears recovered ones", func(t *testing.T) {
+		store := newFakeKVStore()
+		users := &failingUsers{fakeUsers: newUsers(), failEmail: "alice@example.com", err: errors.New("connection reset"), failures: -1}
+		source := &fakeSource{}
+		engine := newTestEngine(source, store, users, 0)
+
+		for run := 1; run <= 3; run++ {
+			source.results = []*FetchResult{{Records: records}}
+			result, err := engine.Run(context.Background())
+			require.NoError(t, err)
+			if run < 3 {
+				assert.Equal(t, 1, result.Failed, "run %d", run)
+			} else {
+				assert.Equal(t, 1, result.Quarantined, "run %d", run)
+			}
+		}
+		failure := store.failures["id:1"]
+		assert.True(t, failure.Quarantined)
+		assert.Equal(t, 2, failure.Failures)
+
+		// Once released and working again, the record syncs and its failure is cleared.
+		require.NoError(t, store.DeleteUserFailure("id:1"))
+		store.failures["id:1"] = kvstore.UserFailure{Key: "id:1", Failures: 1}
+		users.failures = 0
+		source.results = []*FetchResult{{Records: records}}
+		result, err := engine.Run(context.Background())
+		require.NoError(t, err)
+		assert.Equal(t, 1, result.Updated)
+		assert.NotContains(t, store.failures, "id:1")
+	})
+
+	t.Run("records failed runs", func(t *testing.T) {
+		store := newFakeKVStore()
+		engine := newTestEngine(&erroringSource{}, store, newUsers(), 0)
+
+		result, err := engine.Run(context.Background())
+		require.ErrorContains(t, err, "source unavailable")
+		run := store.runs[result.RunID]
+		require.NotNil(t, run)
+		assert.Equal(t, kvstore.RunStatusFailed, run.Status)
+		assert.Contains(t, run.Error, "source unavailable")
+	})
+}
+
+type erroringSource struct{}
+
+func (erroringSource) Name() string { return "erroring" }
+
+func (erroringSource) Fetch(context.Context, string, string) (*FetchResult, error) {
+	return nil, permanent(errors.New("source unavailable"))
+}
+
+func TestEngineRunGuardrails(t *testing.T) {
+	newUsers := func() *fakeUsers {
+		return newFakeUsers(
+			&model.User{Id: "u1", Email: "alice@example.com", Props: model.StringMap{"attr_department": "Sales"}},
+			&model.User{Id: "u2", Email: "bob@example.com", Props: model.StringMap{"attr_department": "Sales"}},
+			&model.User{Id: "u3", Email: "carol@example.com", Props: model.StringMap{"attr_department": "Sales"}},
+			&model.User{Id: "u4", Email: "dave@example.com", Props: model.StringMap{"attr_department": "Sales"}},
+		)
+	}
+	pages := func(departments ...string) []*FetchResult {
+		emails := []string{"alice@example.com", "bob@example.com", "carol@example.com", "dave@example.com"}
+		var results []*FetchResult
+		for i, dept := range departments {
+			result := &FetchResult{Records: []Record{{Email: emails[i], Fields: map[string]string{"dept": dept}}}}
+			if i < len(departments)-1 {
+				result.NextPageToken = fmt.Sprintf("p%d", i+1)
+			}
+			results = append(results, result)
+		}
+		return results
+	}
+
+	for name, tc := range map[string]struct {
+		guardrails Guardrails
+		pages      []*FetchResult
+		tripped    string
+	}{
+		"too few records": {
+			guardrails: Guardrails{MinRecords: 4},
+			pages:      pages("", "", ""),
+			tripped:    "source returned 3 records",
+		},
+		"too many cleared users": {
+			guardrails: Guardrails{MaxClearedUsers: 1},
+			pages:      pages("Sales", "", "Sales", ""),
+			tripped:    "2 users would have attributes cleared",
+		},
+		"too many changed users": {
+			guardrails: Guardrails{MaxChangedPercent: 50},
+			pages:      pages("Legal", "Legal", "Legal", "Sales"),
+			tripped:    "3 of 4 matched users (75.0%) would change",
+		},
+		"within thresholds": {
+			guardrails: Guardrails{MinRecords: 4, MaxClearedUsers: 1, MaxChangedPercent: 50},
+			pages:      pages("Legal", "", "Sales", "Sales"),
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			users := newUsers()
+			store := newFakeKVStore()
+			engine := newTestEngine(&fakeSource{results: tc.pages}, store, users, 0)
+			engine.guardrails = tc.guardrails
+
+			result, err := engine.Run(context.Background())
+			if tc.tripped == "" {
+				require.NoError(t, err)
+				assert.Equal(t, 2, result.Updated)
+				assert.Equal(t, "Legal", users.users["u1"].Props["attr_department"])
+				return
+			}
+
+			require.ErrorIs(t, err, ErrGuardrailTripped)
+			assert.ErrorContains(t, err, tc.tripped)
+			assert.Equal(t, kvstore.RunStatusAborted, store.runs[result.RunID].Status)
+			assert.Zero(t, result.Updated)
+			for _, user := range users.users {
+				assert.Equal(t, "Sales", user.Props["attr_department"])
+			}
+			assert.Empty(t, store.cursors)
+			assert.Empty(t, store.checkpoints)
+		})
+	}
+
+	t.Run("resumed runs are not checked again", func(t *testing.T) {
+		users := newUsers()
+		store := newFakeKVStore()
+		store.checkpoints["fake"] = kvstore.SyncCheckpoint{RunID: "run1", Source: "fake", PageToken: "p3", UpdatedAt: time.Now()}
+		engine := newTestEngine(&fakeSource{results: pages("", "", "", "")[2:]}, store, users, 0)
+		engine.guardrails = Guardrails{MinRecords: 4, MaxClearedUsers: 1}
+
+		result, err := engine.Run(context.Background())
+		require.NoError(t, err)
+		assert.True(t, result.Resumed)
+		assert.Equal(t, 2, result.Updated)
+	})
+}
+
+func TestEngineRunLogging(t *testing.T) {
+	newRun := func(verbosity Verbosity) (*Engine, *recordingLogger) {
+		users := newFakeUsers(
+			&model.User{Id: "u1", Email: "alice@example.com"},
+			&model.User{Id: "u2", Email: "bob@example.com", Props: model.StringMap{"attr_department": "Sales"}},
+		)
+		source := &fakeSource{results: []*FetchResult{{
+			Records: []Record{
+				{Email: "alice@example.com", Fields: map[string]string{"dept": "Engineering"}},
+				{Email: "bob@example.com", Fields: map[string]string{"dept": "Sales"}},
+				{Email: "nobody@example.com", Fields: map[string]string{"dept": "Legal"}},
+			},
+		}}}
+		engine := newTestEngine(source, newFakeKVStore(), users, 0)
+		log := &recordingLogger{}
+		engine.log = log
+		engine.verbosity = verbosity
+		return engine, log
+	}
+
+	t.Run("every line carries the run and source", func(t *testing.T) {
+		engine, log := newRun(VerbosityAll)
+
+		result, err := engine.Run(context.Background())
+		require.NoError(t, err)
+		require.NotEmpty(t, log.lines)
+		for _, line := range log.lines {
+			assert.Equal(t, result.RunID, line.fields["run_id"], line.message)
+			assert.Equal(t, "fake", line.fields["source"], line.message)
+			assert.NotEmpty(t, line.fields["phase"], line.message)
+		}
+		assert.Equal(t, log, engine.log, "the run's fields must not leak into later runs")
+	})
+
+	t.Run("changes verbosity only logs updated users", func(t *testing.T) {
+		engine, log := newRun(VerbosityChanges)
+
+		_, err := engine.Run(context.Background())
+		require.NoError(t, err)
+		lines := log.find("Synced source record")
+		require.Len(t, lines, 1)
+		assert.Equal(t, "u1", lines[0].fields["user_id"])
+		assert.Equal(t, phaseApply, lines[0].fields["phase"])
+		assert.Equal(t, map[string]string{"department": "Engineering"}, lines[0].fields["changes"])
+	})
+
+	t.Run("all verbosity logs every record", func(t *testing.T) {
+		engine, log := newRun(VerbosityAll)
+
+		_, err := engine.Run(context.Background())
+		require.NoError(t, err)
+		outcomes := map[string]any{}
+		for _, line := range log.find("Synced source record") {
+			outcomes[line.fields["key"].(string)] = line.fields["outcome"]
+		}
+		assert.Equal(t, map[string]any{
+			"email:alice@example.com":  "updated",
+			"email:bob@example.com":    "unchanged",
+			"email:nobody@example.com": "unmatched",
+		}, outcomes)
+	})
+
+	t.Run("no per-user output by default", func(t *testing.T) {
+		engine, log := newRun("")
+
+		_, err := engine.Run(context.Background())
+		require.NoError(t, err)
+		assert.Empty(t, log.find("Synced source record"))
+	})
+}
+
+func TestEngineRunOwnership(t *testing.T) {
+	users := newFakeUsers(
+		&model.User{Id: "u1", Email: "alice@example.com", Props: model.StringMap{"attr_pronouns": "she/her"}},
+		&model.User{Id: "u2", Email: "bob@example.com"},
+	)
+	store := newFakeKVStore()
+	source := &fakeSource{results: []*FetchResult{
+		{
+			Records: []Record{
+				{Email: "alice@example.com", Fields: map[string]string{"cost_center": "CC-1", "pronouns": "they/them"}},
+				{Email: "bob@example.com", Fields: map[string]string{"cost_center": "CC-2", "pronouns": "he/him"}},
+			},
+			Cursor: "c1",
+		},
+		{Cursor: "c2", Incremental: true},
+		{
+			Records: []Record{
+				{Email: "bob@example.com", Fields: map[string]string{"cost_center": "CC-2", "pronouns": "he/him"}},
+			},
+			Cursor: "c3",
+		},
+	}}
+	engine := newTestEngine(source, store, users, 24*time.Hour)
+	mappings, err := ParseMappings(`[
+		{"source": "cost_center", "attribute": "cost_center"},
+		{"source": "pronouns", "attribute": "pronouns", "ownership": "user"}
+	]`)
+	require.NoError(t, err)
+	engine.mappings = mappings
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	engine.now = func() time.Time { return start }
+	_, err = engine.Run(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "CC-1", users.users["u1"].Props["attr_cost_center"])
+	assert.Equal(t, "she/her", users.users["u1"].Props["attr_pronouns"], "user-owned values are not overwritten")
+	assert.Equal(t, "he/him", users.users["u2"].Props["attr_pronouns"], "empty user-owned values are seeded")
+	assert.Equal(t, map[string]string{"cost_center": "CC-1"}, store.owned["u1"].Values)
+
+	// Both users edit their attributes; the incremental run does not return their records.
+	edited := model.GetMillisForTime(start.Add(time.Hour))
+	users.users["u1"].Props["attr_cost_center"] = "CC-9"
+	users.users["u1"].UpdateAt = edited
+	users.users["u2"].Props["attr_pronouns"] = "they/them"
+	users.users["u2"].UpdateAt = edited
+
+	engine.now = func() time.Time { return start.Add(2 * time.Hour) }
+	result, err := engine.Run(context.Background())
+	require.NoError(t, err)
+	assert.Zero(t, result.Reset)
+	assert.Equal(t, "CC-9", users.users["u1"].Props["attr_cost_center"], "edits are only checked on full syncs")
+	assert.Equal(t, start, store.cursors["fake"].EditsCheckedAt)
+
+	// The next full sync no longer returns Alice's record, but still reverts her edit.
+	engine.now = func() time.Time { return start.Add(26 * time.Hour) }
+	result, err = engine.Run(context.Background())
+	require.NoError(t, err)
+	assert.True(t, result.Full)
+	assert.Equal(t, 1, result.Reset)
+	assert.Equal(t, "CC-1", users.users["u1"].Props["attr_cost_center"], "source-owned edits are reverted")
+	assert.Equal(t, "they/them", users.users["u2"].Props["attr_pronouns"], "user-owned edits are kept")
+	assert.Equal(t, start.Add(26*time.Hour), store.cursors["fake"].EditsCheckedAt)
+}
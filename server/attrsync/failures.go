@@ -0,0 +1,110 @@
+package attrsync
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mattermost/mattermost-plugin-starter-template/server/store/kvstore"
+)
+
+// RecordKey identifies a source record across runs, preferring the source's own ID.
+func RecordKey(record Record) string {
+	switch {
+	case record.ExternalID != "":
+		return "id:" + record.ExternalID
+	case record.Email != "":
+		return "email:" + strings.ToLower(record.Email)
+	default:
+		return "username:" + strings.ToLower(record.Username)
+	}
+}
+
+// failureTracker keeps the failure state of records during a run. It is loaded once at the start
+// of the run and written through to the KV store as records fail or recover.
+type failureTracker struct {
+	mu        sync.Mutex
+	store     kvstore.KVStore
+	runID     string
+	threshold int
+	failures  map[string]*kvstore.UserFailure
+	now       func() time.Time
+}
+
+func (e *Engine) newFailureTracker(runID string) (*failureTracker, error) {
+	failures, err := e.store.ListUserFailures()
+	if err != nil {
+		return nil, err
+	}
+
+	tracker := &failureTracker{
+		store:     e.store,
+		runID:     runID,
+		threshold: e.quarantineThreshold,
+		failures:  make(map[string]*kvstore.UserFailure, len(failures)),
+		now:       e.now,
+	}
+	for _, failure := range failures {
+		tracker.failures[failure.Key] = failure
+	}
+	return tracker, nil
+}
+
+// quarantined reports whether the record with key is to be skipped.
+func (t *failureTracker) quarantined(key string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	failure, ok := t.failures[key]
+	return ok && failure.Quarantined
+}
+
+// succeeded clears any failure previously recorded for key.
+func (t *failureTracker) succeeded(key string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if _, ok := t.failures[key]; !ok {
+		return nil
+	}
+	if err := t.store.DeleteUserFailure(key); err != nil {
+		return err
+	}
+	delete(t.failures, key)
+	return nil
+}
+
+// failed records that syncing record failed with syncErr, quarantining it once it has failed in
+// threshold consecutive runs.
+func (t *failureTracker) failed(record Record, userID string, syncErr error) (*kvstore.UserFailure, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	key := RecordKey(record)
+	now := t.now()
+	failure, ok := t.failures[key]
+	if !ok {
+		failure = &kvstore.UserFailure{
+			Key:           key,
+			FirstFailedAt: now,
+		}
+	}
+
+	// A resumed run may apply the same page twice; only count each run once.
+	if failure.LastRunID != t.runID {
+		failure.Failures++
+	}
+	failure.ExternalID = record.ExternalID
+	failure.Email = record.Email
+	failure.UserID = userID
+	failure.LastError = syncErr.Error()
+	failure.LastRunID = t.runID
+	failure.LastFailedAt = now
+	failure.Quarantined = t.threshold > 0 && failure.Failures >= t.threshold
+
+	if err := t.store.SaveUserFailure(failure); err != nil {
+		return nil, err
+	}
+	t.failures[key] = failure
+	return failure, nil
+}
@@ -0,0 +1,163 @@
+package attrsync
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/pluginapi"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mattermost/mattermost-plugin-starter-template/server/expr"
+	"github.com/mattermost/mattermost-plugin-starter-template/server/fakeapi"
+	"github.com/mattermost/mattermost-plugin-starter-template/server/store/kvstore"
+)
+
+var updateGolden = flag.Bool("update", false, "rewrite the plan.golden files of TestGolden")
+
+// goldenConfig is the config.json of a golden case.
+type goldenConfig struct {
+	Mappings json.RawMessage `json:"mappings"`
+	Exclude  string          `json:"exclude,omitempty"`
+}
+
+// goldenUser is an entry of the users.json of a golden case.
+type goldenUser struct {
+	Username   string            `json:"username"`
+	Email      string            `json:"email"`
+	Attributes map[string]string `json:"attributes,omitempty"`
+}
+
+// TestGolden plans a sync for every case directory under testdata/golden and compares the plan
+// with the case's plan.golden. A case directory holds:
+//
+//	source.json  the directory, as served to the HTTP source
+//	config.json  the field mappings and an optional exclude filter
+//	users.json   the Mattermost users and their current attributes
+//	plan.golden  the expected outcome of every source record, and the changes planned for it
+//
+// After an intended change in behavior, run the test with -update to rewrite the plans and review
+// their diff.
+func TestGolden(t *testing.T) {
+	dirs, err := filepath.Glob(filepath.Join("testdata", "golden", "*"))
+	require.NoError(t, err)
+	require.NotEmpty(t, dirs)
+
+	for _, dir := range dirs {
+		t.Run(filepath.Base(dir), func(t *testing.T) {
+			plan := planGoldenCase(t, dir)
+			path := filepath.Join(dir, "plan.golden")
+			if *updateGolden {
+				require.NoError(t, os.WriteFile(path, []byte(plan), 0o644))
+				return
+			}
+
+			expected, err := os.ReadFile(path)
+			require.NoError(t, err, "run with -update to create the plan")
+			assert.Equal(t, string(expected), plan)
+		})
+	}
+}
+
+// planGoldenCase plans every record of the case in dir, without applying anything, and formats
+// the plan.
+func planGoldenCase(t *testing.T, dir string) string {
+	t.Helper()
+
+	var config goldenConfig
+	readGoldenFile(t, dir, "config.json", &config)
+	mappings, err := ParseMappings(string(config.Mappings))
+	require.NoError(t, err)
+	var exclude *expr.Expression
+	if config.Exclude != "" {
+		exclude, err = expr.Compile(config.Exclude)
+		require.NoError(t, err)
+	}
+
+	api := fakeapi.New()
+	var users []goldenUser
+	readGoldenFile(t, dir, "users.json", &users)
+	for _, user := range users {
+		mmUser := &model.User{Username: user.Username, Email: user.Email}
+		for name, value := range user.Attributes {
+			mmUser.SetProp(attributePropPrefix+name, value)
+		}
+		api.AddUser(mmUser)
+	}
+
+	source := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeFile(w, r, filepath.Join(dir, "source.json"))
+	}))
+	defer source.Close()
+
+	client := pluginapi.NewClient(api, nil)
+	engine := NewEngine(Config{
+		Source:     NewHTTPSource(source.URL, "", ""),
+		Store:      kvstore.NewKVStore(client),
+		Users:      &client.User,
+		Attributes: NewPropsAttributeStore(&client.User),
+		Log:        &client.Log,
+		Mappings:   mappings,
+		Exclude:    exclude,
+		Retry:      RetryPolicy{Attempts: 1},
+	})
+
+	ctx := context.Background()
+	var records []Record
+	for pageToken := ""; ; {
+		page, err := engine.source.Fetch(ctx, "", pageToken)
+		require.NoError(t, err)
+		records = append(records, page.Records...)
+		if pageToken = page.NextPageToken; pageToken == "" {
+			break
+		}
+	}
+	tracker, err := engine.newFailureTracker("golden")
+	require.NoError(t, err)
+
+	var plan strings.Builder
+	for _, record := range records {
+		key := RecordKey(record)
+		update, outcome, err := engine.planRecord(ctx, record, tracker)
+		require.NoError(t, err)
+
+		switch {
+		case outcome == outcomeFailed:
+			failure, err := engine.store.GetUserFailure(key)
+			require.NoError(t, err)
+			fmt.Fprintf(&plan, "%s: failed: %s\n", key, failure.LastError)
+		case update == nil:
+			fmt.Fprintf(&plan, "%s: %s\n", key, outcome)
+		default:
+			fmt.Fprintf(&plan, "%s matched @%s by %s: %s\n", key, update.User.Username, update.MatchedBy, outcome)
+			current, err := engine.attributes.GetAttributes(update.User)
+			require.NoError(t, err)
+			names := make([]string, 0, len(update.Changes))
+			for name := range update.Changes {
+				names = append(names, name)
+			}
+			slices.Sort(names)
+			for _, name := range names {
+				fmt.Fprintf(&plan, "    %s: %q -> %q\n", name, current[name], update.Changes[name])
+			}
+		}
+	}
+	return plan.String()
+}
+
+func readGoldenFile(t *testing.T, dir, name string, v any) {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join(dir, name))
+	require.NoError(t, err)
+	require.NoError(t, json.Unmarshal(data, v), "invalid %s", name)
+}
@@ -0,0 +1,56 @@
+package attrsync
+
+import (
+	"github.com/pkg/errors"
+
+	"github.com/mattermost/mattermost-plugin-starter-template/server/store/kvstore"
+)
+
+// ErrGuardrailTripped is returned by Engine.Run when the planned changes exceed a guardrail.
+var ErrGuardrailTripped = errors.New("safety threshold exceeded")
+
+// Guardrails are thresholds that abort a run before anything is written, protecting against a
+// truncated or otherwise broken source wiping attributes en masse. Zero disables a threshold.
+type Guardrails struct {
+	// MaxChangedPercent is the largest share of matched users a full sync may update.
+	// Incremental syncs only return changed users, so they are not checked against it.
+	MaxChangedPercent float64
+
+	// MaxClearedUsers is the largest number of users a run may clear attribute values for.
+	MaxClearedUsers int
+
+	// MinRecords is the fewest records a full sync must receive from the source.
+	MinRecords int
+}
+
+func (g Guardrails) enabled() bool {
+	return g.MaxChangedPercent > 0 || g.MaxClearedUsers > 0 || g.MinRecords > 0
+}
+
+// check returns an error wrapping ErrGuardrailTripped if the planned run exceeds a threshold.
+func (g Guardrails) check(full bool, stats kvstore.SyncStats, plans []*pagePlan) error {
+	if full && g.MinRecords > 0 && stats.Fetched < g.MinRecords {
+		return errors.Wrapf(ErrGuardrailTripped, "source returned %d records, fewer than the minimum of %d", stats.Fetched, g.MinRecords)
+	}
+
+	changed, cleared := 0, 0
+	for _, plan := range plans {
+		for _, update := range plan.updates {
+			changed++
+			if update.clears() {
+				cleared++
+			}
+		}
+	}
+
+	if g.MaxClearedUsers > 0 && cleared > g.MaxClearedUsers {
+		return errors.Wrapf(ErrGuardrailTripped, "%d users would have attributes cleared, more than the maximum of %d", cleared, g.MaxClearedUsers)
+	}
+	if full && g.MaxChangedPercent > 0 && stats.Matched > 0 {
+		if percent := float64(changed) / float64(stats.Matched) * 100; percent > g.MaxChangedPercent {
+			return errors.Wrapf(ErrGuardrailTripped, "%d of %d matched users (%.1f%%) would change, more than the maximum of %.1f%%",
+				changed, stats.Matched, percent, g.MaxChangedPercent)
+		}
+	}
+	return nil
+}
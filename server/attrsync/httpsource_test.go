@@ -0,0 +1,119 @@
+package attrsync
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHTTPSourceFetch(t *testing.T) {
+	var requests []*http.Request
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r)
+		if r.Header.Get("If-None-Match") == `"v1"` && r.URL.Query().Get("since") == "" {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		_, _ = w.Write([]byte(`[
+			{"id": 1, "email": "alice@example.com", "username": "alice", "dept": "Engineering", "updated_at": "2024-01-02T00:00:00Z"},
+			{"id": 2, "email": "bob@example.com", "username": "bob", "dept": null, "updated_at": "2024-01-01T00:00:00Z"}
+		]`))
+	}))
+	defer server.Close()
+
+	t.Run("etag", func(t *testing.T) {
+		requests = nil
+		source := NewHTTPSource(server.URL, "secret", "")
+
+		result, err := source.Fetch(context.Background(), "", "")
+		require.NoError(t, err)
+		assert.False(t, result.Incremental)
+		require.Len(t, result.Records, 2)
+		assert.Equal(t, Record{
+			ExternalID: "1",
+			Email:      "alice@example.com",
+			Username:   "alice",
+			Fields:     map[string]string{"dept": "Engineering", "updated_at": "2024-01-02T00:00:00Z"},
+		}, result.Records[0])
+		assert.Equal(t, "Bearer secret", requests[0].Header.Get("Authorization"))
+
+		result, err = source.Fetch(context.Background(), result.Cursor, "")
+		require.NoError(t, err)
+		assert.True(t, result.Incremental)
+		assert.Empty(t, result.Records)
+	})
+
+	t.Run("since parameter", func(t *testing.T) {
+		requests = nil
+		source := NewHTTPSource(server.URL, "", "since")
+
+		result, err := source.Fetch(context.Background(), "", "")
+		require.NoError(t, err)
+		assert.False(t, result.Incremental)
+
+		result, err = source.Fetch(context.Background(), result.Cursor, "")
+		require.NoError(t, err)
+		assert.True(t, result.Incremental)
+		assert.Equal(t, "2024-01-02T00:00:00Z", requests[1].URL.Query().Get("since"))
+	})
+
+	t.Run("large numbers", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte(`[
+				{"id": 1234567, "email": "carol@example.com", "cc": 40012345, "badge": 9007199254740993, "rate": 0.25}
+			]`))
+		}))
+		defer server.Close()
+		source := NewHTTPSource(server.URL, "", "")
+
+		result, err := source.Fetch(context.Background(), "", "")
+		require.NoError(t, err)
+		require.Len(t, result.Records, 1)
+		assert.Equal(t, "1234567", result.Records[0].ExternalID)
+		assert.Equal(t, "id:1234567", RecordKey(result.Records[0]))
+		assert.Equal(t, map[string]string{"cc": "40012345", "badge": "9007199254740993", "rate": "0.25"}, result.Records[0].Fields)
+	})
+
+	t.Run("cursor from another endpoint", func(t *testing.T) {
+		requests = nil
+		source := NewHTTPSource(server.URL, "", "since")
+
+		result, err := source.Fetch(context.Background(), `{"url":"https://elsewhere.example.com","etag":"\"v1\"","since":"2024-01-01T00:00:00Z"}`, "")
+		require.NoError(t, err)
+		assert.False(t, result.Incremental)
+		assert.Empty(t, requests[0].Header.Get("If-None-Match"))
+		assert.Empty(t, requests[0].URL.Query().Get("since"))
+	})
+}
+
+func TestHTTPSourceFetchPages(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("page") == "" {
+			w.Header().Set("ETag", `"v2"`)
+			w.Header().Set("Link", `</users?page=2>; rel="next", </users?page=2>; rel="last"`)
+			_, _ = w.Write([]byte(`[{"id": 1, "updated_at": "2024-01-03T00:00:00Z"}]`))
+			return
+		}
+		_, _ = w.Write([]byte(`[{"id": 2, "updated_at": "2024-01-02T00:00:00Z"}]`))
+	}))
+	defer server.Close()
+	source := NewHTTPSource(server.URL+"/users", "", "since")
+
+	first, err := source.Fetch(context.Background(), "", "")
+	require.NoError(t, err)
+	require.Len(t, first.Records, 1)
+	assert.NotEmpty(t, first.NextPageToken)
+	assert.Empty(t, first.Cursor)
+
+	second, err := source.Fetch(context.Background(), "", first.NextPageToken)
+	require.NoError(t, err)
+	require.Len(t, second.Records, 1)
+	assert.Equal(t, "2", second.Records[0].ExternalID)
+	assert.Empty(t, second.NextPageToken)
+	assert.JSONEq(t, `{"url":"`+server.URL+`/users","etag":"\"v2\"","since":"2024-01-03T00:00:00Z"}`, second.Cursor)
+}
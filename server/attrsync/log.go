@@ -0,0 +1,89 @@
+package attrsync
+
+import "github.com/pkg/errors"
+
+// Verbosity controls how much per-user output a run logs.
+type Verbosity string
+
+const (
+	// VerbosityNone logs only failures of individual users.
+	VerbosityNone Verbosity = "none"
+
+	// VerbosityChanges also logs each user whose attributes are updated, with the new values.
+	VerbosityChanges Verbosity = "changes"
+
+	// VerbosityAll also logs every other source record, including unmatched, unchanged and
+	// quarantined ones.
+	VerbosityAll Verbosity = "all"
+)
+
+// ParseVerbosity parses the configured verbosity. An empty value means VerbosityNone.
+func ParseVerbosity(value string) (Verbosity, error) {
+	switch verbosity := Verbosity(value); verbosity {
+	case "":
+		return VerbosityNone, nil
+	case VerbosityNone, VerbosityChanges, VerbosityAll:
+		return verbosity, nil
+	default:
+		return "", errors.Errorf("unknown verbosity %q, expected none, changes or all", value)
+	}
+}
+
+// Phases of a run, reported in the phase field of its log lines.
+const (
+	phaseFetch  = "fetch"
+	phasePlan   = "plan"
+	phaseApply  = "apply"
+	phaseCommit = "commit"
+	phaseReset  = "reset"
+)
+
+// fieldLogger adds fixed key-value pairs to every line it logs.
+type fieldLogger struct {
+	log    Logger
+	fields []any
+}
+
+// withFields returns a Logger that adds keyValuePairs to every line logged through log.
+func withFields(log Logger, keyValuePairs ...any) Logger {
+	if l, ok := log.(*fieldLogger); ok {
+		return &fieldLogger{log: l.log, fields: append(l.fields[:len(l.fields):len(l.fields)], keyValuePairs...)}
+	}
+	return &fieldLogger{log: log, fields: keyValuePairs}
+}
+
+func (l *fieldLogger) Debug(message string, keyValuePairs ...any) {
+	l.log.Debug(message, l.with(keyValuePairs)...)
+}
+
+func (l *fieldLogger) Info(message string, keyValuePairs ...any) {
+	l.log.Info(message, l.with(keyValuePairs)...)
+}
+
+func (l *fieldLogger) Warn(message string, keyValuePairs ...any) {
+	l.log.Warn(message, l.with(keyValuePairs)...)
+}
+
+func (l *fieldLogger) Error(message string, keyValuePairs ...any) {
+	l.log.Error(message, l.with(keyValuePairs)...)
+}
+
+func (l *fieldLogger) with(keyValuePairs []any) []any {
+	return append(l.fields[:len(l.fields):len(l.fields)], keyValuePairs...)
+}
+
+// logRecord logs the outcome of a record if the engine's verbosity asks for it.
+func (e *Engine) logRecord(phase string, record Record, userID string, outcome recordOutcome, changes map[string]string) {
+	switch {
+	case e.verbosity == VerbosityAll:
+	case e.verbosity == VerbosityChanges && outcome == outcomeUpdated:
+	default:
+		return
+	}
+
+	keyValuePairs := []any{"phase", phase, "key", RecordKey(record), "user_id", userID, "outcome", outcome.String()}
+	if len(changes) > 0 {
+		keyValuePairs = append(keyValuePairs, "changes", changes)
+	}
+	e.log.Info("Synced source record", keyValuePairs...)
+}
@@ -0,0 +1,100 @@
+package attrsync
+
+import (
+	"context"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/pkg/errors"
+
+	"github.com/mattermost/mattermost-plugin-starter-template/server/store/kvstore"
+)
+
+// SyncUsers immediately syncs the users with userIDs, outside of the schedule. Their records are
+// found by reading the full directory and go through the same planning, failure tracking and
+// writes as in Run. Guardrails do not apply, and the source's cursor is left untouched.
+//
+// The run is recorded as a manual run, which does not replace the last scheduled run. If it is
+// cancelled while the directory is read, it stops with ErrRunCancelled before writing anything.
+func (e *Engine) SyncUsers(ctx context.Context, userIDs []string) (*Result, error) {
+	log := e.log
+	defer func() { e.log = log }()
+
+	result := &Result{
+		RunID:     model.NewId(),
+		Source:    e.source.Name(),
+		Full:      true,
+		StartedAt: e.now(),
+	}
+	e.log = withFields(e.log, "run_id", result.RunID, "source", result.Source)
+	e.canceller.RunStarted(result.RunID)
+	e.log.Debug("Starting manual sync run", "phase", phaseFetch, "users", len(userIDs))
+
+	err := e.syncUsers(ctx, userIDs, result)
+	run := &kvstore.SyncRun{
+		ID:         result.RunID,
+		Source:     result.Source,
+		Status:     kvstore.RunStatusSucceeded,
+		Full:       true,
+		Stats:      result.SyncStats,
+		Manual:     true,
+		UserIDs:    userIDs,
+		StartedAt:  result.StartedAt,
+		FinishedAt: e.now(),
+	}
+	if err != nil {
+		run.Status = kvstore.RunStatusFailed
+		switch {
+		case errors.Is(err, ErrRunCancelled):
+			run.Status = kvstore.RunStatusCancelled
+		case ctx.Err() != nil:
+			run.Status = kvstore.RunStatusInterrupted
+		}
+		run.Error = err.Error()
+	}
+	if saveErr := e.store.SaveRun(run); saveErr != nil && err == nil {
+		err = saveErr
+	}
+	return result, err
+}
+
+func (e *Engine) syncUsers(ctx context.Context, userIDs []string, result *Result) error {
+	users := make([]*model.User, 0, len(userIDs))
+	for _, userID := range userIDs {
+		if err := e.limiter.Wait(ctx); err != nil {
+			return err
+		}
+		user, err := e.users.Get(userID)
+		if err != nil {
+			return errors.Wrapf(err, "failed to get user %s", userID)
+		}
+		users = append(users, user)
+	}
+
+	found, err := e.findRecords(ctx, users)
+	if err != nil {
+		return err
+	}
+	records := make([]Record, 0, len(found))
+	for _, user := range users {
+		if match, ok := found[user.Id]; ok {
+			records = append(records, match.Record)
+		} else {
+			result.NotFound = append(result.NotFound, user.Id)
+		}
+	}
+	result.Fetched = len(records)
+	if e.cancelRequested(result.RunID) {
+		e.log.Info("Manual sync run cancelled", "phase", phasePlan, "fetched", result.Fetched)
+		return ErrRunCancelled
+	}
+
+	tracker, err := e.newFailureTracker(result.RunID)
+	if err != nil {
+		return err
+	}
+	updates, err := e.planPage(ctx, records, tracker, &result.SyncStats)
+	if err != nil {
+		return err
+	}
+	return e.applyUpdates(ctx, updates, tracker, &result.SyncStats)
+}
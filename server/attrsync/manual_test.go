@@ -0,0 +1,55 @@
+package attrsync
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mattermost/mattermost-plugin-starter-template/server/store/kvstore"
+)
+
+func TestEngineSyncUsers(t *testing.T) {
+	users := newFakeUsers(
+		&model.User{Id: "u1", Email: "alice@example.com", Username: "alice"},
+		&model.User{Id: "u2", Email: "bob@example.com", Username: "bob"},
+		&model.User{Id: "u3", Email: "carol@example.com", Username: "carol"},
+	)
+	source := &fakeSource{results: []*FetchResult{
+		{
+			Records: []Record{
+				{ExternalID: "1", Email: "alice@example.com", Fields: map[string]string{"dept": "Engineering"}},
+				{ExternalID: "2", Email: "bob@example.com", Fields: map[string]string{"dept": "Sales"}},
+			},
+			NextPageToken: "p2",
+		},
+		{
+			Records: []Record{
+				{ExternalID: "4", Email: "dave@example.com", Fields: map[string]string{"dept": "Legal"}},
+			},
+		},
+	}}
+	store := newFakeKVStore()
+	store.cursors["fake"] = &kvstore.SyncCursor{Cursor: "c1"}
+	engine := newTestEngine(source, store, users, 24*time.Hour)
+
+	result, err := engine.SyncUsers(context.Background(), []string{"u1", "u3"})
+	require.NoError(t, err)
+
+	assert.Equal(t, kvstore.SyncStats{Fetched: 1, Matched: 1, Updated: 1}, result.SyncStats)
+	assert.Equal(t, []string{"u3"}, result.NotFound)
+	assert.Equal(t, []string{"", ""}, source.cursors, "the full directory is read")
+	assert.Equal(t, "Engineering", users.users["u1"].Props["attr_department"])
+	assert.Empty(t, users.users["u2"].Props["attr_department"], "other users are not synced")
+	assert.Equal(t, map[string]string{"department": "Engineering"}, store.owned["u1"].Values)
+	assert.Equal(t, &kvstore.SyncCursor{Cursor: "c1"}, store.cursors["fake"], "the cursor is untouched")
+
+	run := store.runs[result.RunID]
+	require.NotNil(t, run)
+	assert.True(t, run.Manual)
+	assert.Equal(t, []string{"u1", "u3"}, run.UserIDs)
+	assert.Equal(t, kvstore.RunStatusSucceeded, run.Status)
+}
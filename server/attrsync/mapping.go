@@ -0,0 +1,130 @@
+package attrsync
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/mattermost/mattermost-plugin-starter-template/server/expr"
+)
+
+// FieldMapping copies a source field onto a Mattermost user attribute.
+type FieldMapping struct {
+	// Source is the name of the field in the source record.
+	Source string `json:"source"`
+
+	// Attribute is the name of the Mattermost attribute to write.
+	Attribute string `json:"attribute"`
+
+	// Transform optionally names a transform applied to the value before it is written.
+	Transform string `json:"transform,omitempty"`
+
+	// Ownership decides who has the last word on the attribute, see OwnershipSource and
+	// OwnershipUser. It defaults to OwnershipSource.
+	Ownership string `json:"ownership,omitempty"`
+
+	// When is an optional condition over the source record in the expr language, see
+	// recordValues. The attribute is left untouched for records that do not satisfy it.
+	When string `json:"when,omitempty"`
+
+	condition *expr.Expression
+}
+
+// Ownership modes of a mapped attribute.
+const (
+	// OwnershipSource attributes always hold the source's value. Edits made by users are reverted.
+	OwnershipSource = "source"
+
+	// OwnershipUser attributes are only populated from the source while they are empty, so that
+	// users may change them.
+	OwnershipUser = "user"
+)
+
+// userOwned reports whether the attribute is owned by the user.
+func (m FieldMapping) userOwned() bool {
+	return m.Ownership == OwnershipUser
+}
+
+var transforms = map[string]func(string) string{
+	"":      func(s string) string { return s },
+	"trim":  strings.TrimSpace,
+	"lower": strings.ToLower,
+	"upper": strings.ToUpper,
+}
+
+// ParseMappings decodes and validates a JSON array of field mappings.
+func ParseMappings(raw string) ([]FieldMapping, error) {
+	if strings.TrimSpace(raw) == "" {
+		return nil, nil
+	}
+
+	var mappings []FieldMapping
+	if err := json.Unmarshal([]byte(raw), &mappings); err != nil {
+		return nil, errors.Wrap(err, "failed to decode field mappings")
+	}
+
+	seen := map[string]bool{}
+	for i := range mappings {
+		m := &mappings[i]
+		if m.Source == "" || m.Attribute == "" {
+			return nil, errors.New("field mappings require both a source and an attribute")
+		}
+		if _, ok := transforms[m.Transform]; !ok {
+			return nil, errors.Errorf("unknown transform %q for attribute %s", m.Transform, m.Attribute)
+		}
+		if m.Ownership != "" && m.Ownership != OwnershipSource && m.Ownership != OwnershipUser {
+			return nil, errors.Errorf("unknown ownership %q for attribute %s", m.Ownership, m.Attribute)
+		}
+		if seen[m.Attribute] {
+			return nil, errors.Errorf("attribute %s is mapped more than once", m.Attribute)
+		}
+		seen[m.Attribute] = true
+		if m.When != "" {
+			condition, err := expr.Compile(m.When)
+			if err != nil {
+				return nil, errors.Wrapf(err, "invalid condition for attribute %s", m.Attribute)
+			}
+			m.condition = condition
+		}
+	}
+
+	return mappings, nil
+}
+
+// applyMappings computes the attribute values for record. Fields missing from the record map to
+// an empty value, which clears the attribute. Attributes whose condition the record does not
+// satisfy are omitted.
+func applyMappings(mappings []FieldMapping, record Record) (map[string]string, error) {
+	values := make(map[string]string, len(mappings))
+	var conditionValues map[string]string
+	for _, m := range mappings {
+		if m.condition != nil {
+			if conditionValues == nil {
+				conditionValues = recordValues(record)
+			}
+			ok, err := m.condition.Eval(conditionValues)
+			if err != nil {
+				return nil, errors.Wrapf(err, "failed to evaluate the condition for attribute %s", m.Attribute)
+			}
+			if !ok {
+				continue
+			}
+		}
+		values[m.Attribute] = transforms[m.Transform](record.Fields[m.Source])
+	}
+	return values, nil
+}
+
+// recordValues returns the values conditions on record are evaluated against: its fields, and its
+// id, email and username unless a field of the same name exists.
+func recordValues(record Record) map[string]string {
+	values := make(map[string]string, len(record.Fields)+3)
+	values["id"] = record.ExternalID
+	values["email"] = record.Email
+	values["username"] = record.Username
+	for name, value := range record.Fields {
+		values[name] = value
+	}
+	return values
+}
@@ -0,0 +1,51 @@
+package attrsync
+
+import "time"
+
+// Metrics receives measurements from sync runs. Implementations must be safe for concurrent use.
+type Metrics interface {
+	// ObserveRun records a finished run with one of the kvstore.RunStatus values.
+	ObserveRun(status string, duration time.Duration)
+
+	// ObserveFetch records the latency of a request to the source.
+	ObserveFetch(duration time.Duration)
+
+	// ObserveRecord records the final outcome of a source record in a run.
+	ObserveRecord(outcome string)
+
+	// ObserveWrite records an attribute update written to a user.
+	ObserveWrite()
+
+	// ObserveError records a failed attempt of an operation, whether or not it was retried.
+	ObserveError(operation string)
+}
+
+type nopMetrics struct{}
+
+func (nopMetrics) ObserveRun(string, time.Duration) {}
+func (nopMetrics) ObserveFetch(time.Duration)       {}
+func (nopMetrics) ObserveRecord(string)             {}
+func (nopMetrics) ObserveWrite()                    {}
+func (nopMetrics) ObserveError(string)              {}
+
+// String returns the label under which the outcome is reported in metrics.
+func (o recordOutcome) String() string {
+	switch o {
+	case outcomeUnmatched:
+		return "unmatched"
+	case outcomeUnchanged:
+		return "unchanged"
+	case outcomePlanned:
+		return "planned"
+	case outcomeUpdated:
+		return "updated"
+	case outcomeFailed:
+		return "failed"
+	case outcomeQuarantined:
+		return "quarantined"
+	case outcomeExcluded:
+		return "excluded"
+	default:
+		return "unknown"
+	}
+}
@@ -0,0 +1,129 @@
+package attrsync
+
+import (
+	"context"
+	"maps"
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/pkg/errors"
+
+	"github.com/mattermost/mattermost-plugin-starter-template/server/store/kvstore"
+)
+
+// usersPerPage is the page size used to list users.
+const usersPerPage = 200
+
+// recordOwnedValues remembers the source-owned values of the user of update, so that later edits
+// can be reverted. Unless written is set, the stored values are read first and only replaced if
+// they differ, which avoids a write for every unchanged user.
+func (e *Engine) recordOwnedValues(update *plannedUpdate, written bool) error {
+	if len(update.Owned) == 0 {
+		return nil
+	}
+	if !written {
+		stored, err := e.store.GetOwnedValues(update.User.Id)
+		if err != nil {
+			return err
+		}
+		if stored != nil && maps.Equal(stored.Values, update.Owned) {
+			return nil
+		}
+	}
+	return e.store.SaveOwnedValues(update.User.Id, &kvstore.OwnedValues{
+		Values:   update.Owned,
+		SyncedAt: e.now(),
+	})
+}
+
+// resetEditedAttributes reverts source-owned attributes that were changed outside the sync, e.g.
+// by the users themselves, to the values last written by the sync. Only users updated since
+// the given time are checked. Failures for individual users are logged and the user is checked
+// again on the next run; only errors that prevent the check are returned. The check stops with
+// ErrRunCancelled between pages of users if the run with runID is cancelled.
+//
+// Users cannot be listed by when they were updated, so every active user is listed and the rest
+// are skipped here, costing one request per usersPerPage users. Runs only check along with full
+// syncs to bound that cost.
+func (e *Engine) resetEditedAttributes(ctx context.Context, runID string, since time.Time, stats *kvstore.SyncStats) (bool, error) {
+	sinceMillis := model.GetMillisForTime(since)
+	complete := true
+	for page := 0; ; page++ {
+		if page > 0 {
+			if err := e.checkCancelled(runID); err != nil {
+				return false, err
+			}
+		}
+		if err := e.limiter.Wait(ctx); err != nil {
+			return false, err
+		}
+		var users []*model.User
+		err := e.retry(ctx, "list_users", func() error {
+			var listErr error
+			users, listErr = e.users.List(&model.UserGetOptions{Page: page, PerPage: usersPerPage, Active: true})
+			return listErr
+		})
+		if err != nil {
+			return false, errors.Wrap(err, "failed to list users")
+		}
+
+		for _, user := range users {
+			if user.UpdateAt < sinceMillis {
+				continue
+			}
+			reset, err := e.resetUser(ctx, user)
+			if err != nil {
+				if ctxErr := ctx.Err(); ctxErr != nil {
+					return false, ctxErr
+				}
+				e.log.Warn("Failed to reset edited attributes", "phase", phaseReset, "user_id", user.Id, "err", err)
+				complete = false
+				continue
+			}
+			if reset {
+				stats.Reset++
+			}
+		}
+
+		if len(users) < usersPerPage {
+			return complete, nil
+		}
+	}
+}
+
+// resetUser reverts the edited source-owned attributes of user, reporting whether any were.
+func (e *Engine) resetUser(ctx context.Context, user *model.User) (bool, error) {
+	owned, err := e.store.GetOwnedValues(user.Id)
+	if err != nil || owned == nil {
+		return false, err
+	}
+	current, err := e.attributes.GetAttributes(user)
+	if err != nil {
+		return false, err
+	}
+
+	// Attributes that are no longer mapped, or have since become user-owned, are left alone.
+	changes := map[string]string{}
+	for _, m := range e.mappings {
+		value, ok := owned.Values[m.Attribute]
+		if ok && !m.userOwned() && current[m.Attribute] != value {
+			changes[m.Attribute] = value
+		}
+	}
+	if len(changes) == 0 {
+		return false, nil
+	}
+
+	err = e.retry(ctx, "reset_attributes", func() error {
+		if err := e.limiter.WaitN(ctx, writeCalls); err != nil {
+			return err
+		}
+		return e.attributes.SetAttributes(user, changes)
+	})
+	if err != nil {
+		return false, err
+	}
+	e.metrics.ObserveWrite()
+	e.log.Info("Reset attributes edited outside the sync", "phase", phaseReset, "user_id", user.Id, "attributes", changes)
+	return true, nil
+}
@@ -0,0 +1,141 @@
+package attrsync
+
+import (
+	"context"
+	"sync"
+
+	"github.com/pkg/errors"
+
+	"github.com/mattermost/mattermost-plugin-starter-template/server/store/kvstore"
+)
+
+// forEach calls fn for each index below n using up to e.concurrency workers. The first error
+// stops the remaining indexes from being dispatched and is returned once in-flight calls have
+// finished. If ctx is cancelled, the interruption is reported instead.
+//
+// Every cancelCheckInterval indexes, forEach checks whether the run with runID was cancelled. If
+// it was, the remaining indexes are not dispatched and ErrRunCancelled is returned once in-flight
+// calls have finished, so that no record is left half-synced.
+func (e *Engine) forEach(ctx context.Context, n int, runID string, fn func(ctx context.Context, i int) error) error {
+	workerCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		firstErr error
+	)
+	queue := make(chan int)
+	for range min(e.concurrency, n) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range queue {
+				if err := fn(workerCtx, i); err != nil {
+					mu.Lock()
+					if firstErr == nil {
+						firstErr = err
+						cancel()
+					}
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+
+dispatch:
+	for i := range n {
+		if i > 0 && i%cancelCheckInterval == 0 {
+			if err := e.checkCancelled(runID); err != nil {
+				mu.Lock()
+				if firstErr == nil {
+					firstErr = err
+				}
+				mu.Unlock()
+				break dispatch
+			}
+		}
+		select {
+		case queue <- i:
+		case <-workerCtx.Done():
+			break dispatch
+		}
+	}
+	close(queue)
+	wg.Wait()
+
+	// Report the parent's cancellation rather than whichever error a worker saw as a result of it.
+	if err := ctx.Err(); err != nil {
+		return errors.Wrap(err, "sync run interrupted")
+	}
+	return firstErr
+}
+
+// planPage plans records in parallel, adding the outcomes to stats. It returns the updates to
+// apply, in the order of records.
+func (e *Engine) planPage(ctx context.Context, records []Record, tracker *failureTracker, stats *kvstore.SyncStats) ([]*plannedUpdate, error) {
+	var mu sync.Mutex
+	planned := make([]*plannedUpdate, len(records))
+	err := e.forEach(ctx, len(records), tracker.runID, func(ctx context.Context, i int) error {
+		update, outcome, err := e.planRecord(ctx, records[i], tracker)
+		if err != nil {
+			return err
+		}
+
+		if outcome != outcomePlanned {
+			e.metrics.ObserveRecord(outcome.String())
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		switch outcome {
+		case outcomeUnmatched:
+			stats.Unmatched++
+		case outcomeUnchanged:
+			stats.Matched++
+		case outcomePlanned:
+			stats.Matched++
+			planned[i] = update
+		case outcomeFailed:
+			stats.Failed++
+		case outcomeQuarantined:
+			stats.Quarantined++
+		case outcomeExcluded:
+			stats.Excluded++
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	updates := planned[:0]
+	for _, update := range planned {
+		if update != nil {
+			updates = append(updates, update)
+		}
+	}
+	return updates, nil
+}
+
+// applyUpdates writes updates in parallel, adding the outcomes to stats.
+func (e *Engine) applyUpdates(ctx context.Context, updates []*plannedUpdate, tracker *failureTracker, stats *kvstore.SyncStats) error {
+	var mu sync.Mutex
+	return e.forEach(ctx, len(updates), tracker.runID, func(ctx context.Context, i int) error {
+		outcome, err := e.applyUpdate(ctx, updates[i], tracker)
+		if err != nil {
+			return err
+		}
+
+		e.metrics.ObserveRecord(outcome.String())
+
+		mu.Lock()
+		defer mu.Unlock()
+		if outcome == outcomeFailed {
+			stats.Failed++
+		} else {
+			stats.Updated++
+		}
+		return nil
+	})
+}
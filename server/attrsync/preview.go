@@ -0,0 +1,184 @@
+package attrsync
+
+import (
+	"context"
+	"strings"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/pkg/errors"
+)
+
+// Preview describes what a sync would do for a single Mattermost user. Building it writes nothing.
+type Preview struct {
+	UserID string `json:"user_id"`
+
+	// Found reports whether the source has a record matched to the user. The remaining fields are
+	// only set if it does.
+	Found bool `json:"found"`
+
+	RecordKey    string            `json:"record_key,omitempty"`
+	MatchedBy    MatchStrategy     `json:"matched_by,omitempty"`
+	SourceFields map[string]string `json:"source_fields,omitempty"`
+
+	// Excluded and Quarantined report that the record is skipped by the sync, in which case no
+	// attribute would change.
+	Excluded    bool `json:"excluded"`
+	Quarantined bool `json:"quarantined"`
+
+	Attributes []AttributePreview `json:"attributes,omitempty"`
+}
+
+// AttributePreview describes a single mapped attribute of a Preview.
+type AttributePreview struct {
+	Attribute   string `json:"attribute"`
+	SourceField string `json:"source_field"`
+	Ownership   string `json:"ownership"`
+
+	// Applies is false if the record does not satisfy the mapping's condition, in which case the
+	// attribute is left untouched.
+	Applies bool `json:"applies"`
+
+	// SourceValue is the value of the source field after transforms.
+	SourceValue  string `json:"source_value"`
+	CurrentValue string `json:"current_value"`
+	NewValue     string `json:"new_value"`
+	Changed      bool   `json:"changed"`
+}
+
+// Preview works out what a sync would change for the user with userID. Sources cannot be queried
+// for a single user, so the full directory is read until the user's record is found.
+func (e *Engine) Preview(ctx context.Context, userID string) (*Preview, error) {
+	user, err := e.users.Get(userID)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to get user %s", userID)
+	}
+
+	preview := &Preview{UserID: user.Id}
+	found, err := e.findRecords(ctx, []*model.User{user})
+	if err != nil {
+		return nil, err
+	}
+	match, ok := found[user.Id]
+	if !ok {
+		return preview, nil
+	}
+	record := match.Record
+	preview.Found = true
+	preview.RecordKey = RecordKey(record)
+	preview.MatchedBy = match.MatchedBy
+	preview.SourceFields = record.Fields
+
+	if e.exclude != nil {
+		if preview.Excluded, err = e.exclude.Eval(recordValues(record)); err != nil {
+			return nil, errors.Wrap(err, "failed to evaluate the exclude filter")
+		}
+	}
+	failure, err := e.store.GetUserFailure(preview.RecordKey)
+	if err != nil {
+		return nil, err
+	}
+	preview.Quarantined = failure != nil && failure.Quarantined
+
+	current, err := e.attributes.GetAttributes(user)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to get attributes for user %s", user.Id)
+	}
+	values, err := applyMappings(e.mappings, record)
+	if err != nil {
+		return nil, err
+	}
+	changes := map[string]string{}
+	if !preview.Excluded && !preview.Quarantined {
+		update, diffErr := e.diffRecord(ctx, record)
+		if diffErr != nil {
+			return nil, diffErr
+		}
+		if update != nil {
+			changes = update.Changes
+		}
+	}
+
+	for _, m := range e.mappings {
+		value, applies := values[m.Attribute]
+		attribute := AttributePreview{
+			Attribute:    m.Attribute,
+			SourceField:  m.Source,
+			Ownership:    OwnershipSource,
+			Applies:      applies,
+			SourceValue:  value,
+			CurrentValue: current[m.Attribute],
+			NewValue:     current[m.Attribute],
+		}
+		if m.userOwned() {
+			attribute.Ownership = OwnershipUser
+		}
+		if newValue, ok := changes[m.Attribute]; ok {
+			attribute.NewValue = newValue
+			attribute.Changed = true
+		}
+		preview.Attributes = append(preview.Attributes, attribute)
+	}
+	return preview, nil
+}
+
+// matchedRecord is the source record the sync matches to a user.
+type matchedRecord struct {
+	Record    Record
+	MatchedBy MatchStrategy
+}
+
+// findRecords reads the full directory for the records the sync would match to users, keyed by
+// user ID. Users without a record are left out. Reading stops once every user is found.
+func (e *Engine) findRecords(ctx context.Context, users []*model.User) (map[string]matchedRecord, error) {
+	found := make(map[string]matchedRecord, len(users))
+	pageToken := ""
+	for {
+		var page *FetchResult
+		err := e.retry(ctx, "fetch", func() error {
+			var fetchErr error
+			page, fetchErr = e.source.Fetch(ctx, "", pageToken)
+			return fetchErr
+		})
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to fetch from source %s", e.source.Name())
+		}
+
+		for _, record := range page.Records {
+			user := candidateUser(record, users)
+			if user == nil {
+				continue
+			}
+			if _, ok := found[user.Id]; ok {
+				continue
+			}
+			// A record carrying the user's username may still be matched to another user by email.
+			matched, matchedBy, err := e.matchUser(ctx, record)
+			if err != nil {
+				return nil, err
+			}
+			if matched != nil && matched.Id == user.Id {
+				found[user.Id] = matchedRecord{Record: record, MatchedBy: matchedBy}
+			}
+		}
+
+		if page.NextPageToken == "" || len(found) == len(users) {
+			return found, nil
+		}
+		pageToken = page.NextPageToken
+	}
+}
+
+// candidateUser returns the user among users whose email or username record carries, if any.
+func candidateUser(record Record, users []*model.User) *model.User {
+	for _, user := range users {
+		if sameIdentity(record.Email, user.Email) || sameIdentity(record.Username, user.Username) {
+			return user
+		}
+	}
+	return nil
+}
+
+// sameIdentity reports whether a non-empty record value equals the user's, ignoring case.
+func sameIdentity(recordValue, userValue string) bool {
+	return recordValue != "" && strings.EqualFold(recordValue, userValue)
+}
@@ -0,0 +1,98 @@
+package attrsync
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mattermost/mattermost-plugin-starter-template/server/store/kvstore"
+)
+
+func TestEnginePreview(t *testing.T) {
+	newUsers := func() *fakeUsers {
+		return newFakeUsers(
+			&model.User{Id: "u1", Email: "alice@example.com", Username: "alice", Props: model.StringMap{"attr_department": "Sales", "attr_pronouns": "she/her"}},
+			&model.User{Id: "u2", Email: "bob@example.com", Username: "bob"},
+			&model.User{Id: "u3", Email: "carol@example.com", Username: "carol"},
+		)
+	}
+	newSource := func() *fakeSource {
+		return &fakeSource{results: []*FetchResult{
+			{
+				Records: []Record{
+					{ExternalID: "1", Email: "bob@example.com", Fields: map[string]string{"dept": "Support"}},
+				},
+				NextPageToken: "p2",
+			},
+			{
+				Records: []Record{
+					{ExternalID: "2", Email: "ALICE@example.com", Fields: map[string]string{"dept": " Engineering ", "pronouns": "they/them"}},
+					{ExternalID: "3", Email: "carol@corp.example.com", Username: "carol", Fields: map[string]string{"dept": "Legal"}},
+				},
+			},
+		}}
+	}
+	newEngine := func(source Source, store kvstore.KVStore, users UserService) *Engine {
+		engine := newTestEngine(source, store, users, 24*time.Hour)
+		mappings, err := ParseMappings(`[
+			{"source": "dept", "attribute": "department", "transform": "trim"},
+			{"source": "pronouns", "attribute": "pronouns", "ownership": "user"},
+			{"source": "dept", "attribute": "legal_team", "when": "dept == 'Legal'"}
+		]`)
+		require.NoError(t, err)
+		engine.mappings = mappings
+		return engine
+	}
+
+	t.Run("shows the changes a sync would make", func(t *testing.T) {
+		users := newUsers()
+		source := newSource()
+		preview, err := newEngine(source, newFakeKVStore(), users).Preview(context.Background(), "u1")
+		require.NoError(t, err)
+
+		assert.True(t, preview.Found)
+		assert.Equal(t, "id:2", preview.RecordKey)
+		assert.Equal(t, MatchByEmail, preview.MatchedBy)
+		assert.Equal(t, []string{"", "p2"}, source.pageTokens)
+		assert.Equal(t, []string{"", ""}, source.cursors, "the full directory is read")
+		assert.Equal(t, []AttributePreview{
+			{Attribute: "department", SourceField: "dept", Ownership: OwnershipSource, Applies: true, SourceValue: "Engineering", CurrentValue: "Sales", NewValue: "Engineering", Changed: true},
+			{Attribute: "pronouns", SourceField: "pronouns", Ownership: OwnershipUser, Applies: true, SourceValue: "they/them", CurrentValue: "she/her", NewValue: "she/her"},
+			{Attribute: "legal_team", SourceField: "dept", Ownership: OwnershipSource},
+		}, preview.Attributes)
+		assert.Equal(t, "Sales", users.users["u1"].Props["attr_department"], "nothing is written")
+	})
+
+	t.Run("reports username matches", func(t *testing.T) {
+		preview, err := newEngine(newSource(), newFakeKVStore(), newUsers()).Preview(context.Background(), "u3")
+		require.NoError(t, err)
+
+		assert.True(t, preview.Found)
+		assert.Equal(t, MatchByUsername, preview.MatchedBy)
+		assert.Equal(t, "Legal", preview.Attributes[2].NewValue)
+	})
+
+	t.Run("makes no changes for quarantined records", func(t *testing.T) {
+		store := newFakeKVStore()
+		store.failures["id:1"] = kvstore.UserFailure{Key: "id:1", Quarantined: true}
+		preview, err := newEngine(newSource(), store, newUsers()).Preview(context.Background(), "u2")
+		require.NoError(t, err)
+
+		assert.True(t, preview.Quarantined)
+		assert.False(t, preview.Attributes[0].Changed)
+		assert.Equal(t, "Support", preview.Attributes[0].SourceValue)
+	})
+
+	t.Run("reports users missing from the source", func(t *testing.T) {
+		users := newUsers()
+		users.users["u4"] = &model.User{Id: "u4", Email: "dave@example.com", Username: "dave"}
+		preview, err := newEngine(newSource(), newFakeKVStore(), users).Preview(context.Background(), "u4")
+		require.NoError(t, err)
+
+		assert.Equal(t, &Preview{UserID: "u4"}, preview)
+	})
+}
@@ -0,0 +1,80 @@
+package attrsync
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// tokenBucket limits the rate of Mattermost API calls made by a sync run. Tokens refill
+// continuously at rate per second up to burst, and each call consumes one.
+type tokenBucket struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+
+	// now is overridden in tests.
+	now func() time.Time
+}
+
+// newTokenBucket creates a limiter allowing rate calls per second. It returns nil, which never
+// blocks, if rate is not positive.
+func newTokenBucket(rate float64) *tokenBucket {
+	if rate <= 0 {
+		return nil
+	}
+	burst := max(rate, 1)
+	return &tokenBucket{
+		rate:   rate,
+		burst:  burst,
+		tokens: burst,
+		now:    time.Now,
+	}
+}
+
+// Wait blocks until a call is allowed or ctx is done.
+func (b *tokenBucket) Wait(ctx context.Context) error {
+	return b.WaitN(ctx, 1)
+}
+
+// WaitN blocks until n calls are allowed or ctx is done.
+func (b *tokenBucket) WaitN(ctx context.Context, n int) error {
+	if b == nil {
+		return ctx.Err()
+	}
+
+	delay := b.reserve(n)
+	if delay <= 0 {
+		return ctx.Err()
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// reserve takes n tokens and returns how long the caller must wait before using them. The token
+// balance may go negative, queueing callers behind each other.
+func (b *tokenBucket) reserve(n int) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := b.now()
+	if !b.last.IsZero() {
+		b.tokens = min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	}
+	b.last = now
+	b.tokens -= float64(n)
+
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
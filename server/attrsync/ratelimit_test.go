@@ -0,0 +1,51 @@
+package attrsync
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTokenBucket(t *testing.T) {
+	t.Run("unlimited", func(t *testing.T) {
+		bucket := newTokenBucket(0)
+		assert.Nil(t, bucket)
+		assert.NoError(t, bucket.Wait(context.Background()))
+	})
+
+	t.Run("queues callers beyond the burst", func(t *testing.T) {
+		now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+		bucket := newTokenBucket(2)
+		bucket.now = func() time.Time { return now }
+
+		assert.Equal(t, time.Duration(0), bucket.reserve(1))
+		assert.Equal(t, time.Duration(0), bucket.reserve(1))
+		assert.Equal(t, 500*time.Millisecond, bucket.reserve(1))
+		assert.Equal(t, time.Second, bucket.reserve(1))
+
+		// Once the queued callers have gone through, tokens accrue again.
+		now = now.Add(1500 * time.Millisecond)
+		assert.Equal(t, time.Duration(0), bucket.reserve(1))
+		assert.Equal(t, 500*time.Millisecond, bucket.reserve(1))
+	})
+
+	t.Run("takes a token per call", func(t *testing.T) {
+		now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+		bucket := newTokenBucket(2)
+		bucket.now = func() time.Time { return now }
+
+		assert.Equal(t, time.Duration(0), bucket.reserve(writeCalls))
+		assert.Equal(t, time.Second, bucket.reserve(writeCalls))
+	})
+
+	t.Run("honors cancellation", func(t *testing.T) {
+		bucket := newTokenBucket(0.001)
+		assert.NoError(t, bucket.Wait(context.Background()))
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		assert.ErrorIs(t, bucket.Wait(ctx), context.Canceled)
+	})
+}
@@ -0,0 +1,102 @@
+package attrsync
+
+import (
+	"context"
+	"math/rand/v2"
+	"net/http"
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/pkg/errors"
+)
+
+// RetryPolicy controls how transient failures are retried.
+type RetryPolicy struct {
+	// Attempts is the total number of tries, including the first. Values below one mean one.
+	Attempts int
+
+	// BaseDelay is the upper bound of the delay before the first retry. It doubles with every
+	// further retry, up to MaxDelay. The actual delay is picked at random below the bound so that
+	// concurrent workers do not retry in lockstep.
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+}
+
+// DefaultRetryPolicy is used when a Config does not specify one.
+var DefaultRetryPolicy = RetryPolicy{
+	Attempts:  3,
+	BaseDelay: 500 * time.Millisecond,
+	MaxDelay:  30 * time.Second,
+}
+
+// permanentError marks an error that retrying cannot fix.
+type permanentError struct {
+	error
+}
+
+func (e permanentError) Unwrap() error {
+	return e.error
+}
+
+// permanent wraps err so that it is not retried.
+func permanent(err error) error {
+	return permanentError{err}
+}
+
+// isRetryable reports whether err may succeed if the operation is tried again.
+func isRetryable(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	if errors.As(err, &permanentError{}) {
+		return false
+	}
+
+	// Client errors from the Mattermost API will not resolve themselves, except for throttling.
+	var appErr *model.AppError
+	if errors.As(err, &appErr) {
+		return appErr.StatusCode >= http.StatusInternalServerError || appErr.StatusCode == http.StatusTooManyRequests
+	}
+	return true
+}
+
+// delay returns the randomized delay before the given retry, counting from one.
+func (p RetryPolicy) delay(retry int) time.Duration {
+	bound := p.BaseDelay
+	for i := 1; i < retry && bound < p.MaxDelay; i++ {
+		bound *= 2
+	}
+	bound = min(bound, p.MaxDelay)
+	if bound <= 0 {
+		return 0
+	}
+	return rand.N(bound)
+}
+
+// retry calls fn until it succeeds, fails with an error that is not retryable, or the policy's
+// attempts are exhausted. The last error is returned.
+func (e *Engine) retry(ctx context.Context, operation string, fn func() error) error {
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil {
+			return nil
+		}
+		if ctx.Err() == nil {
+			e.metrics.ObserveError(operation)
+		}
+		if !isRetryable(err) || attempt >= e.retryPolicy.Attempts {
+			return err
+		}
+
+		delay := e.retryPolicy.delay(attempt)
+		e.log.Debug("Retrying after transient error", "operation", operation, "attempt", attempt, "delay", delay, "err", err)
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,44 @@
+package attrsync
+
+import "context"
+
+// Record is a single user entry as reported by an external directory.
+type Record struct {
+	// ExternalID is the identifier of the user in the source system.
+	ExternalID string
+	Email      string
+	Username   string
+
+	// Fields holds every other value the source reported for the user, keyed by source field name.
+	Fields map[string]string
+}
+
+// FetchResult is a single page returned by Source.Fetch.
+type FetchResult struct {
+	Records []Record
+
+	// NextPageToken is the token to fetch the following page with. It is empty on the last page.
+	NextPageToken string
+
+	// Cursor is the opaque watermark to hand back to the source on the next incremental fetch. It
+	// is only read from the last page. Sources that cannot fetch incrementally leave it empty.
+	Cursor string
+
+	// Incremental reports whether Records only contains the users changed since the requested
+	// cursor, as opposed to the full directory.
+	Incremental bool
+}
+
+// Source is an external directory that user attributes are synced from.
+type Source interface {
+	// Name identifies the source. It is used to key persisted sync state.
+	Name() string
+
+	// Fetch returns a page of the users changed since cursor. An empty cursor requests the full
+	// directory, and an empty pageToken requests the first page. Sources are free to ignore the
+	// cursor and return everything, in which case FetchResult.Incremental must be false.
+	//
+	// Page tokens must remain valid across plugin restarts, as interrupted runs are resumed from
+	// the last page token they committed.
+	Fetch(ctx context.Context, cursor, pageToken string) (*FetchResult, error)
+}
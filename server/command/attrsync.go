@@ -0,0 +1,231 @@
+package command
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/pluginapi"
+	"github.com/pkg/errors"
+)
+
+const attrSyncCommandTrigger = "attrsync"
+
+// previewTimeout bounds how long a preview may read the source, which it scans in full to find the
+// user's record, while the command waits for it.
+const previewTimeout = time.Minute
+
+func getAttrSyncAutocompleteData() *model.AutocompleteData {
+	attrSync := model.NewAutocompleteData(attrSyncCommandTrigger, "[command]", "Manage user attribute sync")
+
+	status := model.NewAutocompleteData("status", "", "Show the last sync run and the records failing to sync")
+	attrSync.AddCommand(status)
+
+	release := model.NewAutocompleteData("release", "[record key]", "Release a quarantined record so it is synced again")
+	release.AddTextArgument("Key of the record, as shown by status", "[record key]", "")
+	attrSync.AddCommand(release)
+
+	preview := model.NewAutocompleteData("preview", "[@username]", "Show what a sync would change for a user, without changing anything")
+	preview.AddTextArgument("User to preview", "[@username]", "")
+	attrSync.AddCommand(preview)
+
+	cancel := model.NewAutocompleteData("cancel", "", "Stop the running sync after its current page")
+	attrSync.AddCommand(cancel)
+
+	syncUsers := model.NewAutocompleteData("sync", "[@username|email|user ID]...", "Sync the given users now, outside of the schedule")
+	syncUsers.AddTextArgument("Users to sync, separated by spaces", "[@username|email|user ID]...", "")
+	attrSync.AddCommand(syncUsers)
+
+	return attrSync
+}
+
+func (c *Handler) executeAttrSyncCommand(args *model.CommandArgs) *model.CommandResponse {
+	if !c.client.User.HasPermissionTo(args.UserId, model.PermissionManageSystem) {
+		return ephemeralResponse("You must be a system admin to manage attribute sync.")
+	}
+
+	fields := strings.Fields(args.Command)
+	if len(fields) < 2 {
+		return ephemeralResponse("Please specify a command: status, release, preview, sync, cancel")
+	}
+
+	switch fields[1] {
+	case "status":
+		return c.executeAttrSyncStatus()
+	case "release":
+		if len(fields) < 3 {
+			return ephemeralResponse("Please specify the key of the record to release")
+		}
+		return c.executeAttrSyncRelease(fields[2])
+	case "preview":
+		if len(fields) < 3 {
+			return ephemeralResponse("Please specify the user to preview")
+		}
+		return c.executeAttrSyncPreview(fields[2])
+	case "sync":
+		if len(fields) < 3 {
+			return ephemeralResponse("Please specify the users to sync")
+		}
+		return c.executeAttrSyncUsers(fields[2:], args.UserId)
+	case "cancel":
+		return c.executeAttrSyncCancel(args.UserId)
+	default:
+		return ephemeralResponse(fmt.Sprintf("Unknown command: %s", fields[1]))
+	}
+}
+
+func (c *Handler) executeAttrSyncStatus() *model.CommandResponse {
+	running, err := c.syncer.RunningSync()
+	if err != nil {
+		c.client.Log.Error("Failed to get the running sync", "error", err)
+		return ephemeralResponse("Failed to get the sync status.")
+	}
+	run, err := c.kvstore.GetLastRun()
+	if err != nil {
+		c.client.Log.Error("Failed to get last sync run", "error", err)
+		return ephemeralResponse("Failed to get the sync status.")
+	}
+	failures, err := c.kvstore.ListUserFailures()
+	if err != nil {
+		c.client.Log.Error("Failed to list user failures", "error", err)
+		return ephemeralResponse("Failed to get the sync status.")
+	}
+
+	var sb strings.Builder
+	sb.WriteString("#### Attribute sync status\n")
+	if running != nil {
+		description := running.Description()
+		fmt.Fprintf(&sb, "%s is running on node `%s` since %s.", strings.ToUpper(description[:1])+description[1:], running.NodeID, running.StartedAt.UTC().Format("2006-01-02 15:04:05 MST"))
+		if running.RunID != "" {
+			sb.WriteString(" Use `/attrsync cancel` to stop it.")
+		}
+		sb.WriteString("\n")
+	}
+	if run == nil {
+		sb.WriteString("No sync has run yet.\n")
+	} else {
+		fmt.Fprintf(&sb, "Last run `%s` **%s** at %s.\n", run.ID, run.Status, run.FinishedAt.UTC().Format("2006-01-02 15:04:05 MST"))
+		fmt.Fprintf(&sb, "Fetched %d, excluded %d, matched %d, updated %d, unmatched %d, failed %d, quarantined %d, reset %d.\n",
+			run.Stats.Fetched, run.Stats.Excluded, run.Stats.Matched, run.Stats.Updated, run.Stats.Unmatched, run.Stats.Failed, run.Stats.Quarantined, run.Stats.Reset)
+		if run.Error != "" {
+			fmt.Fprintf(&sb, "Error: `%s`\n", run.Error)
+		}
+	}
+
+	if len(failures) > 0 {
+		sb.WriteString("\n##### Failing records\n")
+		sb.WriteString("| Record | User | Failures | Quarantined | Last error |\n|---|---|---|---|---|\n")
+		for _, failure := range failures {
+			fmt.Fprintf(&sb, "| `%s` | %s | %d | %t | %s |\n",
+				failure.Key, failure.UserID, failure.Failures, failure.Quarantined, strings.ReplaceAll(failure.LastError, "|", "\\|"))
+		}
+	}
+
+	return ephemeralResponse(sb.String())
+}
+
+func (c *Handler) executeAttrSyncRelease(key string) *model.CommandResponse {
+	failure, err := c.kvstore.GetUserFailure(key)
+	if err != nil {
+		c.client.Log.Error("Failed to get user failure", "key", key, "error", err)
+		return ephemeralResponse("Failed to release the record.")
+	}
+	if failure == nil {
+		return ephemeralResponse(fmt.Sprintf("No failing record with key `%s`.", key))
+	}
+	if err = c.kvstore.DeleteUserFailure(key); err != nil {
+		c.client.Log.Error("Failed to delete user failure", "key", key, "error", err)
+		return ephemeralResponse("Failed to release the record.")
+	}
+	return ephemeralResponse(fmt.Sprintf("Released `%s`. It will be synced on the next full sync.", key))
+}
+
+func (c *Handler) executeAttrSyncPreview(username string) *model.CommandResponse {
+	user, err := c.client.User.GetByUsername(strings.TrimPrefix(username, "@"))
+	if err != nil {
+		if errors.Is(err, pluginapi.ErrNotFound) {
+			return ephemeralResponse(fmt.Sprintf("No user named `%s`.", username))
+		}
+		c.client.Log.Error("Failed to get user", "username", username, "error", err)
+		return ephemeralResponse("Failed to preview the sync.")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), previewTimeout)
+	defer cancel()
+	preview, err := c.syncer.PreviewUserSync(ctx, user.Id)
+	if errors.Is(err, context.DeadlineExceeded) {
+		c.client.Log.Warn("User sync preview timed out", "user_id", user.Id, "timeout", previewTimeout.String())
+		return ephemeralResponse(fmt.Sprintf("The preview took longer than %s to find the user in the source. Try again once the source is less busy.", previewTimeout))
+	}
+	if err != nil {
+		c.client.Log.Error("Failed to preview user sync", "user_id", user.Id, "error", err)
+		return ephemeralResponse(fmt.Sprintf("Failed to preview the sync: %s", err.Error()))
+	}
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "#### Attribute sync preview for @%s\n", user.Username)
+	if !preview.Found {
+		sb.WriteString("The source has no record matching this user by email or username.\n")
+		return ephemeralResponse(sb.String())
+	}
+	fmt.Fprintf(&sb, "Source record `%s` matched by **%s**.\n", preview.RecordKey, preview.MatchedBy)
+	switch {
+	case preview.Excluded:
+		sb.WriteString("The record is excluded by the source exclude filter, so nothing would change.\n")
+	case preview.Quarantined:
+		sb.WriteString("The record is quarantined, so nothing would change until it is released.\n")
+	}
+
+	sb.WriteString("\n| Attribute | Source field | Ownership | Source value | Current value | New value |\n|---|---|---|---|---|---|\n")
+	for _, attribute := range preview.Attributes {
+		sourceValue := tableValue(attribute.SourceValue)
+		if !attribute.Applies {
+			sourceValue = "_condition not met_"
+		}
+		newValue := "_unchanged_"
+		if attribute.Changed {
+			newValue = "**" + tableValue(attribute.NewValue) + "**"
+		}
+		fmt.Fprintf(&sb, "| %s | %s | %s | %s | %s | %s |\n",
+			attribute.Attribute, attribute.SourceField, attribute.Ownership, sourceValue, tableValue(attribute.CurrentValue), newValue)
+	}
+	return ephemeralResponse(sb.String())
+}
+
+func (c *Handler) executeAttrSyncUsers(identifiers []string, userID string) *model.CommandResponse {
+	runID, err := c.syncer.StartUserSync(identifiers, userID)
+	if err != nil {
+		c.client.Log.Error("Failed to sync users", "users", identifiers, "error", err)
+		return ephemeralResponse(fmt.Sprintf("Failed to sync the users: %s", err.Error()))
+	}
+	return ephemeralResponse(fmt.Sprintf("Started manual sync run `%s`. You will get a direct message when it finishes.", runID))
+}
+
+func (c *Handler) executeAttrSyncCancel(userID string) *model.CommandResponse {
+	running, err := c.syncer.CancelSync("", userID)
+	if err != nil {
+		c.client.Log.Error("Failed to cancel sync", "error", err)
+		return ephemeralResponse("Failed to cancel the sync.")
+	}
+	if running == nil {
+		return ephemeralResponse("No sync is running.")
+	}
+	return ephemeralResponse(fmt.Sprintf("Asked the %s sync run `%s` on node `%s` to stop. It stops after the page it is working on and is recorded as cancelled.",
+		running.Trigger, running.RunID, running.NodeID))
+}
+
+// tableValue formats an attribute value for a markdown table cell.
+func tableValue(value string) string {
+	if value == "" {
+		return "_empty_"
+	}
+	return "`" + strings.ReplaceAll(value, "|", "\\|") + "`"
+}
+
+func ephemeralResponse(text string) *model.CommandResponse {
+	return &model.CommandResponse{
+		ResponseType: model.CommandResponseTypeEphemeral,
+		Text:         text,
+	}
+}
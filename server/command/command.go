@@ -1,26 +1,49 @@
 package command
 
 import (
+	"context"
 	"fmt"
 	"strings"
 
 	"github.com/mattermost/mattermost/server/public/model"
 	"github.com/mattermost/mattermost/server/public/pluginapi"
+
+	"github.com/mattermost/mattermost-plugin-starter-template/server/attrsync"
+	"github.com/mattermost/mattermost-plugin-starter-template/server/store/kvstore"
 )
 
 type Handler struct {
-	client *pluginapi.Client
+	client  *pluginapi.Client
+	kvstore kvstore.KVStore
+	syncer  Syncer
+}
+
+// Syncer runs on-demand sync operations. The plugin implements it.
+type Syncer interface {
+	PreviewUserSync(ctx context.Context, userID string) (*attrsync.Preview, error)
+	// StartUserSync starts syncing the users identified by ID, email or username in the
+	// background and returns the ID of the run. requesterID is sent a direct message with its
+	// outcome.
+	StartUserSync(identifiers []string, requesterID string) (string, error)
+
+	// RunningSync returns the holder of the cluster-wide sync lock, or nil if no sync is running.
+	RunningSync() (*kvstore.SyncLock, error)
+	// CancelSync asks the running sync, or only the run with runID if it is not empty, to stop
+	// after its current page. It returns the holder of the sync lock, or nil if no matching sync
+	// is running.
+	CancelSync(runID, userID string) (*kvstore.SyncLock, error)
 }
 
 type Command interface {
 	Handle(args *model.CommandArgs) (*model.CommandResponse, error)
 	executeHelloCommand(args *model.CommandArgs) *model.CommandResponse
+	executeAttrSyncCommand(args *model.CommandArgs) *model.CommandResponse
 }
 
 const helloCommandTrigger = "hello"
 
 // Register all your slash commands in the NewCommandHandler function.
-func NewCommandHandler(client *pluginapi.Client) Command {
+func NewCommandHandler(client *pluginapi.Client, store kvstore.KVStore, syncer Syncer) Command {
 	err := client.SlashCommand.Register(&model.Command{
 		Trigger:          helloCommandTrigger,
 		AutoComplete:     true,
@@ -31,8 +54,20 @@ func NewCommandHandler(client *pluginapi.Client) Command {
 	if err != nil {
 		client.Log.Error("Failed to register command", "error", err)
 	}
+	err = client.SlashCommand.Register(&model.Command{
+		Trigger:          attrSyncCommandTrigger,
+		AutoComplete:     true,
+		AutoCompleteDesc: "Manage user attribute sync",
+		AutoCompleteHint: "[command]",
+		AutocompleteData: getAttrSyncAutocompleteData(),
+	})
+	if err != nil {
+		client.Log.Error("Failed to register command", "error", err)
+	}
 	return &Handler{
-		client: client,
+		client:  client,
+		kvstore: store,
+		syncer:  syncer,
 	}
 }
 
@@ -42,6 +77,8 @@ func (c *Handler) Handle(args *model.CommandArgs) (*model.CommandResponse, error
 	switch trigger {
 	case helloCommandTrigger:
 		return c.executeHelloCommand(args), nil
+	case attrSyncCommandTrigger:
+		return c.executeAttrSyncCommand(args), nil
 	default:
 		return &model.CommandResponse{
 			ResponseType: model.CommandResponseTypeEphemeral,
@@ -1,12 +1,19 @@
 package command
 
 import (
+	"context"
 	"testing"
+	"time"
 
 	"github.com/mattermost/mattermost/server/public/model"
 	"github.com/mattermost/mattermost/server/public/plugin/plugintest"
 	"github.com/mattermost/mattermost/server/public/pluginapi"
+	"github.com/pkg/errors"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	"github.com/mattermost/mattermost-plugin-starter-template/server/attrsync"
+	"github.com/mattermost/mattermost-plugin-starter-template/server/store/kvstore"
 )
 
 type env struct {
@@ -36,7 +43,10 @@ func TestHelloCommand(t *testing.T) {
 		AutoCompleteHint: "[@username]",
 		AutocompleteData: model.NewAutocompleteData("hello", "[@username]", "Username to say hello to"),
 	}).Return(nil)
-	cmdHandler := NewCommandHandler(env.client)
+	env.api.On("RegisterCommand", mock.MatchedBy(func(c *model.Command) bool {
+		return c.Trigger == attrSyncCommandTrigger
+	})).Return(nil)
+	cmdHandler := NewCommandHandler(env.client, nil, nil)
 
 	args := &model.CommandArgs{
 		Command: "/hello world",
@@ -45,3 +55,165 @@ func TestHelloCommand(t *testing.T) {
 	assert.Nil(err)
 	assert.Equal("Hello, world", response.Text)
 }
+
+type fakeKVStore struct {
+	kvstore.KVStore
+	lastRun  *kvstore.SyncRun
+	failures []*kvstore.UserFailure
+}
+
+func (f *fakeKVStore) GetLastRun() (*kvstore.SyncRun, error) {
+	return f.lastRun, nil
+}
+
+func (f *fakeKVStore) ListUserFailures() ([]*kvstore.UserFailure, error) {
+	return f.failures, nil
+}
+
+func TestAttrSyncStatusCommand(t *testing.T) {
+	assert := assert.New(t)
+	env := setupTest()
+
+	env.api.On("RegisterCommand", mock.Anything).Return(nil)
+	env.api.On("HasPermissionTo", "admin", model.PermissionManageSystem).Return(true)
+	env.api.On("HasPermissionTo", "user", model.PermissionManageSystem).Return(false)
+	store := &fakeKVStore{
+		lastRun: &kvstore.SyncRun{
+			ID:     "run1",
+			Status: kvstore.RunStatusSucceeded,
+			Stats:  kvstore.SyncStats{Fetched: 10, Matched: 9, Updated: 2, Unmatched: 1, Failed: 1},
+		},
+		failures: []*kvstore.UserFailure{
+			{Key: "id:42", UserID: "u42", Failures: 3, Quarantined: true, LastError: "connection reset"},
+		},
+	}
+	syncer := &fakeSyncer{
+		running: &kvstore.SyncLock{NodeID: "node2", Trigger: "manual", StartedAt: time.Date(2025, 3, 1, 9, 30, 0, 0, time.UTC)},
+	}
+	cmdHandler := NewCommandHandler(env.client, store, syncer)
+
+	response, err := cmdHandler.Handle(&model.CommandArgs{Command: "/attrsync status", UserId: "user"})
+	assert.Nil(err)
+	assert.Contains(response.Text, "system admin")
+
+	response, err = cmdHandler.Handle(&model.CommandArgs{Command: "/attrsync status", UserId: "admin"})
+	assert.Nil(err)
+	assert.Contains(response.Text, "A manual sync is running on node `node2` since 2025-03-01 09:30:00 UTC.")
+	assert.Contains(response.Text, "Last run `run1` **succeeded**")
+	assert.Contains(response.Text, "updated 2, unmatched 1, failed 1")
+	assert.Contains(response.Text, "| `id:42` | u42 | 3 | true | connection reset |")
+}
+
+type fakeSyncer struct {
+	previews   map[string]*attrsync.Preview
+	previewErr error
+	deadlines  []bool
+	synced     [][]string
+	requesters []string
+	running    *kvstore.SyncLock
+	cancelled  []string
+}
+
+func (f *fakeSyncer) RunningSync() (*kvstore.SyncLock, error) {
+	return f.running, nil
+}
+
+func (f *fakeSyncer) CancelSync(runID, userID string) (*kvstore.SyncLock, error) {
+	if f.running == nil || (runID != "" && runID != f.running.RunID) {
+		return nil, nil
+	}
+	f.cancelled = append(f.cancelled, f.running.RunID+" by "+userID)
+	return f.running, nil
+}
+
+func (f *fakeSyncer) StartUserSync(identifiers []string, requesterID string) (string, error) {
+	f.synced = append(f.synced, identifiers)
+	f.requesters = append(f.requesters, requesterID)
+	return "run1", nil
+}
+
+func (f *fakeSyncer) PreviewUserSync(ctx context.Context, userID string) (*attrsync.Preview, error) {
+	_, ok := ctx.Deadline()
+	f.deadlines = append(f.deadlines, ok)
+	if f.previewErr != nil {
+		return nil, f.previewErr
+	}
+	return f.previews[userID], nil
+}
+
+func TestAttrSyncPreviewCommand(t *testing.T) {
+	assert := assert.New(t)
+	env := setupTest()
+
+	env.api.On("RegisterCommand", mock.Anything).Return(nil)
+	env.api.On("HasPermissionTo", "admin", model.PermissionManageSystem).Return(true)
+	env.api.On("GetUserByUsername", "alice").Return(&model.User{Id: "u1", Username: "alice"}, nil)
+	env.api.On("GetUserByUsername", "bob").Return(&model.User{Id: "u2", Username: "bob"}, nil)
+	syncer := &fakeSyncer{previews: map[string]*attrsync.Preview{
+		"u1": {
+			UserID:    "u1",
+			Found:     true,
+			RecordKey: "id:1",
+			MatchedBy: attrsync.MatchByEmail,
+			Attributes: []attrsync.AttributePreview{
+				{Attribute: "department", SourceField: "dept", Ownership: attrsync.OwnershipSource, Applies: true, SourceValue: "Engineering", CurrentValue: "Sales", NewValue: "Engineering", Changed: true},
+				{Attribute: "legal_team", SourceField: "dept", Ownership: attrsync.OwnershipSource},
+			},
+		},
+		"u2": {UserID: "u2"},
+	}}
+	cmdHandler := NewCommandHandler(env.client, nil, syncer)
+
+	response, err := cmdHandler.Handle(&model.CommandArgs{Command: "/attrsync preview @alice", UserId: "admin"})
+	assert.Nil(err)
+	assert.Contains(response.Text, "Source record `id:1` matched by **email**.")
+	assert.Contains(response.Text, "| department | dept | source | `Engineering` | `Sales` | **`Engineering`** |")
+	assert.Contains(response.Text, "| legal_team | dept | source | _condition not met_ | _empty_ | _unchanged_ |")
+
+	response, err = cmdHandler.Handle(&model.CommandArgs{Command: "/attrsync preview bob", UserId: "admin"})
+	assert.Nil(err)
+	assert.Contains(response.Text, "no record matching this user")
+	assert.Equal([]bool{true, true}, syncer.deadlines, "previews are bounded")
+
+	env.api.On("LogWarn", "User sync preview timed out", "user_id", "u2", "timeout", "1m0s").Return()
+	syncer.previewErr = errors.Wrap(context.DeadlineExceeded, "failed to fetch users")
+	response, err = cmdHandler.Handle(&model.CommandArgs{Command: "/attrsync preview bob", UserId: "admin"})
+	assert.Nil(err)
+	assert.Contains(response.Text, "The preview took longer than 1m0s to find the user in the source.")
+}
+
+func TestAttrSyncUsersCommand(t *testing.T) {
+	assert := assert.New(t)
+	env := setupTest()
+
+	env.api.On("RegisterCommand", mock.Anything).Return(nil)
+	env.api.On("HasPermissionTo", "admin", model.PermissionManageSystem).Return(true)
+	syncer := &fakeSyncer{}
+	cmdHandler := NewCommandHandler(env.client, nil, syncer)
+
+	response, err := cmdHandler.Handle(&model.CommandArgs{Command: "/attrsync sync @alice bob@example.com", UserId: "admin"})
+	assert.Nil(err)
+	assert.Equal([][]string{{"@alice", "bob@example.com"}}, syncer.synced)
+	assert.Equal([]string{"admin"}, syncer.requesters)
+	assert.Equal("Started manual sync run `run1`. You will get a direct message when it finishes.", response.Text)
+}
+
+func TestAttrSyncCancelCommand(t *testing.T) {
+	assert := assert.New(t)
+	env := setupTest()
+
+	env.api.On("RegisterCommand", mock.Anything).Return(nil)
+	env.api.On("HasPermissionTo", "admin", model.PermissionManageSystem).Return(true)
+	syncer := &fakeSyncer{}
+	cmdHandler := NewCommandHandler(env.client, nil, syncer)
+
+	response, err := cmdHandler.Handle(&model.CommandArgs{Command: "/attrsync cancel", UserId: "admin"})
+	assert.Nil(err)
+	assert.Equal("No sync is running.", response.Text)
+
+	syncer.running = &kvstore.SyncLock{NodeID: "node2", Trigger: "scheduled", RunID: "run1"}
+	response, err = cmdHandler.Handle(&model.CommandArgs{Command: "/attrsync cancel", UserId: "admin"})
+	assert.Nil(err)
+	assert.Equal([]string{"run1 by admin"}, syncer.cancelled)
+	assert.Contains(response.Text, "Asked the scheduled sync run `run1` on node `node2` to stop.")
+}
@@ -49,6 +49,20 @@ func (mr *MockCommandMockRecorder) Handle(arg0 interface{}) *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Handle", reflect.TypeOf((*MockCommand)(nil).Handle), arg0)
 }
 
+// executeAttrSyncCommand mocks base method.
+func (m *MockCommand) executeAttrSyncCommand(arg0 *model.CommandArgs) *model.CommandResponse {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "executeAttrSyncCommand", arg0)
+	ret0, _ := ret[0].(*model.CommandResponse)
+	return ret0
+}
+
+// executeAttrSyncCommand indicates an expected call of executeAttrSyncCommand.
+func (mr *MockCommandMockRecorder) executeAttrSyncCommand(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "executeAttrSyncCommand", reflect.TypeOf((*MockCommand)(nil).executeAttrSyncCommand), arg0)
+}
+
 // executeHelloCommand mocks base method.
 func (m *MockCommand) executeHelloCommand(arg0 *model.CommandArgs) *model.CommandResponse {
 	m.ctrl.T.Helper()
@@ -4,6 +4,10 @@ import (
 	"reflect"
 
 	"github.com/pkg/errors"
+
+	"github.com/mattermost/mattermost-plugin-starter-template/server/attrsync"
+	"github.com/mattermost/mattermost-plugin-starter-template/server/expr"
+	"github.com/mattermost/mattermost-plugin-starter-template/server/membership"
 )
 
 // configuration captures the plugin's external configuration as exposed in the Mattermost server
@@ -18,6 +22,63 @@ import (
 // If you add non-reference types to your configuration struct, be sure to rewrite Clone as a deep
 // copy appropriate for your types.
 type configuration struct {
+	// SourceURL is the endpoint serving the user directory as a JSON array.
+	SourceURL string
+
+	// SourceToken is sent as a bearer token when requesting the source.
+	SourceToken string
+
+	// SourceSinceParam is the query parameter the source accepts to only return users updated
+	// after a timestamp. Leave empty if the source does not support it.
+	SourceSinceParam string
+
+	// FieldMappings is a JSON array describing which source fields map onto which attributes.
+	FieldMappings string
+
+	// SourceExcludeFilter is a condition in the expr language. Source records satisfying it are
+	// not synced.
+	SourceExcludeFilter string
+
+	// MembershipRules is a JSON array of rules adding users to teams and channels, and granting
+	// admin roles, based on their attributes.
+	MembershipRules string
+
+	// FullSyncIntervalHours is how often a full reconcile runs in between incremental syncs.
+	FullSyncIntervalHours int
+
+	// SyncConcurrency is the number of users synced in parallel.
+	SyncConcurrency int
+
+	// APIRequestsPerSecond caps the Mattermost API calls a sync makes. Zero means unlimited.
+	APIRequestsPerSecond int
+
+	// RetryAttempts is how many times a failing source request or user update is tried per run.
+	RetryAttempts int
+
+	// QuarantineAfterFailures is the number of consecutive failed runs after which a user is
+	// skipped until released. Zero disables quarantine.
+	QuarantineAfterFailures int
+
+	// MaxChangedPercent aborts a full sync that would update more than this share of users.
+	MaxChangedPercent int
+
+	// MaxClearedUsers aborts a sync that would clear attributes for more than this many users.
+	MaxClearedUsers int
+
+	// MinSourceRecords aborts a full sync if the source returns fewer records than this.
+	MinSourceRecords int
+
+	// SyncLogVerbosity controls the per-user output logged by syncs: none, changes or all.
+	SyncLogVerbosity string
+
+	// RunHistoryRetentionDays is how long finished sync runs are kept. Zero keeps them forever.
+	RunHistoryRetentionDays int
+
+	// NotifyUsernames is a comma-separated list of users the bot messages about sync problems.
+	NotifyUsernames string
+
+	// NotifyChannelID is a channel the bot posts sync problems to.
+	NotifyChannelID string
 }
 
 // Clone shallow copies the configuration. Your implementation may require a deep copy if
@@ -77,6 +138,21 @@ func (p *Plugin) OnConfigurationChange() error {
 		return errors.Wrap(err, "failed to load plugin configuration")
 	}
 
+	if _, err := attrsync.ParseMappings(configuration.FieldMappings); err != nil {
+		return errors.Wrap(err, "invalid field mappings")
+	}
+	if configuration.SourceExcludeFilter != "" {
+		if _, err := expr.Compile(configuration.SourceExcludeFilter); err != nil {
+			return errors.Wrap(err, "invalid source exclude filter")
+		}
+	}
+	if _, err := membership.ParseRules(configuration.MembershipRules); err != nil {
+		return errors.Wrap(err, "invalid membership rules")
+	}
+	if _, err := attrsync.ParseVerbosity(configuration.SyncLogVerbosity); err != nil {
+		return errors.Wrap(err, "invalid sync log verbosity")
+	}
+
 	p.setConfiguration(configuration)
 
 	return nil
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/mattermost/mattermost-plugin-starter-template/server/fakeapi"
+)
+
+func TestOnConfigurationChange(t *testing.T) {
+	for _, verbosity := range []string{"", "none", "changes", "all"} {
+		api := fakeapi.New()
+		api.SetPluginConfig(map[string]any{"synclogverbosity": verbosity})
+		p := &Plugin{}
+		p.SetAPI(api)
+		assert.NoError(t, p.OnConfigurationChange(), verbosity)
+	}
+
+	api := fakeapi.New()
+	api.SetPluginConfig(map[string]any{"synclogverbosity": "verbose"})
+	p := &Plugin{}
+	p.SetAPI(api)
+	assert.EqualError(t, p.OnConfigurationChange(), `invalid sync log verbosity: unknown verbosity "verbose", expected none, changes or all`)
+}
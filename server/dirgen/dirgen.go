@@ -0,0 +1,228 @@
+// Package dirgen generates synthetic user directories for load and scale testing. A Generator
+// produces a first snapshot of the directory and then successive snapshots in which some users
+// change and some are replaced, in the formats read by the sync sources.
+package dirgen
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"slices"
+	"time"
+)
+
+// Fields are the fields of every generated user besides id, email and username, in output order.
+var Fields = []string{"first_name", "last_name", "department", "title", "location", "manager", "cost_center", "employee_type", "updated_at"}
+
+// Config controls the size and churn of a generated directory.
+type Config struct {
+	// Users is the number of users in every snapshot.
+	Users int
+
+	// Churn is the fraction of users whose department, title, location or manager change between
+	// snapshots.
+	Churn float64
+
+	// Turnover is the fraction of users who leave between snapshots, replaced by as many new ones.
+	Turnover float64
+
+	// Seed makes the generated directories reproducible.
+	Seed uint64
+
+	// Start is the time of the first snapshot. Every later snapshot is a day later. It defaults to
+	// the start of 2025.
+	Start time.Time
+}
+
+// User is a generated directory entry.
+type User struct {
+	ID       string
+	Email    string
+	Username string
+	Fields   map[string]string
+}
+
+// Changes summarizes how a snapshot differs from the previous one.
+type Changes struct {
+	Changed int
+	Joined  int
+	Left    int
+}
+
+// Generator produces successive snapshots of a synthetic directory.
+type Generator struct {
+	config Config
+	rng    *rand.Rand
+	now    time.Time
+	users  []*User
+	nextID int
+}
+
+// New creates a Generator holding the first snapshot of the directory.
+func New(config Config) *Generator {
+	if config.Start.IsZero() {
+		config.Start = time.Date(2025, time.January, 1, 0, 0, 0, 0, time.UTC)
+	}
+	g := &Generator{
+		config: config,
+		rng:    rand.New(rand.NewPCG(config.Seed, config.Seed)),
+		now:    config.Start.UTC(),
+	}
+	for range config.Users {
+		g.users = append(g.users, g.newUser())
+	}
+	return g
+}
+
+// Users returns a copy of the current snapshot, in the order users joined.
+func (g *Generator) Users() []User {
+	users := make([]User, 0, len(g.users))
+	for _, user := range g.users {
+		copied := *user
+		copied.Fields = make(map[string]string, len(user.Fields))
+		for name, value := range user.Fields {
+			copied.Fields[name] = value
+		}
+		users = append(users, copied)
+	}
+	return users
+}
+
+// Next advances the directory to the next snapshot.
+func (g *Generator) Next() Changes {
+	g.now = g.now.Add(24 * time.Hour)
+	changes := Changes{
+		Changed: g.share(g.config.Churn),
+		Left:    g.share(g.config.Turnover),
+	}
+
+	leaving := g.pick(changes.Left)
+	kept := g.users[:0]
+	for i, user := range g.users {
+		if !leaving[i] {
+			kept = append(kept, user)
+		}
+	}
+	g.users = kept
+
+	changes.Changed = min(changes.Changed, len(g.users))
+	for i := range g.pick(changes.Changed) {
+		g.change(g.users[i])
+	}
+
+	for range changes.Left {
+		g.users = append(g.users, g.newUser())
+	}
+	changes.Joined = changes.Left
+	return changes
+}
+
+// share returns the number of current users making up share of them.
+func (g *Generator) share(share float64) int {
+	return min(int(share*float64(len(g.users))+0.5), len(g.users))
+}
+
+// pick returns the indexes of count random current users.
+func (g *Generator) pick(count int) map[int]bool {
+	picked := make(map[int]bool, count)
+	for _, i := range g.rng.Perm(len(g.users))[:count] {
+		picked[i] = true
+	}
+	return picked
+}
+
+func (g *Generator) newUser() *User {
+	g.nextID++
+	first := firstNames[g.rng.IntN(len(firstNames))]
+	last := lastNames[g.rng.IntN(len(lastNames))]
+	username := fmt.Sprintf("%s.%s%d", first, last, g.nextID)
+
+	user := &User{
+		ID:       fmt.Sprintf("E%07d", g.nextID),
+		Email:    username + "@example.com",
+		Username: username,
+		Fields: map[string]string{
+			"first_name":    first,
+			"last_name":     last,
+			"employee_type": employeeTypes[g.rng.IntN(len(employeeTypes))],
+		},
+	}
+	g.assignDepartment(user)
+	user.Fields["location"] = locations[g.rng.IntN(len(locations))]
+	user.Fields["manager"] = g.manager(user)
+	// Users of the first snapshot were last updated at some point during the preceding year.
+	updatedAt := g.now
+	if g.now.Equal(g.config.Start) {
+		updatedAt = g.now.Add(-time.Duration(g.rng.IntN(365*24)) * time.Hour)
+	}
+	user.Fields["updated_at"] = updatedAt.Format(time.RFC3339)
+	return user
+}
+
+// change moves user to another department, title, location or manager.
+func (g *Generator) change(user *User) {
+	switch g.rng.IntN(4) {
+	case 0:
+		g.assignDepartment(user)
+	case 1:
+		titles := departments[user.Fields["department"]].titles
+		user.Fields["title"] = titles[g.rng.IntN(len(titles))]
+	case 2:
+		user.Fields["location"] = locations[g.rng.IntN(len(locations))]
+	case 3:
+		user.Fields["manager"] = g.manager(user)
+	}
+	user.Fields["updated_at"] = g.now.Format(time.RFC3339)
+}
+
+func (g *Generator) assignDepartment(user *User) {
+	name := departmentNames[g.rng.IntN(len(departmentNames))]
+	department := departments[name]
+	user.Fields["department"] = name
+	user.Fields["title"] = department.titles[g.rng.IntN(len(department.titles))]
+	user.Fields["cost_center"] = department.costCenter
+}
+
+// manager returns the username of a random current user other than user, or an empty value if
+// there is none.
+func (g *Generator) manager(user *User) string {
+	if len(g.users) == 0 || (len(g.users) == 1 && g.users[0] == user) {
+		return ""
+	}
+	for {
+		if manager := g.users[g.rng.IntN(len(g.users))]; manager != user {
+			return manager.Username
+		}
+	}
+}
+
+type department struct {
+	costCenter string
+	titles     []string
+}
+
+var departments = map[string]department{
+	"Engineering": {"CC-100", []string{"Software Engineer", "Senior Software Engineer", "Staff Engineer", "Engineering Manager"}},
+	"Sales":       {"CC-200", []string{"Account Executive", "Sales Engineer", "Sales Manager"}},
+	"Marketing":   {"CC-300", []string{"Marketing Specialist", "Content Writer", "Marketing Manager"}},
+	"Support":     {"CC-400", []string{"Support Engineer", "Support Lead"}},
+	"Finance":     {"CC-500", []string{"Accountant", "Financial Analyst", "Controller"}},
+	"Legal":       {"CC-600", []string{"Counsel", "Paralegal"}},
+	"People":      {"CC-700", []string{"Recruiter", "People Partner"}},
+	"Operations":  {"CC-800", []string{"Operations Analyst", "Program Manager"}},
+}
+
+var departmentNames = func() []string {
+	names := make([]string, 0, len(departments))
+	for name := range departments {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}()
+
+var (
+	firstNames    = []string{"alex", "sam", "jordan", "taylor", "morgan", "casey", "riley", "jamie", "avery", "quinn", "kai", "rowan", "sasha", "noor", "li", "mateo", "amara", "yuki", "priya", "omar"}
+	lastNames     = []string{"smith", "garcia", "chen", "kumar", "nguyen", "okafor", "silva", "muller", "rossi", "kowalski", "haddad", "tanaka", "jones", "ivanova", "cohen", "dubois"}
+	locations     = []string{"Remote", "New York", "San Francisco", "London", "Berlin", "Toronto", "Bangalore", "Singapore", "São Paulo", "Sydney"}
+	employeeTypes = []string{"full-time", "full-time", "full-time", "part-time", "contractor"}
+)
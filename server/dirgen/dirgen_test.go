@@ -0,0 +1,72 @@
+package dirgen
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGenerator(t *testing.T) {
+	config := Config{Users: 1000, Churn: 0.1, Turnover: 0.02, Seed: 7}
+	g := New(config)
+	first := g.Users()
+	require.Len(t, first, 1000)
+	assert.Equal(t, first, New(config).Users(), "the same seed generates the same directory")
+
+	seen := map[string]bool{}
+	for _, user := range first {
+		assert.False(t, seen[user.Username], "usernames are unique")
+		seen[user.Username] = true
+		for _, field := range Fields {
+			if field != "manager" {
+				assert.NotEmpty(t, user.Fields[field], field)
+			}
+		}
+	}
+
+	changes := g.Next()
+	assert.Equal(t, Changes{Changed: 100, Joined: 20, Left: 20}, changes)
+	second := g.Users()
+	require.Len(t, second, 1000)
+
+	before := map[string]User{}
+	for _, user := range first {
+		before[user.ID] = user
+	}
+	var changed, joined int
+	for _, user := range second {
+		previous, ok := before[user.ID]
+		switch {
+		case !ok:
+			joined++
+		case previous.Fields["updated_at"] != user.Fields["updated_at"]:
+			changed++
+		}
+	}
+	assert.Equal(t, 20, joined)
+	assert.Equal(t, 100, changed)
+}
+
+func TestWrite(t *testing.T) {
+	users := New(Config{Users: 3, Seed: 1}).Users()
+
+	var buf bytes.Buffer
+	require.NoError(t, WriteJSON(&buf, users))
+	var entries []map[string]string
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &entries))
+	require.Len(t, entries, 3)
+	assert.Equal(t, users[0].Email, entries[0]["email"])
+	assert.Equal(t, users[0].Fields["department"], entries[0]["department"])
+
+	buf.Reset()
+	require.NoError(t, WriteCSV(&buf, users))
+	rows, err := csv.NewReader(&buf).ReadAll()
+	require.NoError(t, err)
+	require.Len(t, rows, 4)
+	assert.Equal(t, []string{"id", "email", "username"}, rows[0][:3])
+	assert.Equal(t, users[2].ID, rows[3][0])
+}
@@ -0,0 +1,48 @@
+package dirgen
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io"
+)
+
+// Entry returns user as an entry of a JSON directory, the format read by the HTTP source.
+func (u User) Entry() map[string]string {
+	entry := make(map[string]string, len(u.Fields)+3)
+	for name, value := range u.Fields {
+		entry[name] = value
+	}
+	entry["id"] = u.ID
+	entry["email"] = u.Email
+	entry["username"] = u.Username
+	return entry
+}
+
+// WriteJSON writes users as a JSON array of objects.
+func WriteJSON(w io.Writer, users []User) error {
+	entries := make([]map[string]string, 0, len(users))
+	for _, user := range users {
+		entries = append(entries, user.Entry())
+	}
+	return json.NewEncoder(w).Encode(entries)
+}
+
+// WriteCSV writes users as CSV with a header row of id, email, username and Fields.
+func WriteCSV(w io.Writer, users []User) error {
+	writer := csv.NewWriter(w)
+	header := append([]string{"id", "email", "username"}, Fields...)
+	if err := writer.Write(header); err != nil {
+		return err
+	}
+	for _, user := range users {
+		row := []string{user.ID, user.Email, user.Username}
+		for _, name := range Fields {
+			row = append(row, user.Fields[name])
+		}
+		if err := writer.Write(row); err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
@@ -0,0 +1,156 @@
+package expr
+
+import (
+	"regexp"
+)
+
+// Type is the type of a value in an expression.
+type Type int
+
+const (
+	TypeString Type = iota + 1
+	TypeNumber
+	TypeDate
+	TypeBool
+	// TypeList is a list of strings.
+	TypeList
+)
+
+func (t Type) String() string {
+	switch t {
+	case TypeString:
+		return "string"
+	case TypeNumber:
+		return "number"
+	case TypeDate:
+		return "date"
+	case TypeBool:
+		return "boolean"
+	case TypeList:
+		return "list"
+	default:
+		return "unknown"
+	}
+}
+
+// check returns the type of n, reporting operators and functions applied to values of the wrong
+// type.
+func check(n node) (Type, error) {
+	switch n := n.(type) {
+	case *identNode, *stringNode:
+		return TypeString, nil
+	case *numberNode:
+		return TypeNumber, nil
+	case *boolNode:
+		return TypeBool, nil
+	case *listNode:
+		for _, item := range n.items {
+			typ, err := check(item)
+			if err != nil {
+				return 0, err
+			}
+			if typ != TypeString {
+				return 0, errorf(item.position(), "lists may only contain strings, not a %s", typ)
+			}
+		}
+		return TypeList, nil
+	case *callNode:
+		return checkCall(n)
+	case *unaryNode:
+		typ, err := check(n.operand)
+		if err != nil {
+			return 0, err
+		}
+		if typ != TypeBool {
+			return 0, errorf(n.pos, "NOT requires a condition, not a %s", typ)
+		}
+		return TypeBool, nil
+	case *binaryNode:
+		return checkBinary(n)
+	default:
+		return 0, errorf(n.position(), "unexpected expression")
+	}
+}
+
+func checkCall(n *callNode) (Type, error) {
+	fn, ok := functions[n.name]
+	if !ok {
+		return 0, errorf(n.pos, "unknown function %s", n.name)
+	}
+	if len(n.args) != len(fn.params) {
+		return 0, errorf(n.pos, "%s takes %d arguments, not %d", n.name, len(fn.params), len(n.args))
+	}
+	for i, arg := range n.args {
+		typ, err := check(arg)
+		if err != nil {
+			return 0, err
+		}
+		if typ != fn.params[i] {
+			return 0, errorf(arg.position(), "argument %d of %s must be a %s, not a %s", i+1, n.name, fn.params[i], typ)
+		}
+	}
+	return fn.result, nil
+}
+
+func checkBinary(n *binaryNode) (Type, error) {
+	left, err := check(n.left)
+	if err != nil {
+		return 0, err
+	}
+	right, err := check(n.right)
+	if err != nil {
+		return 0, err
+	}
+	mismatch := func() error {
+		return errorf(n.pos, "cannot apply %s to a %s and a %s", operatorName(n.op), left, right)
+	}
+
+	switch n.op {
+	case "&&", "||":
+		if left != TypeBool || right != TypeBool {
+			return 0, mismatch()
+		}
+	case "==", "!=":
+		if left != right || left == TypeList {
+			return 0, mismatch()
+		}
+	case "<", "<=", ">", ">=":
+		if left != right || left != TypeNumber && left != TypeDate {
+			return 0, mismatch()
+		}
+	case "in":
+		if left != TypeString || right != TypeList {
+			return 0, mismatch()
+		}
+	case "contains":
+		if left != TypeString && left != TypeList || right != TypeString {
+			return 0, mismatch()
+		}
+	case "=~":
+		if left != TypeString || right != TypeString {
+			return 0, mismatch()
+		}
+		pattern, ok := n.right.(*stringNode)
+		if !ok {
+			return 0, errorf(n.right.position(), "the pattern of matches must be a string literal")
+		}
+		if n.pattern, err = regexp.Compile(pattern.value); err != nil {
+			return 0, errorf(n.right.position(), "invalid pattern: %v", err)
+		}
+	}
+
+	return TypeBool, nil
+}
+
+func operatorName(op string) string {
+	switch op {
+	case "&&":
+		return "AND"
+	case "||":
+		return "OR"
+	case "=~":
+		return "matches"
+	default:
+		return op
+	}
+}
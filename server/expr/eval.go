@@ -0,0 +1,139 @@
+package expr
+
+import (
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// eval computes the value of a type checked node. Values are strings, float64, time.Time, bool
+// or []string.
+func eval(n node, attributes map[string]string) (any, error) {
+	switch n := n.(type) {
+	case *identNode:
+		return attributes[n.name], nil
+	case *stringNode:
+		return n.value, nil
+	case *numberNode:
+		return n.value, nil
+	case *boolNode:
+		return n.value, nil
+	case *listNode:
+		items := make([]string, 0, len(n.items))
+		for _, item := range n.items {
+			value, err := eval(item, attributes)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, value.(string))
+		}
+		return items, nil
+	case *callNode:
+		args := make([]any, 0, len(n.args))
+		for _, arg := range n.args {
+			value, err := eval(arg, attributes)
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, value)
+		}
+		value, err := functions[n.name].call(args)
+		var exprErr *Error
+		if errors.As(err, &exprErr) {
+			// Functions do not know where they were called from.
+			exprErr.Pos = n.pos
+		}
+		return value, err
+	case *unaryNode:
+		value, err := eval(n.operand, attributes)
+		if err != nil {
+			return nil, err
+		}
+		return !value.(bool), nil
+	case *binaryNode:
+		return evalBinary(n, attributes)
+	default:
+		return nil, errorf(n.position(), "unexpected expression")
+	}
+}
+
+func evalBinary(n *binaryNode, attributes map[string]string) (any, error) {
+	left, err := eval(n.left, attributes)
+	if err != nil {
+		return nil, err
+	}
+
+	// The boolean operators only evaluate their right operand if needed, so that it can guard
+	// against values that would fail to convert.
+	switch n.op {
+	case "&&":
+		if !left.(bool) {
+			return false, nil
+		}
+		return eval(n.right, attributes)
+	case "||":
+		if left.(bool) {
+			return true, nil
+		}
+		return eval(n.right, attributes)
+	}
+
+	right, err := eval(n.right, attributes)
+	if err != nil {
+		return nil, err
+	}
+
+	switch n.op {
+	case "==":
+		return compare(left, right) == 0, nil
+	case "!=":
+		return compare(left, right) != 0, nil
+	case "<":
+		return compare(left, right) < 0, nil
+	case "<=":
+		return compare(left, right) <= 0, nil
+	case ">":
+		return compare(left, right) > 0, nil
+	case ">=":
+		return compare(left, right) >= 0, nil
+	case "in":
+		return slices.Contains(right.([]string), left.(string)), nil
+	case "contains":
+		if list, ok := left.([]string); ok {
+			return slices.Contains(list, right.(string)), nil
+		}
+		return strings.Contains(left.(string), right.(string)), nil
+	case "=~":
+		return n.pattern.MatchString(left.(string)), nil
+	default:
+		return nil, errorf(n.pos, "unknown operator %s", n.op)
+	}
+}
+
+// compare orders two values of the same type.
+func compare(left, right any) int {
+	switch left := left.(type) {
+	case string:
+		return strings.Compare(left, right.(string))
+	case float64:
+		switch right := right.(float64); {
+		case left < right:
+			return -1
+		case left > right:
+			return 1
+		default:
+			return 0
+		}
+	case time.Time:
+		return left.Compare(right.(time.Time))
+	case bool:
+		if left == right.(bool) {
+			return 0
+		}
+		return 1
+	default:
+		return 1
+	}
+}
@@ -0,0 +1,117 @@
+// Package expr implements the condition language used to act on user attributes.
+//
+// An expression compares attributes, which are strings, against literals and each other:
+//
+//	department == "Engineering" AND location in ["Berlin", "Munich"]
+//	title contains "Manager" OR NOT (employment_type matches "^contract")
+//	end_date != "" AND date(end_date) < days_ago(30)
+//
+// Operators are ==, !=, <, <=, >, >=, in, contains, matches (also =~), and the boolean AND (&&),
+// OR (||) and NOT (!), which short-circuit. Keywords are case-insensitive. Strings are quoted
+// with single or double quotes. The functions date, now, days_ago, number, lower, upper and trim
+// convert between the string, number and date types. Attributes that are not set are empty
+// strings.
+//
+// Expressions are type checked when compiled, so that mistakes such as comparing a date with a
+// string are reported when a rule is configured rather than when it is evaluated.
+package expr
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Error describes a problem with an expression.
+type Error struct {
+	// Pos is the byte offset in the expression the problem was found at.
+	Pos int
+	Msg string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("at position %d: %s", e.Pos+1, e.Msg)
+}
+
+func errorf(pos int, format string, args ...any) error {
+	return &Error{Pos: pos, Msg: fmt.Sprintf(format, args...)}
+}
+
+// Expression is a compiled condition.
+type Expression struct {
+	src        string
+	root       node
+	attributes []string
+}
+
+// Compile parses and type checks src, which must be a boolean condition.
+func Compile(src string) (*Expression, error) {
+	root, err := parse(src)
+	if err != nil {
+		return nil, err
+	}
+	typ, err := check(root)
+	if err != nil {
+		return nil, err
+	}
+	if typ != TypeBool {
+		return nil, errorf(root.position(), "expression is a %s, not a condition", typ)
+	}
+
+	return &Expression{
+		src:        src,
+		root:       root,
+		attributes: referencedAttributes(root),
+	}, nil
+}
+
+// Eval evaluates the expression against attributes. An error is returned if a value cannot be
+// converted, e.g. an attribute passed to date is not a date.
+func (e *Expression) Eval(attributes map[string]string) (bool, error) {
+	value, err := eval(e.root, attributes)
+	if err != nil {
+		return false, err
+	}
+	return value.(bool), nil
+}
+
+// Attributes returns the names of the attributes the expression refers to, sorted.
+func (e *Expression) Attributes() []string {
+	return e.attributes
+}
+
+// String returns the source of the expression.
+func (e *Expression) String() string {
+	return e.src
+}
+
+func referencedAttributes(root node) []string {
+	seen := map[string]bool{}
+	var walk func(n node)
+	walk = func(n node) {
+		switch n := n.(type) {
+		case *identNode:
+			seen[n.name] = true
+		case *listNode:
+			for _, item := range n.items {
+				walk(item)
+			}
+		case *callNode:
+			for _, arg := range n.args {
+				walk(arg)
+			}
+		case *unaryNode:
+			walk(n.operand)
+		case *binaryNode:
+			walk(n.left)
+			walk(n.right)
+		}
+	}
+	walk(root)
+
+	names := make([]string, 0, len(seen))
+	for name := range seen {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,99 @@
+package expr
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEval(t *testing.T) {
+	now = func() time.Time { return time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC) }
+	defer func() { now = time.Now }()
+
+	attributes := map[string]string{
+		"department": "Engineering",
+		"location":   "Berlin",
+		"title":      "Engineering Manager",
+		"level":      "7",
+		"start_date": "2024-05-20",
+		"end_date":   "",
+	}
+
+	for src, expected := range map[string]bool{
+		`department == "Engineering"`:                                  true,
+		`department != 'Engineering'`:                                  false,
+		`department == "Engineering" AND location == "Berlin"`:         true,
+		`department == "Engineering" && location == "Paris"`:           false,
+		`department == "Sales" or location == "Berlin"`:                true,
+		`NOT department == "Sales"`:                                    true,
+		`!(department == "Engineering" || location == "Paris")`:        false,
+		`location in ["Berlin", "Munich"]`:                             true,
+		`location in []`:                                               false,
+		`title contains "Manager"`:                                     true,
+		`["a", lower(department)] contains "engineering"`:              true,
+		`title matches "^Eng.*Manager$"`:                               true,
+		`title =~ "(?i)^director"`:                                     false,
+		`missing == ""`:                                                true,
+		`number(level) >= 5`:                                           true,
+		`number(level) < 5`:                                            false,
+		`date(start_date) > date("2024-05-01")`:                        true,
+		`date(start_date) >= days_ago(7)`:                              false,
+		`date(start_date) <= now()`:                                    true,
+		`end_date != "" AND date(end_date) < now()`:                    false,
+		`upper(trim(" berlin ")) == "BERLIN"`:                          true,
+		`(department == "Sales" OR title contains "Manager") AND true`: true,
+	} {
+		expression, err := Compile(src)
+		require.NoError(t, err, src)
+		actual, err := expression.Eval(attributes)
+		require.NoError(t, err, src)
+		assert.Equal(t, expected, actual, src)
+	}
+}
+
+func TestEvalErrors(t *testing.T) {
+	expression, err := Compile(`date(end_date) < now()`)
+	require.NoError(t, err)
+
+	_, err = expression.Eval(map[string]string{"end_date": "soon"})
+	var exprErr *Error
+	require.ErrorAs(t, err, &exprErr)
+	assert.Equal(t, 0, exprErr.Pos)
+	assert.Contains(t, err.Error(), `"soon" is not a date`)
+}
+
+func TestCompileErrors(t *testing.T) {
+	for src, message := range map[string]string{
+		``:                                  "expected a value, found end of expression",
+		`department ==`:                     "expected a value, found end of expression",
+		`department == "Engineering`:        "unterminated string",
+		`department = "x"`:                  `unexpected character '='`,
+		`(department == "x"`:                "expected ), found end of expression",
+		`department == "x" location == "y"`: "unexpected location",
+		`a == b == c`:                       "comparisons cannot be chained",
+		`department`:                        "expression is a string, not a condition",
+		`department AND location == "x"`:    "cannot apply AND to a string and a boolean",
+		`date(start_date) < "2024-01-01"`:   "cannot apply < to a date and a string",
+		`department < "x"`:                  "cannot apply < to a string and a string",
+		`department in "x"`:                 "cannot apply in to a string and a string",
+		`department matches location`:       "the pattern of matches must be a string literal",
+		`department matches "("`:            "invalid pattern",
+		`unknown(department) == "x"`:        "unknown function unknown",
+		`date() < now()`:                    "date takes 1 arguments, not 0",
+		`number(5) > 1`:                     "argument 1 of number must be a string, not a number",
+		`department in ["x", number("1")]`:  "lists may only contain strings, not a number",
+		`NOT department`:                    "NOT requires a condition, not a string",
+	} {
+		_, err := Compile(src)
+		require.Error(t, err, src)
+		assert.Contains(t, err.Error(), message, src)
+	}
+}
+
+func TestAttributes(t *testing.T) {
+	expression, err := Compile(`title contains "Manager" OR (department in ["a", location] AND date(start) < now())`)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"department", "location", "start", "title"}, expression.Attributes())
+}
@@ -0,0 +1,73 @@
+package expr
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+// function is a built-in function. Arguments are checked against params before call is invoked.
+type function struct {
+	params []Type
+	result Type
+	call   func(args []any) (any, error)
+}
+
+// now is overridden in tests.
+var now = time.Now
+
+// dateLayouts are the formats date accepts.
+var dateLayouts = []string{time.RFC3339, "2006-01-02T15:04:05", "2006-01-02"}
+
+var functions = map[string]function{
+	"date": {
+		params: []Type{TypeString},
+		result: TypeDate,
+		call: func(args []any) (any, error) {
+			value := strings.TrimSpace(args[0].(string))
+			for _, layout := range dateLayouts {
+				if t, err := time.Parse(layout, value); err == nil {
+					return t, nil
+				}
+			}
+			return nil, errorf(0, "%q is not a date", value)
+		},
+	},
+	"now": {
+		result: TypeDate,
+		call: func([]any) (any, error) {
+			return now(), nil
+		},
+	},
+	"days_ago": {
+		params: []Type{TypeNumber},
+		result: TypeDate,
+		call: func(args []any) (any, error) {
+			return now().Add(-time.Duration(args[0].(float64) * float64(24*time.Hour))), nil
+		},
+	},
+	"number": {
+		params: []Type{TypeString},
+		result: TypeNumber,
+		call: func(args []any) (any, error) {
+			value, err := strconv.ParseFloat(strings.TrimSpace(args[0].(string)), 64)
+			if err != nil {
+				return nil, errorf(0, "%q is not a number", args[0])
+			}
+			return value, nil
+		},
+	},
+	"lower": stringFunction(strings.ToLower),
+	"upper": stringFunction(strings.ToUpper),
+	"trim":  stringFunction(strings.TrimSpace),
+}
+
+func stringFunction(fn func(string) string) function {
+	return function{
+		params: []Type{TypeString},
+		result: TypeString,
+		call: func(args []any) (any, error) {
+			return fn(args[0].(string)), nil
+		},
+	}
+}
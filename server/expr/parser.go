@@ -0,0 +1,276 @@
+package expr
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// node is an element of the syntax tree.
+type node interface {
+	position() int
+}
+
+// identNode refers to an attribute by name.
+type identNode struct {
+	pos  int
+	name string
+}
+
+type stringNode struct {
+	pos   int
+	value string
+}
+
+type numberNode struct {
+	pos   int
+	value float64
+}
+
+type boolNode struct {
+	pos   int
+	value bool
+}
+
+type listNode struct {
+	pos   int
+	items []node
+}
+
+type callNode struct {
+	pos  int
+	name string
+	args []node
+}
+
+type unaryNode struct {
+	pos     int
+	op      string
+	operand node
+}
+
+type binaryNode struct {
+	pos         int
+	op          string
+	left, right node
+
+	// pattern is the compiled regular expression of matches, set by the type checker.
+	pattern *regexp.Regexp
+}
+
+func (n *identNode) position() int  { return n.pos }
+func (n *stringNode) position() int { return n.pos }
+func (n *numberNode) position() int { return n.pos }
+func (n *boolNode) position() int   { return n.pos }
+func (n *listNode) position() int   { return n.pos }
+func (n *callNode) position() int   { return n.pos }
+func (n *unaryNode) position() int  { return n.pos }
+func (n *binaryNode) position() int { return n.pos }
+
+// comparisons are the binary operators binding tighter than the boolean ones. They do not chain.
+var comparisons = map[string]bool{
+	"==": true, "!=": true, "<": true, "<=": true, ">": true, ">=": true,
+	"in": true, "contains": true, "=~": true,
+}
+
+// parser is a recursive descent parser over the grammar:
+//
+//	or         = and { "||" and }
+//	and        = unary { "&&" unary }
+//	unary      = "!" unary | comparison
+//	comparison = operand [ comparisonOp operand ]
+//	operand    = ident | ident "(" [ or { "," or } ] ")" | string | number | "true" | "false"
+//	           | "[" [ or { "," or } ] "]" | "(" or ")"
+type parser struct {
+	tokens []token
+	next   int
+}
+
+// parse builds the syntax tree of src.
+func parse(src string) (node, error) {
+	tokens, err := lex(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if tok := p.peek(); tok.kind != tokenEOF {
+		return nil, errorf(tok.pos, "unexpected %s", describe(tok))
+	}
+	return root, nil
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.next]
+}
+
+func (p *parser) advance() token {
+	tok := p.tokens[p.next]
+	if tok.kind != tokenEOF {
+		p.next++
+	}
+	return tok
+}
+
+func (p *parser) isOperator(op string) bool {
+	tok := p.peek()
+	return tok.kind == tokenOperator && tok.text == op
+}
+
+func (p *parser) expect(kind tokenKind, what string) (token, error) {
+	tok := p.advance()
+	if tok.kind != kind {
+		return tok, errorf(tok.pos, "expected %s, found %s", what, describe(tok))
+	}
+	return tok, nil
+}
+
+func (p *parser) parseOr() (node, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.isOperator("||") {
+		tok := p.advance()
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &binaryNode{pos: tok.pos, op: "||", left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseAnd() (node, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.isOperator("&&") {
+		tok := p.advance()
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = &binaryNode{pos: tok.pos, op: "&&", left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseUnary() (node, error) {
+	if p.isOperator("!") {
+		tok := p.advance()
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &unaryNode{pos: tok.pos, op: "!", operand: operand}, nil
+	}
+	return p.parseComparison()
+}
+
+func (p *parser) parseComparison() (node, error) {
+	left, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	tok := p.peek()
+	if tok.kind != tokenOperator || !comparisons[tok.text] {
+		return left, nil
+	}
+	p.advance()
+	right, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	if next := p.peek(); next.kind == tokenOperator && comparisons[next.text] {
+		return nil, errorf(next.pos, "comparisons cannot be chained, use parentheses")
+	}
+	return &binaryNode{pos: tok.pos, op: tok.text, left: left, right: right}, nil
+}
+
+func (p *parser) parseOperand() (node, error) {
+	tok := p.advance()
+	switch tok.kind {
+	case tokenString:
+		return &stringNode{pos: tok.pos, value: tok.text}, nil
+	case tokenNumber:
+		value, err := strconv.ParseFloat(tok.text, 64)
+		if err != nil {
+			return nil, errorf(tok.pos, "invalid number %s", tok.text)
+		}
+		return &numberNode{pos: tok.pos, value: value}, nil
+	case tokenIdent:
+		switch strings.ToLower(tok.text) {
+		case "true":
+			return &boolNode{pos: tok.pos, value: true}, nil
+		case "false":
+			return &boolNode{pos: tok.pos, value: false}, nil
+		}
+		if p.peek().kind != tokenLParen {
+			return &identNode{pos: tok.pos, name: tok.text}, nil
+		}
+		p.advance()
+		args, err := p.parseList(tokenRParen, ")")
+		if err != nil {
+			return nil, err
+		}
+		return &callNode{pos: tok.pos, name: strings.ToLower(tok.text), args: args}, nil
+	case tokenLBracket:
+		items, err := p.parseList(tokenRBracket, "]")
+		if err != nil {
+			return nil, err
+		}
+		return &listNode{pos: tok.pos, items: items}, nil
+	case tokenLParen:
+		inner, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if _, err = p.expect(tokenRParen, ")"); err != nil {
+			return nil, err
+		}
+		return inner, nil
+	default:
+		return nil, errorf(tok.pos, "expected a value, found %s", describe(tok))
+	}
+}
+
+// parseList parses comma-separated expressions up to and including the closing token.
+func (p *parser) parseList(closing tokenKind, text string) ([]node, error) {
+	var items []node
+	if p.peek().kind == closing {
+		p.advance()
+		return items, nil
+	}
+	for {
+		item, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+
+		tok := p.advance()
+		switch tok.kind {
+		case closing:
+			return items, nil
+		case tokenComma:
+		default:
+			return nil, errorf(tok.pos, "expected , or %s, found %s", text, describe(tok))
+		}
+	}
+}
+
+func describe(tok token) string {
+	switch tok.kind {
+	case tokenEOF:
+		return "end of expression"
+	case tokenString:
+		return strconv.Quote(tok.text)
+	default:
+		return tok.text
+	}
+}
@@ -0,0 +1,254 @@
+// Package fakeapi provides an in-memory implementation of the parts of the Mattermost plugin API
+// the plugin depends on, so that the sync engine, slash commands and HTTP API can be tested end to
+// end without a Mattermost server.
+//
+// API embeds plugin.API, so calling a method it does not implement panics. The plugin API of the
+// supported server versions has no property fields or values; synced attributes live in user
+// Props and are covered by the fake users.
+package fakeapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/plugin"
+)
+
+// API is an in-memory plugin API. It is safe for concurrent use.
+type API struct {
+	plugin.API
+
+	// PluginID is returned by GetPluginID.
+	PluginID string
+
+	// ServerVersion is returned by GetServerVersion.
+	ServerVersion string
+
+	// BundlePath is returned by GetBundlePath. Bot profile images are read relative to it.
+	BundlePath string
+
+	mu           sync.Mutex
+	now          func() time.Time
+	failures     map[string]*model.AppError
+	config       *model.Config
+	pluginConfig map[string]any
+	logs         []LogEntry
+	commands     []*model.Command
+
+	users map[string]*model.User
+
+	// usersByEmail and usersByUsername map lowercased emails and usernames to user IDs.
+	// userLists caches the IDs of the users matching a filter, sorted by username. Lists are built
+	// on demand and dropped when a username or deactivation changes.
+	usersByEmail    map[string]string
+	usersByUsername map[string]string
+	userLists       map[userFilter][]string
+
+	kv map[string]kvEntry
+
+	// sortedKeys caches the KV keys in lexical order. It is built on demand and dropped when a key
+	// is added or removed. expiring holds the keys set with an expiry.
+	sortedKeys []string
+	expiring   map[string]bool
+	bots       map[string]*model.Bot
+	channels   map[string]*model.Channel
+	posts      []*model.Post
+
+	teams       map[string]*model.Team
+	teamMembers map[teamMemberKey]*model.TeamMember
+}
+
+var _ plugin.API = (*API)(nil)
+
+// LogEntry is a message logged by the plugin.
+type LogEntry struct {
+	Level         string
+	Message       string
+	KeyValuePairs []any
+}
+
+// New creates an empty API with a default server configuration.
+func New() *API {
+	config := &model.Config{}
+	config.SetDefaults()
+
+	return &API{
+		PluginID:        "com.mattermost.plugin-starter-template",
+		ServerVersion:   "10.5.0",
+		now:             time.Now,
+		failures:        map[string]*model.AppError{},
+		config:          config,
+		pluginConfig:    map[string]any{},
+		users:           map[string]*model.User{},
+		usersByEmail:    map[string]string{},
+		usersByUsername: map[string]string{},
+		userLists:       map[userFilter][]string{},
+		kv:              map[string]kvEntry{},
+		expiring:        map[string]bool{},
+		bots:            map[string]*model.Bot{},
+		channels:        map[string]*model.Channel{},
+		teams:           map[string]*model.Team{},
+		teamMembers:     map[teamMemberKey]*model.TeamMember{},
+	}
+}
+
+// SetClock replaces the clock used for KV expiry and timestamps.
+func (a *API) SetClock(now func() time.Time) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.now = now
+}
+
+// Fail makes every later call of the named API method fail with appErr, or succeed again if
+// appErr is nil. Only methods returning an *model.AppError can be made to fail.
+func (a *API) Fail(method string, appErr *model.AppError) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if appErr == nil {
+		delete(a.failures, method)
+		return
+	}
+	a.failures[method] = appErr
+}
+
+// failure returns the error injected for method. It must be called with mu held.
+func (a *API) failure(method string) *model.AppError {
+	return a.failures[method]
+}
+
+func notFound(where, id string) *model.AppError {
+	return model.NewAppError(where, "fakeapi.not_found", map[string]any{"Id": id}, "", http.StatusNotFound)
+}
+
+// GetPluginID returns PluginID.
+func (a *API) GetPluginID() string {
+	return a.PluginID
+}
+
+// GetServerVersion returns ServerVersion.
+func (a *API) GetServerVersion() string {
+	return a.ServerVersion
+}
+
+// GetBundlePath returns BundlePath.
+func (a *API) GetBundlePath() (string, error) {
+	return a.BundlePath, nil
+}
+
+// SetConfig replaces the server configuration.
+func (a *API) SetConfig(config *model.Config) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.config = config.Clone()
+}
+
+// GetConfig returns a copy of the server configuration.
+func (a *API) GetConfig() *model.Config {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.config.Clone()
+}
+
+// SetPluginConfig replaces the plugin's settings, as keyed in the server configuration.
+func (a *API) SetPluginConfig(config map[string]any) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.pluginConfig = cloneConfig(config)
+}
+
+// GetPluginConfig returns a copy of the plugin's settings.
+func (a *API) GetPluginConfig() map[string]any {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return cloneConfig(a.pluginConfig)
+}
+
+// SavePluginConfig replaces the plugin's settings. Unlike the server, it does not call
+// OnConfigurationChange.
+func (a *API) SavePluginConfig(config map[string]any) *model.AppError {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if appErr := a.failure("SavePluginConfig"); appErr != nil {
+		return appErr
+	}
+	a.pluginConfig = cloneConfig(config)
+	return nil
+}
+
+// LoadPluginConfiguration decodes the plugin's settings into dest the way the server does, matching
+// setting keys to fields case-insensitively.
+func (a *API) LoadPluginConfiguration(dest any) error {
+	a.mu.Lock()
+	data, err := json.Marshal(a.pluginConfig)
+	a.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, dest)
+}
+
+func cloneConfig(config map[string]any) map[string]any {
+	clone := make(map[string]any, len(config))
+	for key, value := range config {
+		clone[key] = value
+	}
+	return clone
+}
+
+// RegisterCommand records command.
+func (a *API) RegisterCommand(command *model.Command) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.commands = append(a.commands, command)
+	return nil
+}
+
+// Commands returns the registered slash commands.
+func (a *API) Commands() []*model.Command {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return slices.Clone(a.commands)
+}
+
+func (a *API) log(level, msg string, keyValuePairs []any) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.logs = append(a.logs, LogEntry{Level: level, Message: msg, KeyValuePairs: keyValuePairs})
+}
+
+// LogDebug records a debug message.
+func (a *API) LogDebug(msg string, keyValuePairs ...any) {
+	a.log("debug", msg, keyValuePairs)
+}
+
+// LogInfo records an info message.
+func (a *API) LogInfo(msg string, keyValuePairs ...any) {
+	a.log("info", msg, keyValuePairs)
+}
+
+// LogWarn records a warning.
+func (a *API) LogWarn(msg string, keyValuePairs ...any) {
+	a.log("warn", msg, keyValuePairs)
+}
+
+// LogError records an error.
+func (a *API) LogError(msg string, keyValuePairs ...any) {
+	a.log("error", msg, keyValuePairs)
+}
+
+// Logs returns the messages logged at level, or all messages if level is empty.
+func (a *API) Logs(level string) []LogEntry {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	var logs []LogEntry
+	for _, entry := range a.logs {
+		if level == "" || entry.Level == level {
+			logs = append(logs, entry)
+		}
+	}
+	return logs
+}
@@ -0,0 +1,131 @@
+package fakeapi
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/pluginapi"
+	"github.com/mattermost/mattermost/server/public/pluginapi/cluster"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUsers(t *testing.T) {
+	api := New()
+	client := pluginapi.NewClient(api, nil)
+	alice := api.AddUser(&model.User{Username: "Alice", Email: "Alice@example.com"})
+	api.AddUser(&model.User{Username: "bob", Email: "bob@example.com", DeleteAt: 1})
+	admin := api.AddUser(&model.User{Username: "admin", Roles: model.SystemAdminRoleId + " " + model.SystemUserRoleId})
+
+	user, err := client.User.GetByEmail("alice@EXAMPLE.com")
+	require.NoError(t, err)
+	assert.Equal(t, alice.Id, user.Id)
+	_, err = client.User.GetByUsername("carol")
+	assert.ErrorIs(t, err, pluginapi.ErrNotFound)
+
+	users, err := client.User.List(&model.UserGetOptions{Page: 0, PerPage: 10, Active: true})
+	require.NoError(t, err)
+	require.Len(t, users, 2)
+	assert.Equal(t, "admin", users[0].Username)
+
+	user.SetProp("attr_department", "Legal")
+	require.NoError(t, client.User.Update(user))
+	assert.Equal(t, "Legal", api.User(alice.Id).Props["attr_department"])
+
+	assert.True(t, client.User.HasPermissionTo(admin.Id, model.PermissionManageSystem))
+	assert.False(t, client.User.HasPermissionTo(alice.Id, model.PermissionManageSystem))
+
+	api.Fail("UpdateUser", model.NewAppError("UpdateUser", "boom", nil, "", http.StatusInternalServerError))
+	assert.Error(t, client.User.Update(user))
+}
+
+func TestKV(t *testing.T) {
+	api := New()
+	now := time.Now()
+	api.SetClock(func() time.Time { return now })
+	kv := pluginapi.NewClient(api, nil).KV
+
+	written, err := kv.Set("key", []byte("v1"), pluginapi.SetAtomic(nil))
+	require.NoError(t, err)
+	assert.True(t, written)
+	written, err = kv.Set("key", []byte("v2"), pluginapi.SetAtomic(nil))
+	require.NoError(t, err)
+	assert.False(t, written, "an atomic write expecting no value fails if there is one")
+	written, err = kv.Set("key", []byte("v2"), pluginapi.SetAtomic([]byte("v1")))
+	require.NoError(t, err)
+	assert.True(t, written)
+	assert.Equal(t, []byte("v2"), api.KVValue("key"))
+
+	_, err = kv.Set("expiring", []byte("v"), pluginapi.SetExpiry(time.Minute))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"expiring", "key"}, api.KVKeys())
+	now = now.Add(time.Minute)
+	assert.Equal(t, []string{"key"}, api.KVKeys(), "expired values are gone")
+
+	require.NoError(t, kv.Delete("key"))
+	assert.Empty(t, api.KVKeys())
+}
+
+func TestClusterMutex(t *testing.T) {
+	api := New()
+	first, err := cluster.NewMutex(api, "lock")
+	require.NoError(t, err)
+	second, err := cluster.NewMutex(api, "lock")
+	require.NoError(t, err)
+
+	first.Lock()
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	assert.Error(t, second.LockWithContext(ctx), "a held mutex cannot be locked")
+
+	first.Unlock()
+	second.Lock()
+	second.Unlock()
+}
+
+func TestBotPosts(t *testing.T) {
+	api := New()
+	client := pluginapi.NewClient(api, nil)
+	admin := api.AddUser(&model.User{Username: "admin"})
+
+	botID, err := client.Bot.EnsureBot(&model.Bot{Username: "bot", DisplayName: "Bot"})
+	require.NoError(t, err)
+	again, err := client.Bot.EnsureBot(&model.Bot{Username: "bot", DisplayName: "Bot"})
+	require.NoError(t, err)
+	assert.Equal(t, botID, again)
+
+	require.NoError(t, client.Post.DM(botID, admin.Id, &model.Post{Message: "hello"}))
+	posts := api.DirectPosts(admin.Id, botID)
+	require.Len(t, posts, 1)
+	assert.Equal(t, "hello", posts[0].Message)
+	assert.Equal(t, botID, posts[0].UserId)
+}
+
+func TestTeams(t *testing.T) {
+	api := New()
+	client := pluginapi.NewClient(api, nil)
+	alice := api.AddUser(&model.User{Username: "alice"})
+	team := api.AddTeam(&model.Team{Name: "eng"})
+
+	found, err := client.Team.GetByName("eng")
+	require.NoError(t, err)
+	assert.Equal(t, team.Id, found.Id)
+	_, err = client.Team.GetByName("sales")
+	assert.ErrorIs(t, err, pluginapi.ErrNotFound)
+
+	_, err = client.Team.GetMember(team.Id, alice.Id)
+	assert.ErrorIs(t, err, pluginapi.ErrNotFound)
+	_, err = client.Team.CreateMember(team.Id, alice.Id)
+	require.NoError(t, err)
+	member, err := client.Team.UpdateMemberRoles(team.Id, alice.Id, model.TeamUserRoleId+" "+model.TeamAdminRoleId)
+	require.NoError(t, err)
+	assert.Equal(t, model.TeamUserRoleId+" "+model.TeamAdminRoleId, member.Roles)
+
+	require.NoError(t, client.Team.DeleteMember(team.Id, alice.Id, ""))
+	member, err = client.Team.GetMember(team.Id, alice.Id)
+	require.NoError(t, err)
+	assert.NotZero(t, member.DeleteAt)
+}
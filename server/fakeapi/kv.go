@@ -0,0 +1,161 @@
+package fakeapi
+
+import (
+	"bytes"
+	"slices"
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+// kvEntry is a stored KV value and the time it expires at, zero if it never does.
+type kvEntry struct {
+	value     []byte
+	expiresAt time.Time
+}
+
+// entry returns the live value under key. It must be called with mu held.
+func (a *API) entry(key string) ([]byte, bool) {
+	entry, ok := a.kv[key]
+	if !ok {
+		return nil, false
+	}
+	if !entry.expiresAt.IsZero() && !a.now().Before(entry.expiresAt) {
+		a.deleteKey(key)
+		return nil, false
+	}
+	return entry.value, true
+}
+
+// KVValue returns the raw value stored under key, or nil if there is none.
+func (a *API) KVValue(key string) []byte {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	value, _ := a.entry(key)
+	return bytes.Clone(value)
+}
+
+// SetKVValue stores value under key without expiry, bypassing failures injected with Fail.
+func (a *API) SetKVValue(key string, value []byte) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.setKey(key, kvEntry{value: bytes.Clone(value)})
+}
+
+// setKey stores entry under key. It must be called with mu held.
+func (a *API) setKey(key string, entry kvEntry) {
+	if _, ok := a.kv[key]; !ok {
+		a.sortedKeys = nil
+	}
+	a.kv[key] = entry
+	if entry.expiresAt.IsZero() {
+		delete(a.expiring, key)
+	} else {
+		a.expiring[key] = true
+	}
+}
+
+// deleteKey removes key. It must be called with mu held.
+func (a *API) deleteKey(key string) {
+	if _, ok := a.kv[key]; ok {
+		a.sortedKeys = nil
+	}
+	delete(a.kv, key)
+	delete(a.expiring, key)
+}
+
+// KVGet returns the value stored under key, or nil if there is none.
+func (a *API) KVGet(key string) ([]byte, *model.AppError) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if appErr := a.failure("KVGet"); appErr != nil {
+		return nil, appErr
+	}
+	value, _ := a.entry(key)
+	return bytes.Clone(value), nil
+}
+
+// KVSetWithOptions stores value under key, or deletes key if value is nil. Atomic writes only
+// succeed if the current value equals options.OldValue, where a nil OldValue matches a missing
+// key.
+func (a *API) KVSetWithOptions(key string, value []byte, options model.PluginKVSetOptions) (bool, *model.AppError) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if appErr := a.failure("KVSetWithOptions"); appErr != nil {
+		return false, appErr
+	}
+
+	if options.Atomic {
+		current, ok := a.entry(key)
+		if ok != (options.OldValue != nil) || !bytes.Equal(current, options.OldValue) {
+			return false, nil
+		}
+	}
+	if value == nil {
+		a.deleteKey(key)
+		return true, nil
+	}
+
+	entry := kvEntry{value: bytes.Clone(value)}
+	if options.ExpireInSeconds > 0 {
+		entry.expiresAt = a.now().Add(time.Duration(options.ExpireInSeconds) * time.Second)
+	}
+	a.setKey(key, entry)
+	return true, nil
+}
+
+// KVDelete removes key.
+func (a *API) KVDelete(key string) *model.AppError {
+	_, appErr := a.KVSetWithOptions(key, nil, model.PluginKVSetOptions{})
+	return appErr
+}
+
+// KVDeleteAll removes every key.
+func (a *API) KVDeleteAll() *model.AppError {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if appErr := a.failure("KVDeleteAll"); appErr != nil {
+		return appErr
+	}
+	a.kv = map[string]kvEntry{}
+	a.sortedKeys = nil
+	clear(a.expiring)
+	return nil
+}
+
+// KVList returns a page of the stored keys in lexical order.
+func (a *API) KVList(page, perPage int) ([]string, *model.AppError) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if appErr := a.failure("KVList"); appErr != nil {
+		return nil, appErr
+	}
+
+	keys := a.keys()
+	start := min(page*perPage, len(keys))
+	return keys[start:min(start+perPage, len(keys))], nil
+}
+
+// KVKeys returns every stored key in lexical order.
+func (a *API) KVKeys() []string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return slices.Clone(a.keys())
+}
+
+// keys returns the live keys in lexical order. The slice must not be modified. It must be called
+// with mu held.
+func (a *API) keys() []string {
+	for key := range a.expiring {
+		// Drops the key if it expired.
+		a.entry(key)
+	}
+	if a.sortedKeys == nil {
+		a.sortedKeys = make([]string, 0, len(a.kv))
+		for key := range a.kv {
+			a.sortedKeys = append(a.sortedKeys, key)
+		}
+		slices.Sort(a.sortedKeys)
+	}
+	return a.sortedKeys
+}
@@ -0,0 +1,125 @@
+package fakeapi
+
+import (
+	"strings"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+// botIDKey is the reserved KV key under which the server records the ID of the bot a plugin
+// ensured, as raw bytes rather than JSON.
+const botIDKey = "mmi_botid"
+
+// EnsureBotUser returns the user ID of the bot with bot's username, creating the bot and its user
+// if there is none. Like the server, it records the bot's ID under botIDKey.
+func (a *API) EnsureBotUser(bot *model.Bot) (string, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if appErr := a.failure("EnsureBotUser"); appErr != nil {
+		return "", appErr
+	}
+
+	for _, existing := range a.bots {
+		if strings.EqualFold(existing.Username, bot.Username) {
+			a.setKey(botIDKey, kvEntry{value: []byte(existing.UserId)})
+			return existing.UserId, nil
+		}
+	}
+
+	user := a.addUser(model.UserFromBot(bot))
+	created := bot.Clone()
+	created.UserId = user.Id
+	created.CreateAt = user.CreateAt
+	created.UpdateAt = user.CreateAt
+	a.bots[created.UserId] = created
+	a.setKey(botIDKey, kvEntry{value: []byte(created.UserId)})
+	return created.UserId, nil
+}
+
+// GetBot returns the bot with botUserID.
+func (a *API) GetBot(botUserID string, includeDeleted bool) (*model.Bot, *model.AppError) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	bot, ok := a.bots[botUserID]
+	if !ok || (bot.DeleteAt != 0 && !includeDeleted) {
+		return nil, notFound("GetBot", botUserID)
+	}
+	return bot.Clone(), nil
+}
+
+// GetDirectChannel returns the direct channel between the two users, creating it if needed.
+func (a *API) GetDirectChannel(userID1, userID2 string) (*model.Channel, *model.AppError) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if appErr := a.failure("GetDirectChannel"); appErr != nil {
+		return nil, appErr
+	}
+	for _, userID := range []string{userID1, userID2} {
+		if _, ok := a.users[userID]; !ok {
+			return nil, notFound("GetDirectChannel", userID)
+		}
+	}
+
+	name := model.GetDMNameFromIds(userID1, userID2)
+	for _, channel := range a.channels {
+		if channel.Name == name {
+			return channel.DeepCopy(), nil
+		}
+	}
+	channel := &model.Channel{
+		Id:       model.NewId(),
+		Type:     model.ChannelTypeDirect,
+		Name:     name,
+		CreateAt: model.GetMillisForTime(a.now()),
+	}
+	a.channels[channel.Id] = channel
+	return channel.DeepCopy(), nil
+}
+
+// CreatePost stores post. Posts may be created in any channel ID, not only in channels the fake
+// knows about.
+func (a *API) CreatePost(post *model.Post) (*model.Post, *model.AppError) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if appErr := a.failure("CreatePost"); appErr != nil {
+		return nil, appErr
+	}
+
+	created := post.Clone()
+	created.Id = model.NewId()
+	created.CreateAt = model.GetMillisForTime(a.now())
+	created.UpdateAt = created.CreateAt
+	a.posts = append(a.posts, created)
+	return created.Clone(), nil
+}
+
+// Posts returns the posts created in channelID, oldest first.
+func (a *API) Posts(channelID string) []*model.Post {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	var posts []*model.Post
+	for _, post := range a.posts {
+		if post.ChannelId == channelID {
+			posts = append(posts, post.Clone())
+		}
+	}
+	return posts
+}
+
+// DirectPosts returns the posts in the direct channel between the two users, oldest first.
+func (a *API) DirectPosts(userID1, userID2 string) []*model.Post {
+	a.mu.Lock()
+	name := model.GetDMNameFromIds(userID1, userID2)
+	var channelID string
+	for _, channel := range a.channels {
+		if channel.Name == name {
+			channelID = channel.Id
+		}
+	}
+	a.mu.Unlock()
+
+	if channelID == "" {
+		return nil
+	}
+	return a.Posts(channelID)
+}
@@ -0,0 +1,105 @@
+package fakeapi
+
+import "github.com/mattermost/mattermost/server/public/model"
+
+// AddTeam stores a copy of team, filling in an ID when it is missing, and returns the stored team.
+func (a *API) AddTeam(team *model.Team) *model.Team {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	stored := *team
+	if stored.Id == "" {
+		stored.Id = model.NewId()
+	}
+	a.teams[stored.Id] = &stored
+	copied := stored
+	return &copied
+}
+
+// GetTeamByName returns the team named name.
+func (a *API) GetTeamByName(name string) (*model.Team, *model.AppError) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if appErr := a.failure("GetTeamByName"); appErr != nil {
+		return nil, appErr
+	}
+	for _, team := range a.teams {
+		if team.Name == name {
+			copied := *team
+			return &copied, nil
+		}
+	}
+	return nil, notFound("GetTeamByName", name)
+}
+
+// GetTeamMember returns the membership of the user with userID in the team with teamID.
+// Memberships that were deleted are returned with DeleteAt set, as the server does.
+func (a *API) GetTeamMember(teamID, userID string) (*model.TeamMember, *model.AppError) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if appErr := a.failure("GetTeamMember"); appErr != nil {
+		return nil, appErr
+	}
+	member, ok := a.teamMembers[teamMemberKey{teamID, userID}]
+	if !ok {
+		return nil, notFound("GetTeamMember", userID)
+	}
+	copied := *member
+	return &copied, nil
+}
+
+// CreateTeamMember adds the user with userID to the team with teamID as a team user.
+func (a *API) CreateTeamMember(teamID, userID string) (*model.TeamMember, *model.AppError) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if appErr := a.failure("CreateTeamMember"); appErr != nil {
+		return nil, appErr
+	}
+	if _, ok := a.teams[teamID]; !ok {
+		return nil, notFound("CreateTeamMember", teamID)
+	}
+	if _, ok := a.users[userID]; !ok {
+		return nil, notFound("CreateTeamMember", userID)
+	}
+	member := &model.TeamMember{TeamId: teamID, UserId: userID, Roles: model.TeamUserRoleId, SchemeUser: true}
+	a.teamMembers[teamMemberKey{teamID, userID}] = member
+	copied := *member
+	return &copied, nil
+}
+
+// DeleteTeamMember removes the user with userID from the team with teamID, keeping the membership
+// with DeleteAt set.
+func (a *API) DeleteTeamMember(teamID, userID, _ string) *model.AppError {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if appErr := a.failure("DeleteTeamMember"); appErr != nil {
+		return appErr
+	}
+	member, ok := a.teamMembers[teamMemberKey{teamID, userID}]
+	if !ok {
+		return notFound("DeleteTeamMember", userID)
+	}
+	member.DeleteAt = model.GetMillisForTime(a.now())
+	return nil
+}
+
+// UpdateTeamMemberRoles replaces the roles of the user with userID in the team with teamID.
+func (a *API) UpdateTeamMemberRoles(teamID, userID, newRoles string) (*model.TeamMember, *model.AppError) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if appErr := a.failure("UpdateTeamMemberRoles"); appErr != nil {
+		return nil, appErr
+	}
+	member, ok := a.teamMembers[teamMemberKey{teamID, userID}]
+	if !ok {
+		return nil, notFound("UpdateTeamMemberRoles", userID)
+	}
+	member.Roles = newRoles
+	member.SchemeAdmin = false
+	copied := *member
+	return &copied, nil
+}
+
+// teamMemberKey identifies the membership of a user in a team.
+type teamMemberKey struct {
+	teamID, userID string
+}
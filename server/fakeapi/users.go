@@ -0,0 +1,188 @@
+package fakeapi
+
+import (
+	"slices"
+	"strings"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+// AddUser stores a copy of user, filling in an ID, the system_user role and timestamps when they
+// are missing, and returns the stored user. Emails and usernames are lowercased as the server does.
+func (a *API) AddUser(user *model.User) *model.User {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.addUser(user).DeepCopy()
+}
+
+func (a *API) addUser(user *model.User) *model.User {
+	stored := user.DeepCopy()
+	if stored.Id == "" {
+		stored.Id = model.NewId()
+	}
+	if stored.Roles == "" {
+		stored.Roles = model.SystemUserRoleId
+	}
+	if stored.CreateAt == 0 {
+		stored.CreateAt = model.GetMillisForTime(a.now())
+		stored.UpdateAt = stored.CreateAt
+	}
+	stored.Email = strings.ToLower(stored.Email)
+	stored.Username = strings.ToLower(stored.Username)
+	a.storeUser(stored)
+	return stored
+}
+
+// storeUser stores user and indexes it. It must be called with mu held.
+func (a *API) storeUser(user *model.User) {
+	if current, ok := a.users[user.Id]; ok {
+		delete(a.usersByEmail, strings.ToLower(current.Email))
+		delete(a.usersByUsername, strings.ToLower(current.Username))
+		if current.Username != user.Username || current.DeleteAt != user.DeleteAt {
+			clear(a.userLists)
+		}
+	} else {
+		clear(a.userLists)
+	}
+	a.users[user.Id] = user
+	a.usersByEmail[strings.ToLower(user.Email)] = user.Id
+	a.usersByUsername[strings.ToLower(user.Username)] = user.Id
+}
+
+// User returns a copy of the user with userID, or nil if there is none.
+func (a *API) User(userID string) *model.User {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	user, ok := a.users[userID]
+	if !ok {
+		return nil
+	}
+	return user.DeepCopy()
+}
+
+// GetUser returns the user with userID.
+func (a *API) GetUser(userID string) (*model.User, *model.AppError) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if appErr := a.failure("GetUser"); appErr != nil {
+		return nil, appErr
+	}
+	user, ok := a.users[userID]
+	if !ok {
+		return nil, notFound("GetUser", userID)
+	}
+	return user.DeepCopy(), nil
+}
+
+// GetUserByEmail returns the user with email, ignoring case.
+func (a *API) GetUserByEmail(email string) (*model.User, *model.AppError) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if appErr := a.failure("GetUserByEmail"); appErr != nil {
+		return nil, appErr
+	}
+	userID, ok := a.usersByEmail[strings.ToLower(email)]
+	if !ok {
+		return nil, notFound("GetUserByEmail", email)
+	}
+	return a.users[userID].DeepCopy(), nil
+}
+
+// GetUserByUsername returns the user with username, ignoring case.
+func (a *API) GetUserByUsername(username string) (*model.User, *model.AppError) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if appErr := a.failure("GetUserByUsername"); appErr != nil {
+		return nil, appErr
+	}
+	userID, ok := a.usersByUsername[strings.ToLower(username)]
+	if !ok {
+		return nil, notFound("GetUserByUsername", username)
+	}
+	return a.users[userID].DeepCopy(), nil
+}
+
+// GetUsers returns a page of users sorted by username. Of the options, only paging and the
+// Active and Inactive filters are supported.
+func (a *API) GetUsers(options *model.UserGetOptions) ([]*model.User, *model.AppError) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if appErr := a.failure("GetUsers"); appErr != nil {
+		return nil, appErr
+	}
+
+	userIDs := a.userList(userFilter{active: options.Active, inactive: options.Inactive})
+	start := min(options.Page*options.PerPage, len(userIDs))
+	page := userIDs[start:min(start+options.PerPage, len(userIDs))]
+	result := make([]*model.User, 0, len(page))
+	for _, userID := range page {
+		result = append(result, a.users[userID].DeepCopy())
+	}
+	return result, nil
+}
+
+// userFilter selects the users listed by GetUsers.
+type userFilter struct {
+	active   bool
+	inactive bool
+}
+
+// userList returns the IDs of the users matching filter, sorted by username. It must be called
+// with mu held.
+func (a *API) userList(filter userFilter) []string {
+	if userIDs, ok := a.userLists[filter]; ok {
+		return userIDs
+	}
+
+	userIDs := make([]string, 0, len(a.users))
+	for userID, user := range a.users {
+		deleted := user.DeleteAt != 0
+		if (filter.active && deleted) || (filter.inactive && !deleted) {
+			continue
+		}
+		userIDs = append(userIDs, userID)
+	}
+	slices.SortFunc(userIDs, func(x, y string) int {
+		return strings.Compare(a.users[x].Username, a.users[y].Username)
+	})
+	a.userLists[filter] = userIDs
+	return userIDs
+}
+
+// UpdateUser replaces the stored user with the same ID.
+func (a *API) UpdateUser(user *model.User) (*model.User, *model.AppError) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if appErr := a.failure("UpdateUser"); appErr != nil {
+		return nil, appErr
+	}
+	current, ok := a.users[user.Id]
+	if !ok {
+		return nil, notFound("UpdateUser", user.Id)
+	}
+
+	updated := user.DeepCopy()
+	updated.CreateAt = current.CreateAt
+	updated.UpdateAt = model.GetMillisForTime(a.now())
+	a.storeUser(updated)
+	return updated.DeepCopy(), nil
+}
+
+// SetProfileImage accepts any image for an existing user.
+func (a *API) SetProfileImage(userID string, _ []byte) *model.AppError {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if _, ok := a.users[userID]; !ok {
+		return notFound("SetProfileImage", userID)
+	}
+	return nil
+}
+
+// HasPermissionTo reports whether the user is a system admin. Permissions are not modelled
+// otherwise: system admins hold all of them and other users none.
+func (a *API) HasPermissionTo(userID string, _ *model.Permission) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	user, ok := a.users[userID]
+	return ok && user.IsSystemAdmin()
+}
@@ -1,6 +1,345 @@
 package main
 
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/pluginapi"
+	"github.com/pkg/errors"
+
+	"github.com/mattermost/mattermost-plugin-starter-template/server/attrsync"
+	"github.com/mattermost/mattermost-plugin-starter-template/server/expr"
+	"github.com/mattermost/mattermost-plugin-starter-template/server/membership"
+	"github.com/mattermost/mattermost-plugin-starter-template/server/retention"
+	"github.com/mattermost/mattermost-plugin-starter-template/server/store/kvstore"
+)
+
 func (p *Plugin) runJob() {
-	// Include job logic here
-	p.API.LogInfo("Job is currently running")
+	run := p.newSyncRun(kvstore.SyncTriggerScheduled)
+	engine, err := p.newSyncEngine(run)
+	if err != nil {
+		p.API.LogError("Failed to set up attribute sync", "err", err)
+		return
+	}
+	if engine == nil {
+		p.API.LogDebug("Skipping attribute sync, no source is configured")
+		return
+	}
+
+	if err = run.lock(); err != nil {
+		var running syncRunningError
+		if errors.As(err, &running) {
+			p.API.LogInfo("Skipping attribute sync, another sync is running", "reason", err.Error())
+			return
+		}
+		p.API.LogError("Failed to lock attribute sync", "err", err)
+		return
+	}
+	defer run.unlock()
+
+	result, err := engine.Run(p.jobContext)
+	if err != nil {
+		if errors.Is(err, attrsync.ErrRunCancelled) {
+			p.API.LogInfo("Attribute sync cancelled", "run_id", result.RunID, "source", result.Source, "fetched", result.Fetched, "updated", result.Updated)
+			return
+		}
+		if errors.Is(err, context.Canceled) {
+			if result != nil {
+				p.API.LogInfo("Attribute sync interrupted, it will resume on the next run", "run_id", result.RunID, "source", result.Source)
+				return
+			}
+			p.API.LogInfo("Attribute sync interrupted, it will resume on the next run")
+			return
+		}
+		if errors.Is(err, attrsync.ErrGuardrailTripped) {
+			p.API.LogError("Attribute sync aborted by a safety threshold, no changes were written", "run_id", result.RunID, "source", result.Source, "err", err)
+			p.notifyAdmins(fmt.Sprintf("#### :no_entry: Attribute sync aborted\nRun `%s` was aborted by a safety threshold and made no changes: %s\n\nDetails: %s",
+				result.RunID, err.Error(), p.runDetailURL(result.RunID)))
+			return
+		}
+		if result != nil {
+			p.API.LogError("Attribute sync failed", "run_id", result.RunID, "source", result.Source, "err", err)
+			p.notifyAdmins(fmt.Sprintf("#### :warning: Attribute sync failed\nRun `%s` failed after fetching %d records and updating %d users: %s\n\nDetails: %s",
+				result.RunID, result.Fetched, result.Updated, err.Error(), p.runDetailURL(result.RunID)))
+			return
+		}
+		p.API.LogError("Attribute sync failed", "err", err)
+		p.notifyAdmins(fmt.Sprintf("#### :warning: Attribute sync failed\nThe sync could not start: %s", err.Error()))
+		return
+	}
+
+	p.API.LogInfo("Attribute sync completed",
+		"run_id", result.RunID,
+		"source", result.Source,
+		"resumed", result.Resumed,
+		"full", result.Full,
+		"fetched", result.Fetched,
+		"excluded", result.Excluded,
+		"matched", result.Matched,
+		"updated", result.Updated,
+		"unmatched", result.Unmatched,
+		"failed", result.Failed,
+		"quarantined", result.Quarantined,
+		"reset", result.Reset,
+	)
+
+	p.applyMembershipRules()
+
+	if len(result.NewUnmappedFields) > 0 {
+		p.notifyAdmins(fmt.Sprintf("#### Attribute sync found new source fields\nThe source reported fields that are not mapped to any attribute: `%s`. Add them to the field mappings to sync them.\n\nDetails: %s",
+			strings.Join(result.NewUnmappedFields, "`, `"), p.runDetailURL(result.RunID)))
+	}
+}
+
+// errNoSyncSource is returned by on-demand sync operations when no source is configured.
+var errNoSyncSource = errors.New("no sync source is configured")
+
+// PreviewUserSync works out what a sync would change for a single user without writing anything.
+func (p *Plugin) PreviewUserSync(ctx context.Context, userID string) (*attrsync.Preview, error) {
+	engine, err := p.newSyncEngine(nil)
+	if err != nil {
+		return nil, err
+	}
+	if engine == nil {
+		return nil, errNoSyncSource
+	}
+	return engine.Preview(ctx, userID)
+}
+
+// StartUserSync starts syncing the users identified by ID, email or username, outside of the
+// schedule, and returns the ID of the run once it has started. It fails with a syncRunningError
+// if another sync is running.
+//
+// The run continues in the background and stops early if the plugin is deactivated or it is
+// cancelled through CancelSync. Membership rules are applied once it succeeds, before the sync
+// lock is released. requesterID is sent a direct message with its outcome.
+func (p *Plugin) StartUserSync(identifiers []string, requesterID string) (string, error) {
+	userIDs, err := p.resolveUsers(identifiers)
+	if err != nil {
+		return "", err
+	}
+	run := p.newSyncRun(kvstore.SyncTriggerManual)
+	engine, err := p.newSyncEngine(run)
+	if err != nil {
+		return "", err
+	}
+	if engine == nil {
+		return "", errNoSyncSource
+	}
+
+	if err = run.lock(); err != nil {
+		return "", err
+	}
+	go func() {
+		defer run.unlock()
+		result, err := engine.SyncUsers(p.jobContext, userIDs)
+		if err == nil {
+			// Memberships follow the new attributes now rather than after the next scheduled run.
+			p.applyMembershipRules()
+		}
+		p.reportUserSync(requesterID, userIDs, result, err)
+	}()
+
+	<-run.started
+	return run.runID(), nil
+}
+
+// reportUserSync logs the outcome of a manual sync and tells the user who requested it. Failures
+// are also reported to the admins, as for scheduled runs.
+func (p *Plugin) reportUserSync(requesterID string, userIDs []string, result *attrsync.Result, err error) {
+	var message string
+	switch {
+	case errors.Is(err, attrsync.ErrRunCancelled):
+		p.API.LogInfo("Manual attribute sync cancelled", "run_id", result.RunID, "source", result.Source)
+		message = fmt.Sprintf("Manual sync run `%s` was cancelled before it changed anything.", result.RunID)
+	case err != nil:
+		p.API.LogError("Manual attribute sync failed", "run_id", result.RunID, "source", result.Source, "err", err)
+		message = fmt.Sprintf("Manual sync run `%s` failed after updating %d users: %s\n\nDetails: %s",
+			result.RunID, result.Updated, err.Error(), p.runDetailURL(result.RunID))
+		if !errors.Is(err, context.Canceled) {
+			p.notifyAdmins(fmt.Sprintf("#### :warning: Attribute sync failed\nManual run `%s` of %d users failed after updating %d users: %s\n\nDetails: %s",
+				result.RunID, len(userIDs), result.Updated, err.Error(), p.runDetailURL(result.RunID)))
+		}
+	default:
+		p.API.LogInfo("Manual attribute sync completed",
+			"run_id", result.RunID,
+			"source", result.Source,
+			"users", len(userIDs),
+			"not_found", len(result.NotFound),
+			"excluded", result.Excluded,
+			"matched", result.Matched,
+			"updated", result.Updated,
+			"failed", result.Failed,
+			"quarantined", result.Quarantined,
+		)
+		var sb strings.Builder
+		fmt.Fprintf(&sb, "Manual sync run `%s` finished: matched %d, updated %d, excluded %d, failed %d, quarantined %d.\n",
+			result.RunID, result.Matched, result.Updated, result.Excluded, result.Failed, result.Quarantined)
+		if len(result.NotFound) > 0 {
+			fmt.Fprintf(&sb, "The source has no record for %d of the users: `%s`\n", len(result.NotFound), strings.Join(result.NotFound, "`, `"))
+		}
+		if result.Failed > 0 {
+			sb.WriteString("Use `/attrsync status` to see why records failed.\n")
+		}
+		message = sb.String()
+	}
+	p.notifyUser(requesterID, message)
+}
+
+// unknownUsersError lists the identifiers passed to StartUserSync that match no user.
+type unknownUsersError []string
+
+func (e unknownUsersError) Error() string {
+	return "no user found for " + strings.Join(e, ", ")
+}
+
+// resolveUsers returns the IDs of the users identified by ID, email or @-prefixed or plain
+// username, without duplicates.
+func (p *Plugin) resolveUsers(identifiers []string) ([]string, error) {
+	var (
+		userIDs []string
+		unknown unknownUsersError
+		seen    = map[string]bool{}
+	)
+	for _, identifier := range identifiers {
+		user, err := p.lookupUser(identifier)
+		if errors.Is(err, pluginapi.ErrNotFound) {
+			unknown = append(unknown, identifier)
+			continue
+		}
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to look up user %s", identifier)
+		}
+		if !seen[user.Id] {
+			seen[user.Id] = true
+			userIDs = append(userIDs, user.Id)
+		}
+	}
+	if len(unknown) > 0 {
+		return nil, unknown
+	}
+	return userIDs, nil
+}
+
+func (p *Plugin) lookupUser(identifier string) (*model.User, error) {
+	if strings.Contains(identifier, "@") && !strings.HasPrefix(identifier, "@") {
+		return p.client.User.GetByEmail(identifier)
+	}
+	if model.IsValidId(identifier) {
+		user, err := p.client.User.Get(identifier)
+		if !errors.Is(err, pluginapi.ErrNotFound) {
+			return user, err
+		}
+		// Usernames can look like IDs.
+	}
+	return p.client.User.GetByUsername(strings.TrimPrefix(identifier, "@"))
+}
+
+// newSyncEngine builds a sync engine from the active configuration, whose runs are cancelled
+// through canceller if it is not nil. It returns nil if no source is configured.
+func (p *Plugin) newSyncEngine(canceller attrsync.Canceller) (*attrsync.Engine, error) {
+	config := p.getConfiguration()
+	if config.SourceURL == "" {
+		return nil, nil
+	}
+
+	mappings, err := attrsync.ParseMappings(config.FieldMappings)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid field mappings")
+	}
+
+	var exclude *expr.Expression
+	if config.SourceExcludeFilter != "" {
+		if exclude, err = expr.Compile(config.SourceExcludeFilter); err != nil {
+			return nil, errors.Wrap(err, "invalid source exclude filter")
+		}
+	}
+
+	return attrsync.NewEngine(attrsync.Config{
+		Source:           attrsync.NewHTTPSource(config.SourceURL, config.SourceToken, config.SourceSinceParam),
+		Store:            p.kvstore,
+		Users:            &p.client.User,
+		Attributes:       attrsync.NewPropsAttributeStore(&p.client.User),
+		Log:              &p.client.Log,
+		Metrics:          p.metrics,
+		Mappings:         mappings,
+		Exclude:          exclude,
+		FullSyncInterval: time.Duration(config.FullSyncIntervalHours) * time.Hour,
+		Concurrency:      config.SyncConcurrency,
+		APIRateLimit:     float64(config.APIRequestsPerSecond),
+		Retry: attrsync.RetryPolicy{
+			Attempts:  config.RetryAttempts,
+			BaseDelay: attrsync.DefaultRetryPolicy.BaseDelay,
+			MaxDelay:  attrsync.DefaultRetryPolicy.MaxDelay,
+		},
+		QuarantineThreshold: config.QuarantineAfterFailures,
+		Guardrails: attrsync.Guardrails{
+			MaxChangedPercent: float64(config.MaxChangedPercent),
+			MaxClearedUsers:   config.MaxClearedUsers,
+			MinRecords:        config.MinSourceRecords,
+		},
+		Verbosity: attrsync.Verbosity(config.SyncLogVerbosity),
+		Canceller: canceller,
+	}), nil
+}
+
+// runRetentionJob deletes expired run history and state orphaned by interrupted runs and deleted
+// users.
+func (p *Plugin) runRetentionJob() {
+	cleaner := retention.NewCleaner(retention.Config{
+		Store:     p.kvstore,
+		Users:     &p.client.User,
+		Log:       &p.client.Log,
+		RunMaxAge: time.Duration(p.getConfiguration().RunHistoryRetentionDays) * 24 * time.Hour,
+	})
+	result, err := cleaner.Run(p.jobContext)
+	if err != nil {
+		p.API.LogError("Failed to clean up plugin state", "phase", "retention", "err", err)
+		return
+	}
+	p.API.LogInfo("Cleaned up plugin state",
+		"phase", "retention",
+		"runs", result.Runs,
+		"checkpoints", result.Checkpoints,
+		"user_states", result.UserStates,
+	)
+}
+
+// applyMembershipRules brings channel membership in line with the configured rules.
+func (p *Plugin) applyMembershipRules() {
+	rules, err := membership.ParseRules(p.getConfiguration().MembershipRules)
+	if err != nil {
+		p.API.LogError("Invalid membership rules", "err", err)
+		return
+	}
+	if len(rules) == 0 {
+		return
+	}
+
+	reconciler := membership.NewReconciler(membership.Config{
+		Users:       &p.client.User,
+		Teams:       &p.client.Team,
+		Channels:    &p.client.Channel,
+		Attributes:  attrsync.NewPropsAttributeStore(&p.client.User),
+		Store:       p.kvstore,
+		Log:         &p.client.Log,
+		RequestorID: p.botUserID,
+		Rules:       rules,
+	})
+	result, err := reconciler.Run(p.jobContext)
+	if err != nil {
+		p.API.LogError("Failed to apply membership rules", "phase", "membership", "err", err)
+		return
+	}
+	p.API.LogInfo("Applied membership rules",
+		"phase", "membership",
+		"added", result.Added,
+		"removed", result.Removed,
+		"promoted", result.Promoted,
+		"demoted", result.Demoted,
+		"failed", result.Failed,
+	)
 }
@@ -0,0 +1,460 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/pluginapi/cluster"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mattermost/mattermost-plugin-starter-template/server/fakeapi"
+	"github.com/mattermost/mattermost-plugin-starter-template/server/store/kvstore"
+)
+
+// testDirectory serves a user directory in the format read by attrsync.HTTPSource.
+type testDirectory struct {
+	mu      sync.Mutex
+	records []map[string]any
+}
+
+func (d *testDirectory) set(records ...map[string]any) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.records = records
+}
+
+func (d *testDirectory) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	_ = json.NewEncoder(w).Encode(d.records)
+}
+
+// pagedDirectory serves one record per page in the format read by attrsync.HTTPSource. Requests
+// for the pages listed in hold wait until release is closed.
+type pagedDirectory struct {
+	records []map[string]any
+	hold    map[int]bool
+	held    chan int
+	release chan struct{}
+}
+
+func (d *pagedDirectory) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+	if d.hold[page] {
+		d.held <- page
+		select {
+		case <-d.release:
+		case <-r.Context().Done():
+			return
+		}
+	}
+	if page+1 < len(d.records) {
+		w.Header().Set("Link", fmt.Sprintf(`<?page=%d>; rel="next"`, page+1))
+	}
+	_ = json.NewEncoder(w).Encode(d.records[page : page+1])
+}
+
+// setupPlugin activates the plugin against api and deactivates it when the test ends.
+func setupPlugin(t *testing.T, api *fakeapi.API) *Plugin {
+	t.Helper()
+	api.BundlePath = ".."
+
+	p := &Plugin{}
+	p.SetAPI(api)
+	require.NoError(t, p.OnConfigurationChange())
+	require.NoError(t, p.OnActivate())
+	t.Cleanup(func() {
+		assert.NoError(t, p.OnDeactivate())
+	})
+	return p
+}
+
+func TestSyncEndToEnd(t *testing.T) {
+	api := fakeapi.New()
+	admin := api.AddUser(&model.User{Username: "admin", Email: "admin@example.com", Roles: model.SystemAdminRoleId + " " + model.SystemUserRoleId})
+	alice := api.AddUser(&model.User{Username: "alice", Email: "alice@example.com"})
+	bob := api.AddUser(&model.User{Username: "bob", Email: "bob@example.com"})
+	team := api.AddTeam(&model.Team{Name: "marketing", DisplayName: "Marketing"})
+
+	directory := &testDirectory{}
+	directory.set(
+		map[string]any{"id": "1", "email": "alice@example.com", "username": "alice", "dept": "Engineering", "title": "Engineer"},
+		map[string]any{"id": "2", "email": "bob@example.com", "username": "bob", "dept": "Sales", "title": "Manager"},
+		map[string]any{"id": "3", "email": "carol@example.com", "username": "carol", "dept": "Legal", "title": "Counsel"},
+	)
+	source := httptest.NewServer(directory)
+	defer source.Close()
+
+	api.SetPluginConfig(map[string]any{
+		"sourceurl":       source.URL,
+		"fieldmappings":   `[{"source": "dept", "attribute": "department"}]`,
+		"syncconcurrency": 2,
+		"notifyusernames": "admin",
+		"membershiprules": `[{"name": "marketing", "match": {"department": "Marketing"}, "team": "marketing"}]`,
+	})
+	p := setupPlugin(t, api)
+
+	t.Run("scheduled sync runs on activation", func(t *testing.T) {
+		require.Eventually(t, func() bool {
+			run, err := p.kvstore.GetLastRun()
+			return err == nil && run != nil
+		}, 5*time.Second, 10*time.Millisecond)
+
+		assert.Equal(t, "Engineering", api.User(alice.Id).Props["attr_department"])
+		assert.Equal(t, "Sales", api.User(bob.Id).Props["attr_department"])
+		assert.Empty(t, api.User(admin.Id).Props["attr_department"])
+
+		require.Eventually(t, func() bool {
+			return len(api.DirectPosts(p.botUserID, admin.Id)) > 0
+		}, 5*time.Second, 10*time.Millisecond)
+		assert.Contains(t, api.DirectPosts(p.botUserID, admin.Id)[0].Message, "`title`", "admins are told about unmapped fields")
+
+		require.Eventually(t, func() bool {
+			return api.KVValue("mutex_"+syncMutexKey) == nil
+		}, 5*time.Second, 10*time.Millisecond, "the sync lock is released")
+	})
+
+	t.Run("manual sync through the API", func(t *testing.T) {
+		directory.set(
+			map[string]any{"id": "1", "email": "alice@example.com", "username": "alice", "dept": "Research"},
+			map[string]any{"id": "2", "email": "bob@example.com", "username": "bob", "dept": "Marketing"},
+		)
+
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodPost, "/api/v1/sync/users", strings.NewReader(`{"users": ["bob"]}`))
+		r.Header.Set("Mattermost-User-ID", alice.Id)
+		p.ServeHTTP(nil, w, r)
+		assert.Equal(t, http.StatusForbidden, w.Code)
+
+		w = httptest.NewRecorder()
+		r = httptest.NewRequest(http.MethodPost, "/api/v1/sync/users", strings.NewReader(`{"users": ["bob"]}`))
+		r.Header.Set("Mattermost-User-ID", admin.Id)
+		p.ServeHTTP(nil, w, r)
+		require.Equal(t, http.StatusAccepted, w.Code)
+
+		var response SyncUsersResponse
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&response))
+		require.NotEmpty(t, response.RunID)
+
+		getRun := func() *kvstore.SyncRun {
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodGet, "/api/v1/sync/runs/"+response.RunID, nil)
+			r.Header.Set("Mattermost-User-ID", admin.Id)
+			p.ServeHTTP(nil, w, r)
+			if w.Code != http.StatusOK {
+				return nil
+			}
+			var run kvstore.SyncRun
+			if err := json.NewDecoder(w.Body).Decode(&run); err != nil {
+				return nil
+			}
+			return &run
+		}
+		require.NotNil(t, getRun(), "the run is reported from the start")
+
+		var run *kvstore.SyncRun
+		require.Eventually(t, func() bool {
+			run = getRun()
+			return run != nil && run.Status != kvstore.RunStatusRunning
+		}, 5*time.Second, 10*time.Millisecond)
+		assert.Equal(t, kvstore.RunStatusSucceeded, run.Status)
+		assert.Equal(t, 1, run.Stats.Updated)
+		assert.Equal(t, "Marketing", api.User(bob.Id).Props["attr_department"])
+		assert.Equal(t, "Engineering", api.User(alice.Id).Props["attr_department"], "other users are left alone")
+
+		require.Eventually(t, func() bool {
+			for _, post := range api.DirectPosts(p.botUserID, admin.Id) {
+				if strings.HasPrefix(post.Message, "Manual sync run `"+response.RunID+"` finished: matched 1, updated 1") {
+					return true
+				}
+			}
+			return false
+		}, 5*time.Second, 10*time.Millisecond, "the requester is told the outcome")
+
+		member, appErr := api.GetTeamMember(team.Id, bob.Id)
+		require.Nil(t, appErr, "membership rules are applied before the requester is told")
+		assert.Zero(t, member.DeleteAt)
+		_, appErr = api.GetTeamMember(team.Id, alice.Id)
+		assert.NotNil(t, appErr)
+	})
+
+	t.Run("slash commands", func(t *testing.T) {
+		response, appErr := p.ExecuteCommand(nil, &model.CommandArgs{Command: "/attrsync preview @alice", UserId: admin.Id})
+		require.Nil(t, appErr)
+		assert.Contains(t, response.Text, "| department | dept | source | `Research` | `Engineering` | **`Research`** |")
+
+		response, appErr = p.ExecuteCommand(nil, &model.CommandArgs{Command: "/attrsync status", UserId: admin.Id})
+		require.Nil(t, appErr)
+		assert.Contains(t, response.Text, "**succeeded**")
+	})
+}
+
+func TestManualSyncFailure(t *testing.T) {
+	api := fakeapi.New()
+	admin := api.AddUser(&model.User{Username: "admin", Email: "admin@example.com", Roles: model.SystemAdminRoleId + " " + model.SystemUserRoleId})
+	ops := api.AddUser(&model.User{Username: "ops", Email: "ops@example.com"})
+	alice := api.AddUser(&model.User{Username: "alice", Email: "alice@example.com"})
+
+	source := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		http.Error(w, "directory unavailable", http.StatusBadRequest)
+	}))
+	defer source.Close()
+
+	api.SetPluginConfig(map[string]any{
+		"sourceurl":       source.URL,
+		"fieldmappings":   `[{"source": "dept", "attribute": "department"}]`,
+		"retryattempts":   1,
+		"notifyusernames": "ops",
+	})
+	p := setupPlugin(t, api)
+
+	// The scheduled sync started on activation fails too, and must finish before a manual one can
+	// start.
+	require.Eventually(t, func() bool {
+		run, err := p.kvstore.GetLastRun()
+		return err == nil && run != nil && api.KVValue("mutex_"+syncMutexKey) == nil
+	}, 5*time.Second, 10*time.Millisecond)
+
+	runID, err := p.StartUserSync([]string{"alice"}, admin.Id)
+	require.NoError(t, err)
+
+	hasPost := func(posts []*model.Post, prefix string) bool {
+		for _, post := range posts {
+			if strings.HasPrefix(post.Message, prefix) {
+				return true
+			}
+		}
+		return false
+	}
+	require.Eventually(t, func() bool {
+		return hasPost(api.DirectPosts(p.botUserID, admin.Id), "Manual sync run `"+runID+"` failed")
+	}, 5*time.Second, 10*time.Millisecond, "the requester is told the outcome")
+	assert.True(t, hasPost(api.DirectPosts(p.botUserID, ops.Id), "#### :warning: Attribute sync failed\nManual run `"+runID+"` of 1 users failed"),
+		"admins are told about failed manual runs")
+	assert.Empty(t, api.User(alice.Id).Props["attr_department"])
+}
+
+func TestSyncLock(t *testing.T) {
+	api := fakeapi.New()
+	admin := api.AddUser(&model.User{Username: "admin", Email: "admin@example.com", Roles: model.SystemAdminRoleId + " " + model.SystemUserRoleId})
+	api.AddUser(&model.User{Username: "alice", Email: "alice@example.com"})
+
+	directory := &testDirectory{}
+	directory.set(map[string]any{"id": "1", "email": "alice@example.com", "username": "alice", "dept": "Engineering"})
+	source := httptest.NewServer(directory)
+	defer source.Close()
+
+	api.SetPluginConfig(map[string]any{
+		"sourceurl":     source.URL,
+		"fieldmappings": `[{"source": "dept", "attribute": "department"}]`,
+	})
+	p := setupPlugin(t, api)
+	require.Eventually(t, func() bool {
+		return api.KVValue("mutex_"+syncMutexKey) == nil && api.KVValue("cron_BackgroundJob") != nil
+	}, 5*time.Second, 10*time.Millisecond, "the scheduled sync finishes")
+
+	syncUsers := func() *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodPost, "/api/v1/sync/users", strings.NewReader(`{"users": ["alice"]}`))
+		r.Header.Set("Mattermost-User-ID", admin.Id)
+		p.ServeHTTP(nil, w, r)
+		return w
+	}
+	// Manual syncs run in the background and release the lock once they finish.
+	waitForUnlock := func(t *testing.T) {
+		require.Eventually(t, func() bool {
+			return api.KVValue("mutex_"+syncMutexKey) == nil
+		}, 5*time.Second, 10*time.Millisecond, "the manual sync finishes")
+	}
+
+	t.Run("a manual sync is refused while another sync runs", func(t *testing.T) {
+		run := p.newSyncRun(kvstore.SyncTriggerScheduled)
+		require.NoError(t, run.lock())
+
+		w := syncUsers()
+		assert.Equal(t, http.StatusConflict, w.Code)
+		assert.Contains(t, w.Body.String(), "a scheduled sync is already running on node "+p.nodeID+" since ")
+
+		w = httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/api/v1/sync/status", nil)
+		r.Header.Set("Mattermost-User-ID", admin.Id)
+		p.ServeHTTP(nil, w, r)
+		require.Equal(t, http.StatusOK, w.Code)
+		var status SyncStatus
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&status))
+		require.NotNil(t, status.Running)
+		assert.Equal(t, p.nodeID, status.Running.NodeID)
+
+		response, appErr := p.ExecuteCommand(nil, &model.CommandArgs{Command: "/attrsync status", UserId: admin.Id})
+		require.Nil(t, appErr)
+		assert.Contains(t, response.Text, "A scheduled sync is running on node `"+p.nodeID+"`")
+
+		run.unlock()
+		assert.Equal(t, http.StatusAccepted, syncUsers().Code)
+		waitForUnlock(t)
+	})
+
+	t.Run("an archive import is refused while a sync runs", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/api/v1/archive", nil)
+		r.Header.Set("Mattermost-User-ID", admin.Id)
+		p.ServeHTTP(nil, w, r)
+		require.Equal(t, http.StatusOK, w.Code)
+		archive := w.Body.String()
+
+		importArchive := func() *httptest.ResponseRecorder {
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodPost, "/api/v1/archive?include=state", strings.NewReader(archive))
+			r.Header.Set("Mattermost-User-ID", admin.Id)
+			p.ServeHTTP(nil, w, r)
+			return w
+		}
+
+		_, appErr := api.KVSetWithOptions("added_after_export", []byte(`true`), model.PluginKVSetOptions{})
+		require.Nil(t, appErr)
+		run := p.newSyncRun(kvstore.SyncTriggerScheduled)
+		require.NoError(t, run.lock())
+
+		w = importArchive()
+		assert.Equal(t, http.StatusConflict, w.Code)
+		assert.Contains(t, w.Body.String(), "a scheduled sync is already running on node "+p.nodeID+" since ")
+		assert.NotNil(t, api.KVValue("added_after_export"), "state is left alone")
+
+		run.unlock()
+		assert.Equal(t, http.StatusNoContent, importArchive().Code)
+		assert.Nil(t, api.KVValue("added_after_export"))
+		assert.Nil(t, api.KVValue("mutex_"+syncMutexKey), "the import releases the sync lock")
+	})
+
+	t.Run("a lock taken before its holder is recorded", func(t *testing.T) {
+		mutex, err := cluster.NewMutex(api, syncMutexKey)
+		require.NoError(t, err)
+		mutex.Lock()
+
+		w := syncUsers()
+		assert.Equal(t, http.StatusConflict, w.Code)
+		assert.Contains(t, w.Body.String(), "a sync is already running")
+
+		mutex.Unlock()
+		assert.Equal(t, http.StatusAccepted, syncUsers().Code)
+		waitForUnlock(t)
+	})
+
+	t.Run("the stale lock of a crashed node is taken over", func(t *testing.T) {
+		crashed := &kvstore.SyncLock{
+			NodeID:      "node-crashed",
+			Trigger:     kvstore.SyncTriggerScheduled,
+			StartedAt:   time.Now().Add(-time.Hour),
+			HeartbeatAt: time.Now().Add(-10 * time.Minute),
+		}
+		require.NoError(t, p.kvstore.SaveSyncLock(crashed))
+
+		running, err := p.RunningSync()
+		require.NoError(t, err)
+		assert.Nil(t, running, "stale holders are not reported as running")
+
+		assert.Equal(t, http.StatusAccepted, syncUsers().Code)
+		waitForUnlock(t)
+		var warned bool
+		for _, entry := range api.Logs("warn") {
+			if strings.HasPrefix(entry.Message, "Took over a stale sync lock") {
+				warned = true
+				assert.Contains(t, entry.KeyValuePairs, "node-crashed")
+			}
+		}
+		assert.True(t, warned, "the stale lock is reported")
+
+		holder, err := p.kvstore.GetSyncLock()
+		require.NoError(t, err)
+		assert.Nil(t, holder, "the holder is removed once the sync finishes")
+	})
+}
+
+func TestSyncCancel(t *testing.T) {
+	api := fakeapi.New()
+	admin := api.AddUser(&model.User{Username: "admin", Email: "admin@example.com", Roles: model.SystemAdminRoleId + " " + model.SystemUserRoleId})
+	alice := api.AddUser(&model.User{Username: "alice", Email: "alice@example.com"})
+	bob := api.AddUser(&model.User{Username: "bob", Email: "bob@example.com"})
+	carol := api.AddUser(&model.User{Username: "carol", Email: "carol@example.com"})
+
+	directory := &pagedDirectory{
+		records: []map[string]any{
+			{"id": "1", "email": "alice@example.com", "dept": "Engineering"},
+			{"id": "2", "email": "bob@example.com", "dept": "Sales"},
+			{"id": "3", "email": "carol@example.com", "dept": "Legal"},
+		},
+		hold:    map[int]bool{1: true},
+		held:    make(chan int, 1),
+		release: make(chan struct{}),
+	}
+	source := httptest.NewServer(directory)
+	defer source.Close()
+
+	api.SetPluginConfig(map[string]any{
+		"sourceurl":     source.URL,
+		"fieldmappings": `[{"source": "dept", "attribute": "department"}]`,
+	})
+	p := setupPlugin(t, api)
+
+	cancelRun := func(runID string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodPost, "/api/v1/sync/runs/"+runID+"/cancel", nil)
+		r.Header.Set("Mattermost-User-ID", admin.Id)
+		p.ServeHTTP(nil, w, r)
+		return w
+	}
+
+	// The scheduled sync started on activation is now waiting for the second page.
+	select {
+	case <-directory.held:
+	case <-time.After(5 * time.Second):
+		require.Fail(t, "the scheduled sync did not request the second page")
+	}
+	running, err := p.RunningSync()
+	require.NoError(t, err)
+	require.NotNil(t, running)
+	require.NotEmpty(t, running.RunID)
+
+	assert.Equal(t, http.StatusNotFound, cancelRun("unknown").Code)
+
+	w := cancelRun(running.RunID)
+	require.Equal(t, http.StatusAccepted, w.Code)
+	var accepted kvstore.SyncLock
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&accepted))
+	assert.Equal(t, p.nodeID, accepted.NodeID)
+
+	response, appErr := p.ExecuteCommand(nil, &model.CommandArgs{Command: "/attrsync cancel", UserId: admin.Id})
+	require.Nil(t, appErr)
+	assert.Contains(t, response.Text, "Asked the scheduled sync run `"+running.RunID+"`")
+
+	close(directory.release)
+	require.Eventually(t, func() bool {
+		return api.KVValue("mutex_"+syncMutexKey) == nil
+	}, 5*time.Second, 10*time.Millisecond, "the sync lock is released")
+
+	run, err := p.kvstore.GetRun(running.RunID)
+	require.NoError(t, err)
+	require.NotNil(t, run)
+	assert.Equal(t, kvstore.RunStatusCancelled, run.Status)
+	assert.Equal(t, "Engineering", api.User(alice.Id).Props["attr_department"])
+	assert.Equal(t, "Sales", api.User(bob.Id).Props["attr_department"], "the page being synced is finished")
+	assert.Empty(t, api.User(carol.Id).Props["attr_department"])
+
+	cancel, err := p.kvstore.GetSyncCancel(running.RunID)
+	require.NoError(t, err)
+	assert.Nil(t, cancel, "the cancel request is removed with the lock")
+	checkpoint, err := p.kvstore.GetActiveCheckpoint("http")
+	require.NoError(t, err)
+	assert.Nil(t, checkpoint, "cancelled runs are not resumed")
+
+	assert.Equal(t, http.StatusConflict, cancelRun(running.RunID).Code)
+}
@@ -0,0 +1,300 @@
+package membership
+
+import (
+	"context"
+	"sort"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/pkg/errors"
+
+	"github.com/mattermost/mattermost-plugin-starter-template/server/attrsync"
+	"github.com/mattermost/mattermost-plugin-starter-template/server/store/kvstore"
+)
+
+// usersPerPage is the page size used to list users.
+const usersPerPage = 200
+
+// UserService lists Mattermost users. *pluginapi.UserService satisfies it.
+type UserService interface {
+	List(options *model.UserGetOptions) ([]*model.User, error)
+}
+
+// TeamService manages team membership. *pluginapi.TeamService satisfies it.
+type TeamService interface {
+	GetByName(name string) (*model.Team, error)
+	GetMember(teamID, userID string) (*model.TeamMember, error)
+	CreateMember(teamID, userID string) (*model.TeamMember, error)
+	DeleteMember(teamID, userID, requestorID string) error
+	UpdateMemberRoles(teamID, userID, newRoles string) (*model.TeamMember, error)
+}
+
+// ChannelService manages channel membership. *pluginapi.ChannelService satisfies it.
+type ChannelService interface {
+	GetByName(teamID, channelName string, includeDeleted bool) (*model.Channel, error)
+	GetMember(channelID, userID string) (*model.ChannelMember, error)
+	AddMember(channelID, userID string) (*model.ChannelMember, error)
+	DeleteMember(channelID, userID string) error
+	UpdateChannelMemberRoles(channelID, userID, newRoles string) (*model.ChannelMember, error)
+}
+
+// Config holds the dependencies and rules of a Reconciler.
+type Config struct {
+	Users      UserService
+	Teams      TeamService
+	Channels   ChannelService
+	Attributes attrsync.AttributeStore
+	Store      kvstore.KVStore
+	Log        attrsync.Logger
+
+	// RequestorID is the user recorded as removing members from teams.
+	RequestorID string
+
+	Rules []Rule
+}
+
+// Reconciler applies membership rules to every active user.
+type Reconciler struct {
+	users       UserService
+	teams       TeamService
+	channels    ChannelService
+	attributes  attrsync.AttributeStore
+	store       kvstore.KVStore
+	log         attrsync.Logger
+	requestorID string
+
+	rules []Rule
+}
+
+// Result summarizes a reconciliation.
+type Result struct {
+	Added    int
+	Removed  int
+	Promoted int
+	Demoted  int
+	Failed   int
+}
+
+// NewReconciler creates a Reconciler from cfg.
+func NewReconciler(cfg Config) *Reconciler {
+	return &Reconciler{
+		users:       cfg.Users,
+		teams:       cfg.Teams,
+		channels:    cfg.Channels,
+		attributes:  cfg.Attributes,
+		store:       cfg.Store,
+		log:         cfg.Log,
+		requestorID: cfg.RequestorID,
+		rules:       cfg.Rules,
+	}
+}
+
+// Run evaluates every rule against the attributes of all active users, adding matching users to
+// the rule's team or channel and granting them its admin role if the rule asks for it. Rules that
+// remove revoke what they granted from users that no longer match. Failures for individual users
+// are logged and counted; only errors that prevent the rules from being evaluated are returned.
+func (r *Reconciler) Run(ctx context.Context) (*Result, error) {
+	result := &Result{}
+	if len(r.rules) == 0 {
+		return result, nil
+	}
+
+	matched, err := r.matchUsers(ctx)
+	if err != nil {
+		return result, err
+	}
+
+	for _, rule := range r.rules {
+		if err = r.applyRule(ctx, rule, matched[rule.Name], result); err != nil {
+			return result, err
+		}
+	}
+	return result, nil
+}
+
+// matchUsers returns the IDs of the active users matching each rule, keyed by rule name. Users
+// the rule could not be evaluated for are included as not matching, so that nothing is granted
+// to or revoked from them.
+func (r *Reconciler) matchUsers(ctx context.Context) (map[string]map[string]bool, error) {
+	matched := make(map[string]map[string]bool, len(r.rules))
+	for _, rule := range r.rules {
+		matched[rule.Name] = map[string]bool{}
+	}
+
+	for page := 0; ; page++ {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		users, err := r.users.List(&model.UserGetOptions{Page: page, PerPage: usersPerPage, Active: true})
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to list users")
+		}
+
+		for _, user := range users {
+			attributes, err := r.attributes.GetAttributes(user)
+			if err != nil {
+				return nil, errors.Wrapf(err, "failed to get attributes for user %s", user.Id)
+			}
+			for _, rule := range r.rules {
+				ok, err := rule.matches(attributes)
+				if err != nil {
+					r.log.Warn("Failed to evaluate membership rule", "phase", "membership", "rule", rule.Name, "user_id", user.Id, "err", err)
+				}
+				if ok || err != nil {
+					matched[rule.Name][user.Id] = ok
+				}
+			}
+		}
+
+		if len(users) < usersPerPage {
+			return matched, nil
+		}
+	}
+}
+
+// applyRule brings the team or channel of rule in line with the matching users and records which
+// memberships and roles the rule manages.
+func (r *Reconciler) applyRule(ctx context.Context, rule Rule, matched map[string]bool, result *Result) error {
+	target, err := r.resolve(rule)
+	if err != nil {
+		r.log.Warn("Skipping membership rule, its team or channel could not be found", "phase", "membership", "rule", rule.Name, "team", rule.Team, "channel", rule.Channel, "err", err)
+		result.Failed++
+		return nil
+	}
+
+	previousMembers, err := r.store.GetRuleMembers(rule.Name)
+	if err != nil {
+		return err
+	}
+	previousAdmins, err := r.store.GetRuleAdmins(rule.Name)
+	if err != nil {
+		return err
+	}
+	members, admins := toSet(previousMembers), toSet(previousAdmins)
+
+	for _, userID := range sortedKeys(matched) {
+		if err = ctx.Err(); err != nil {
+			return err
+		}
+		if !matched[userID] {
+			continue
+		}
+		r.grant(rule, target, userID, members, admins, result)
+	}
+
+	// Revoke what was granted to users that no longer match, and the admin role if the rule
+	// stopped granting it.
+	tracked := toSet(append(sortedKeys(members), sortedKeys(admins)...))
+	for _, userID := range sortedKeys(tracked) {
+		isMatched, evaluated := matched[userID]
+		if evaluated && !isMatched || isMatched && (rule.Admin || !admins[userID]) {
+			continue
+		}
+		r.revoke(rule, target, userID, isMatched, members, admins, result)
+	}
+
+	if err = r.store.SaveRuleMembers(rule.Name, sortedKeys(members)); err != nil {
+		return err
+	}
+	return r.store.SaveRuleAdmins(rule.Name, sortedKeys(admins))
+}
+
+// resolve looks up the team or channel rule applies to.
+func (r *Reconciler) resolve(rule Rule) (target, error) {
+	team, err := r.teams.GetByName(rule.Team)
+	if err != nil {
+		return nil, err
+	}
+	teamTarget := &teamTarget{teams: r.teams, teamID: team.Id, requestorID: r.requestorID}
+	if rule.Channel == "" {
+		return teamTarget, nil
+	}
+
+	channel, err := r.channels.GetByName(team.Id, rule.Channel, false)
+	if err != nil {
+		return nil, err
+	}
+	return &channelTarget{team: teamTarget, channels: r.channels, channelID: channel.Id}, nil
+}
+
+// grant makes a matching user a member, and an admin if the rule asks for it, recording what the
+// rule granted in members and admins.
+func (r *Reconciler) grant(rule Rule, target target, userID string, members, admins map[string]bool, result *Result) {
+	isMember, isAdmin, err := target.member(userID)
+	if err != nil {
+		r.log.Warn("Failed to check membership", "phase", "membership", "rule", rule.Name, "user_id", userID, "err", err)
+		result.Failed++
+		return
+	}
+
+	if !isMember {
+		if err = target.add(userID); err != nil {
+			r.log.Warn("Failed to add member", "phase", "membership", "rule", rule.Name, "user_id", userID, "err", err)
+			result.Failed++
+			return
+		}
+		r.log.Info("Added member", "phase", "membership", "rule", rule.Name, "user_id", userID)
+		result.Added++
+		members[userID] = true
+	}
+
+	if rule.Admin && !isAdmin {
+		if err = target.setAdmin(userID, true); err != nil {
+			r.log.Warn("Failed to grant admin role", "phase", "membership", "rule", rule.Name, "user_id", userID, "err", err)
+			result.Failed++
+			return
+		}
+		r.log.Info("Granted admin role", "phase", "membership", "rule", rule.Name, "user_id", userID)
+		result.Promoted++
+		admins[userID] = true
+	}
+}
+
+// revoke takes back what the rule granted to a user, if the rule removes: the membership if the
+// user no longer matches, otherwise just the admin role. Without removal, the user stays tracked so
+// that enabling it later applies to them too.
+func (r *Reconciler) revoke(rule Rule, target target, userID string, matched bool, members, admins map[string]bool, result *Result) {
+	if !rule.Remove {
+		return
+	}
+
+	if !matched && members[userID] {
+		if err := target.remove(userID); err != nil {
+			r.log.Warn("Failed to remove member", "phase", "membership", "rule", rule.Name, "user_id", userID, "err", err)
+			result.Failed++
+			return
+		}
+		r.log.Info("Removed member", "phase", "membership", "rule", rule.Name, "user_id", userID)
+		result.Removed++
+		delete(members, userID)
+		delete(admins, userID)
+		return
+	}
+
+	if admins[userID] {
+		if err := target.setAdmin(userID, false); err != nil {
+			r.log.Warn("Failed to revoke admin role", "phase", "membership", "rule", rule.Name, "user_id", userID, "err", err)
+			result.Failed++
+			return
+		}
+		r.log.Info("Revoked admin role", "phase", "membership", "rule", rule.Name, "user_id", userID)
+		result.Demoted++
+		delete(admins, userID)
+	}
+}
+
+func toSet(values []string) map[string]bool {
+	set := make(map[string]bool, len(values))
+	for _, value := range values {
+		set[value] = true
+	}
+	return set
+}
+
+func sortedKeys(set map[string]bool) []string {
+	keys := make([]string, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
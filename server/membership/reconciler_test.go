@@ -0,0 +1,280 @@
+package membership
+
+import (
+	"context"
+	"testing"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/pluginapi"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mattermost/mattermost-plugin-starter-template/server/attrsync"
+	"github.com/mattermost/mattermost-plugin-starter-template/server/store/kvstore"
+)
+
+type fakeUsers struct {
+	users []*model.User
+}
+
+func (f *fakeUsers) List(options *model.UserGetOptions) ([]*model.User, error) {
+	start := min(options.Page*options.PerPage, len(f.users))
+	end := min(start+options.PerPage, len(f.users))
+	return f.users[start:end], nil
+}
+
+func (f *fakeUsers) Get(userID string) (*model.User, error) {
+	for _, user := range f.users {
+		if user.Id == userID {
+			return user, nil
+		}
+	}
+	return nil, pluginapi.ErrNotFound
+}
+
+func (f *fakeUsers) GetByEmail(string) (*model.User, error)    { return nil, pluginapi.ErrNotFound }
+func (f *fakeUsers) GetByUsername(string) (*model.User, error) { return nil, pluginapi.ErrNotFound }
+func (f *fakeUsers) Update(*model.User) error                  { return nil }
+
+// memberships maps a team or channel ID to its members and whether they are admins.
+type memberships map[string]map[string]bool
+
+func (m memberships) add(id, userID string) {
+	if m[id] == nil {
+		m[id] = map[string]bool{}
+	}
+	m[id][userID] = false
+}
+
+func (m memberships) setAdmin(id, userID string, admin bool) error {
+	if _, ok := m[id][userID]; !ok {
+		return pluginapi.ErrNotFound
+	}
+	m[id][userID] = admin
+	return nil
+}
+
+type fakeTeams struct {
+	teams   map[string]string
+	members memberships
+}
+
+func (f *fakeTeams) GetByName(name string) (*model.Team, error) {
+	id, ok := f.teams[name]
+	if !ok {
+		return nil, pluginapi.ErrNotFound
+	}
+	return &model.Team{Id: id}, nil
+}
+
+func (f *fakeTeams) GetMember(teamID, userID string) (*model.TeamMember, error) {
+	admin, ok := f.members[teamID][userID]
+	if !ok {
+		return nil, pluginapi.ErrNotFound
+	}
+	return &model.TeamMember{TeamId: teamID, UserId: userID, SchemeAdmin: admin}, nil
+}
+
+func (f *fakeTeams) CreateMember(teamID, userID string) (*model.TeamMember, error) {
+	f.members.add(teamID, userID)
+	return &model.TeamMember{TeamId: teamID, UserId: userID}, nil
+}
+
+func (f *fakeTeams) DeleteMember(teamID, userID, _ string) error {
+	delete(f.members[teamID], userID)
+	return nil
+}
+
+func (f *fakeTeams) UpdateMemberRoles(teamID, userID, newRoles string) (*model.TeamMember, error) {
+	return nil, f.members.setAdmin(teamID, userID, newRoles == teamAdminRoles)
+}
+
+type fakeChannels struct {
+	channels map[string]string
+	members  memberships
+}
+
+func (f *fakeChannels) GetByName(teamID, channelName string, _ bool) (*model.Channel, error) {
+	id, ok := f.channels[teamID+"/"+channelName]
+	if !ok {
+		return nil, pluginapi.ErrNotFound
+	}
+	return &model.Channel{Id: id}, nil
+}
+
+func (f *fakeChannels) GetMember(channelID, userID string) (*model.ChannelMember, error) {
+	admin, ok := f.members[channelID][userID]
+	if !ok {
+		return nil, pluginapi.ErrNotFound
+	}
+	return &model.ChannelMember{ChannelId: channelID, UserId: userID, SchemeAdmin: admin}, nil
+}
+
+func (f *fakeChannels) AddMember(channelID, userID string) (*model.ChannelMember, error) {
+	f.members.add(channelID, userID)
+	return &model.ChannelMember{ChannelId: channelID, UserId: userID}, nil
+}
+
+func (f *fakeChannels) DeleteMember(channelID, userID string) error {
+	delete(f.members[channelID], userID)
+	return nil
+}
+
+func (f *fakeChannels) UpdateChannelMemberRoles(channelID, userID, newRoles string) (*model.ChannelMember, error) {
+	return nil, f.members.setAdmin(channelID, userID, newRoles == channelAdminRoles)
+}
+
+type fakeKVStore struct {
+	kvstore.KVStore
+	ruleMembers map[string][]string
+	ruleAdmins  map[string][]string
+}
+
+func newFakeKVStore() *fakeKVStore {
+	return &fakeKVStore{ruleMembers: map[string][]string{}, ruleAdmins: map[string][]string{}}
+}
+
+func (f *fakeKVStore) GetRuleMembers(rule string) ([]string, error) {
+	return f.ruleMembers[rule], nil
+}
+
+func (f *fakeKVStore) SaveRuleMembers(rule string, userIDs []string) error {
+	f.ruleMembers[rule] = userIDs
+	return nil
+}
+
+func (f *fakeKVStore) GetRuleAdmins(rule string) ([]string, error) {
+	return f.ruleAdmins[rule], nil
+}
+
+func (f *fakeKVStore) SaveRuleAdmins(rule string, userIDs []string) error {
+	f.ruleAdmins[rule] = userIDs
+	return nil
+}
+
+type nopLogger struct{}
+
+func (nopLogger) Debug(string, ...any) {}
+func (nopLogger) Info(string, ...any)  {}
+func (nopLogger) Warn(string, ...any)  {}
+func (nopLogger) Error(string, ...any) {}
+
+func newUser(id, department, location string) *model.User {
+	return &model.User{Id: id, Props: model.StringMap{"attr_department": department, "attr_location": location}}
+}
+
+func newTestReconciler(users *fakeUsers, teams *fakeTeams, channels *fakeChannels, store *fakeKVStore, rules ...Rule) *Reconciler {
+	return NewReconciler(Config{
+		Users:      users,
+		Teams:      teams,
+		Channels:   channels,
+		Attributes: attrsync.NewPropsAttributeStore(users),
+		Store:      store,
+		Log:        nopLogger{},
+		Rules:      rules,
+	})
+}
+
+func TestReconcilerRunChannels(t *testing.T) {
+	users := &fakeUsers{users: []*model.User{
+		newUser("u1", "Engineering", "Berlin"),
+		newUser("u2", "Engineering", "Paris"),
+		newUser("u3", "Sales", "Berlin"),
+	}}
+	teams := &fakeTeams{
+		teams:   map[string]string{"acme": "t1"},
+		members: memberships{"t1": {"u3": false}},
+	}
+	channels := &fakeChannels{
+		channels: map[string]string{"t1/eng-berlin": "c1"},
+		members:  memberships{"c1": {"u3": false}},
+	}
+	store := newFakeKVStore()
+	rule := Rule{
+		Name:    "eng-berlin",
+		Match:   map[string]string{"department": "Engineering", "location": "Berlin"},
+		Team:    "acme",
+		Channel: "eng-berlin",
+		Remove:  true,
+	}
+
+	result, err := newTestReconciler(users, teams, channels, store, rule).Run(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, &Result{Added: 1}, result)
+	assert.Equal(t, map[string]bool{"u1": false, "u3": false}, channels.members["c1"])
+	assert.Contains(t, teams.members["t1"], "u1", "users join the channel's team first")
+	assert.Equal(t, []string{"u1"}, store.ruleMembers["eng-berlin"])
+
+	t.Run("removes users it added once they stop matching, but not other members", func(t *testing.T) {
+		users.users[0].Props["attr_location"] = "Paris"
+		users.users[2].Props["attr_department"] = "Engineering"
+
+		result, err := newTestReconciler(users, teams, channels, store, rule).Run(context.Background())
+		require.NoError(t, err)
+		assert.Equal(t, &Result{Removed: 1}, result)
+		assert.Equal(t, map[string]bool{"u3": false}, channels.members["c1"])
+		assert.Empty(t, store.ruleMembers["eng-berlin"])
+	})
+
+	t.Run("keeps users that stop matching without remove", func(t *testing.T) {
+		users.users[0].Props["attr_location"] = "Berlin"
+		_, err := newTestReconciler(users, teams, channels, store, rule).Run(context.Background())
+		require.NoError(t, err)
+		users.users[0].Props["attr_location"] = "Paris"
+
+		keep := rule
+		keep.Remove = false
+		result, err := newTestReconciler(users, teams, channels, store, keep).Run(context.Background())
+		require.NoError(t, err)
+		assert.Equal(t, &Result{}, result)
+		assert.Contains(t, channels.members["c1"], "u1")
+		assert.Equal(t, []string{"u1"}, store.ruleMembers["eng-berlin"])
+	})
+
+	t.Run("skips rules whose channel does not exist", func(t *testing.T) {
+		missing := rule
+		missing.Name = "missing"
+		missing.Channel = "nowhere"
+
+		result, err := newTestReconciler(users, teams, channels, store, missing).Run(context.Background())
+		require.NoError(t, err)
+		assert.Equal(t, &Result{Failed: 1}, result)
+	})
+}
+
+func TestReconcilerRunTeamAdmins(t *testing.T) {
+	users := &fakeUsers{users: []*model.User{
+		newUser("u1", "Engineering", "Berlin"),
+		newUser("u2", "Engineering", "Paris"),
+	}}
+	teams := &fakeTeams{
+		teams:   map[string]string{"acme": "t1"},
+		members: memberships{"t1": {"u2": false}},
+	}
+	store := newFakeKVStore()
+	rule := Rule{
+		Name:   "eng-admins",
+		Match:  map[string]string{"department": "Engineering"},
+		Team:   "acme",
+		Admin:  true,
+		Remove: true,
+	}
+
+	result, err := newTestReconciler(users, teams, &fakeChannels{}, store, rule).Run(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, &Result{Added: 1, Promoted: 2}, result)
+	assert.Equal(t, map[string]bool{"u1": true, "u2": true}, teams.members["t1"])
+	assert.Equal(t, []string{"u1"}, store.ruleMembers["eng-admins"])
+	assert.Equal(t, []string{"u1", "u2"}, store.ruleAdmins["eng-admins"])
+
+	// u1 was added by the rule and loses the membership; u2 was already a member and only loses
+	// the admin role.
+	users.users[0].Props["attr_department"] = "Sales"
+	users.users[1].Props["attr_department"] = "Sales"
+	result, err = newTestReconciler(users, teams, &fakeChannels{}, store, rule).Run(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, &Result{Removed: 1, Demoted: 1}, result)
+	assert.Equal(t, map[string]bool{"u2": false}, teams.members["t1"])
+	assert.Empty(t, store.ruleMembers["eng-admins"])
+	assert.Empty(t, store.ruleAdmins["eng-admins"])
+}
@@ -0,0 +1,96 @@
+// Package membership keeps team and channel membership in line with rules over user attributes.
+package membership
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/mattermost/mattermost-plugin-starter-template/server/expr"
+)
+
+// Rule makes the users whose attributes match members, and optionally admins, of a team or
+// channel.
+type Rule struct {
+	// Name identifies the rule. The users it has added are tracked under it, so renaming a rule
+	// makes it forget them.
+	Name string `json:"name"`
+
+	// Match lists the attribute values a user must have, all of which must be equal.
+	Match map[string]string `json:"match,omitempty"`
+
+	// When is a condition over the user's attributes in the expr language. If both Match and When
+	// are set, users must satisfy both.
+	When string `json:"when,omitempty"`
+
+	// Team is the name of the team users are added to.
+	Team string `json:"team"`
+
+	// Channel is the name of a channel in Team. If set, users are added to the channel, joining
+	// the team first if needed.
+	Channel string `json:"channel,omitempty"`
+
+	// Admin grants the team or channel admin role to matching users.
+	Admin bool `json:"admin,omitempty"`
+
+	// Remove revokes what the rule granted once a user no longer matches: the admin role, and the
+	// membership if the rule added it. Memberships and roles obtained by other means are never
+	// revoked.
+	Remove bool `json:"remove,omitempty"`
+
+	condition *expr.Expression
+}
+
+// ParseRules decodes and validates a JSON array of membership rules.
+func ParseRules(raw string) ([]Rule, error) {
+	if strings.TrimSpace(raw) == "" {
+		return nil, nil
+	}
+
+	var rules []Rule
+	if err := json.Unmarshal([]byte(raw), &rules); err != nil {
+		return nil, errors.Wrap(err, "failed to decode membership rules")
+	}
+
+	seen := map[string]bool{}
+	for i := range rules {
+		rule := &rules[i]
+		if rule.Name == "" {
+			return nil, errors.New("membership rules require a name")
+		}
+		if seen[rule.Name] {
+			return nil, errors.Errorf("membership rule %s is defined more than once", rule.Name)
+		}
+		seen[rule.Name] = true
+		if len(rule.Match) == 0 && rule.When == "" {
+			return nil, errors.Errorf("membership rule %s has no conditions", rule.Name)
+		}
+		if rule.When != "" {
+			condition, err := expr.Compile(rule.When)
+			if err != nil {
+				return nil, errors.Wrapf(err, "invalid condition for membership rule %s", rule.Name)
+			}
+			rule.condition = condition
+		}
+		if rule.Team == "" {
+			return nil, errors.Errorf("membership rule %s requires a team", rule.Name)
+		}
+	}
+
+	return rules, nil
+}
+
+// matches reports whether a user with attributes satisfies the rule. Missing attributes are
+// empty.
+func (r Rule) matches(attributes map[string]string) (bool, error) {
+	for name, value := range r.Match {
+		if attributes[name] != value {
+			return false, nil
+		}
+	}
+	if r.condition == nil {
+		return true, nil
+	}
+	return r.condition.Eval(attributes)
+}
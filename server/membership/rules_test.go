@@ -0,0 +1,36 @@
+package membership
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseRules(t *testing.T) {
+	rules, err := ParseRules(`[{"name": "r", "match": {"department": "Engineering"}, "team": "acme", "channel": "eng"}]`)
+	require.NoError(t, err)
+	assert.Equal(t, []Rule{{Name: "r", Match: map[string]string{"department": "Engineering"}, Team: "acme", Channel: "eng"}}, rules)
+
+	rules, err = ParseRules(`[{"name": "managers", "when": "title contains 'Manager' AND location in ['Berlin', 'Paris']", "team": "acme", "admin": true}]`)
+	require.NoError(t, err)
+	require.Len(t, rules, 1)
+	matches, err := rules[0].matches(map[string]string{"title": "Engineering Manager", "location": "Paris"})
+	require.NoError(t, err)
+	assert.True(t, matches)
+	matches, err = rules[0].matches(map[string]string{"title": "Engineer", "location": "Paris"})
+	require.NoError(t, err)
+	assert.False(t, matches)
+
+	for name, raw := range map[string]string{
+		"invalid condition": `[{"name": "r", "when": "title contains", "team": "t"}]`,
+		"invalid json":      `{`,
+		"missing name":      `[{"match": {"a": "b"}, "team": "t", "channel": "c"}]`,
+		"no conditions":     `[{"name": "r", "team": "t", "channel": "c"}]`,
+		"no team":           `[{"name": "r", "match": {"a": "b"}, "channel": "c"}]`,
+		"duplicate":         `[{"name": "r", "match": {"a": "b"}, "team": "t", "channel": "c"}, {"name": "r", "match": {"a": "b"}, "team": "t", "channel": "c"}]`,
+	} {
+		_, err = ParseRules(raw)
+		assert.Error(t, err, name)
+	}
+}
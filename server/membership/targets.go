@@ -0,0 +1,116 @@
+package membership
+
+import (
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/pluginapi"
+	"github.com/pkg/errors"
+)
+
+// Roles assigned to members, with and without the admin role of the team or channel.
+const (
+	teamMemberRoles    = model.TeamUserRoleId
+	teamAdminRoles     = model.TeamUserRoleId + " " + model.TeamAdminRoleId
+	channelMemberRoles = model.ChannelUserRoleId
+	channelAdminRoles  = model.ChannelUserRoleId + " " + model.ChannelAdminRoleId
+)
+
+// target is the team or channel whose membership a rule manages.
+type target interface {
+	// member reports whether the user is a member and whether they hold the admin role.
+	member(userID string) (member, admin bool, err error)
+	add(userID string) error
+	remove(userID string) error
+	setAdmin(userID string, admin bool) error
+}
+
+type teamTarget struct {
+	teams       TeamService
+	teamID      string
+	requestorID string
+}
+
+func (t *teamTarget) member(userID string) (bool, bool, error) {
+	member, err := t.teams.GetMember(t.teamID, userID)
+	if errors.Is(err, pluginapi.ErrNotFound) {
+		return false, false, nil
+	}
+	if err != nil {
+		return false, false, err
+	}
+	// Users who left the team keep a deleted membership.
+	if member.DeleteAt != 0 {
+		return false, false, nil
+	}
+	return true, member.SchemeAdmin, nil
+}
+
+func (t *teamTarget) add(userID string) error {
+	_, err := t.teams.CreateMember(t.teamID, userID)
+	return err
+}
+
+func (t *teamTarget) remove(userID string) error {
+	err := t.teams.DeleteMember(t.teamID, userID, t.requestorID)
+	if errors.Is(err, pluginapi.ErrNotFound) {
+		return nil
+	}
+	return err
+}
+
+func (t *teamTarget) setAdmin(userID string, admin bool) error {
+	roles := teamMemberRoles
+	if admin {
+		roles = teamAdminRoles
+	}
+	_, err := t.teams.UpdateMemberRoles(t.teamID, userID, roles)
+	return err
+}
+
+type channelTarget struct {
+	team      *teamTarget
+	channels  ChannelService
+	channelID string
+}
+
+func (t *channelTarget) member(userID string) (bool, bool, error) {
+	member, err := t.channels.GetMember(t.channelID, userID)
+	if errors.Is(err, pluginapi.ErrNotFound) {
+		return false, false, nil
+	}
+	if err != nil {
+		return false, false, err
+	}
+	return true, member.SchemeAdmin, nil
+}
+
+// add joins the user to the channel, and to its team first if needed.
+func (t *channelTarget) add(userID string) error {
+	inTeam, _, err := t.team.member(userID)
+	if err != nil {
+		return err
+	}
+	if !inTeam {
+		if err = t.team.add(userID); err != nil {
+			return errors.Wrap(err, "failed to add user to the channel's team")
+		}
+	}
+	_, err = t.channels.AddMember(t.channelID, userID)
+	return err
+}
+
+func (t *channelTarget) remove(userID string) error {
+	err := t.channels.DeleteMember(t.channelID, userID)
+	if errors.Is(err, pluginapi.ErrNotFound) {
+		return nil
+	}
+	return err
+}
+
+func (t *channelTarget) setAdmin(userID string, admin bool) error {
+	roles := channelMemberRoles
+	if admin {
+		roles = channelAdminRoles
+	}
+	_, err := t.channels.UpdateChannelMemberRoles(t.channelID, userID, roles)
+	return err
+}
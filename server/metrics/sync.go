@@ -0,0 +1,80 @@
+package metrics
+
+import (
+	"io"
+	"time"
+)
+
+// Sync collects the metrics of attribute sync runs. It satisfies attrsync.Metrics.
+//
+// Counters and histograms only cover the runs on this node, as each node collects its own. The
+// time of the last successful run is read from the store instead, so every node reports the same.
+type Sync struct {
+	registry Registry
+
+	runs          *CounterVec
+	runDuration   *Histogram
+	users         *CounterVec
+	writes        *Counter
+	errors        *CounterVec
+	fetchDuration *Histogram
+}
+
+// NewSync creates the sync metrics. lastSuccess returns when the last successful scheduled run
+// finished on any node, or the zero time if none has; it is called on every write.
+func NewSync(lastSuccess func() (time.Time, error)) *Sync {
+	s := &Sync{}
+	s.runs = s.registry.NewCounterVec("attrsync_runs_total",
+		"Sync runs by outcome.", "outcome")
+	s.runDuration = s.registry.NewHistogram("attrsync_run_duration_seconds",
+		"Duration of sync runs.", []float64{1, 5, 15, 30, 60, 300, 900, 1800, 3600})
+	s.users = s.registry.NewCounterVec("attrsync_users_processed_total",
+		"Source records processed by outcome.", "outcome")
+	s.writes = s.registry.NewCounter("attrsync_attribute_writes_total",
+		"User attribute updates written to Mattermost.")
+	s.errors = s.registry.NewCounterVec("attrsync_errors_total",
+		"Failed attempts by operation, including those that were retried.", "type")
+	s.fetchDuration = s.registry.NewHistogram("attrsync_source_fetch_duration_seconds",
+		"Latency of requests for a page from the source.", []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30})
+	s.registry.NewGaugeFunc("attrsync_last_success_timestamp_seconds",
+		"Unix time at which the last successful scheduled sync run finished on any node.",
+		func() (float64, error) {
+			finishedAt, err := lastSuccess()
+			if err != nil || finishedAt.IsZero() {
+				return 0, err
+			}
+			return float64(finishedAt.UnixNano()) / float64(time.Second), nil
+		})
+	return s
+}
+
+// ObserveRun records a finished run with the given status.
+func (s *Sync) ObserveRun(status string, duration time.Duration) {
+	s.runs.Inc(status)
+	s.runDuration.Observe(duration.Seconds())
+}
+
+// ObserveFetch records the latency of a request to the source.
+func (s *Sync) ObserveFetch(duration time.Duration) {
+	s.fetchDuration.Observe(duration.Seconds())
+}
+
+// ObserveRecord records the final outcome of a source record.
+func (s *Sync) ObserveRecord(outcome string) {
+	s.users.Inc(outcome)
+}
+
+// ObserveWrite records an attribute update written to a user.
+func (s *Sync) ObserveWrite() {
+	s.writes.Inc()
+}
+
+// ObserveError records a failed attempt of operation.
+func (s *Sync) ObserveError(operation string) {
+	s.errors.Inc(operation)
+}
+
+// Write writes the metrics to w in the Prometheus text exposition format.
+func (s *Sync) Write(w io.Writer) error {
+	return s.registry.Write(w)
+}
@@ -0,0 +1,51 @@
+package metrics
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSyncWrite(t *testing.T) {
+	lastSuccess := time.Unix(1700000000, 0)
+	s := NewSync(func() (time.Time, error) { return lastSuccess, nil })
+	s.ObserveRun("succeeded", 12*time.Second)
+	s.ObserveRun("failed", 2*time.Second)
+	s.ObserveFetch(300 * time.Millisecond)
+	s.ObserveRecord("updated")
+	s.ObserveRecord("updated")
+	s.ObserveRecord("unmatched")
+	s.ObserveWrite()
+	s.ObserveError("fetch")
+
+	var buf bytes.Buffer
+	require.NoError(t, s.Write(&buf))
+	out := buf.String()
+
+	assert.Contains(t, out, "# TYPE attrsync_runs_total counter\n")
+	assert.Contains(t, out, "attrsync_runs_total{outcome=\"failed\"} 1\nattrsync_runs_total{outcome=\"succeeded\"} 1\n")
+	assert.Contains(t, out, "# TYPE attrsync_run_duration_seconds histogram\n")
+	assert.Contains(t, out, "attrsync_run_duration_seconds_bucket{le=\"5\"} 1\n")
+	assert.Contains(t, out, "attrsync_run_duration_seconds_bucket{le=\"15\"} 2\n")
+	assert.Contains(t, out, "attrsync_run_duration_seconds_bucket{le=\"+Inf\"} 2\nattrsync_run_duration_seconds_sum 14\nattrsync_run_duration_seconds_count 2\n")
+	assert.Contains(t, out, "attrsync_users_processed_total{outcome=\"updated\"} 2\n")
+	assert.Contains(t, out, "attrsync_attribute_writes_total 1\n")
+	assert.Contains(t, out, "attrsync_errors_total{type=\"fetch\"} 1\n")
+	assert.Contains(t, out, "attrsync_source_fetch_duration_seconds_bucket{le=\"0.25\"} 0\nattrsync_source_fetch_duration_seconds_bucket{le=\"0.5\"} 1\n")
+	assert.Contains(t, out, "attrsync_last_success_timestamp_seconds 1.7e+09\n")
+
+	t.Run("reads the last success on every write", func(t *testing.T) {
+		lastSuccess = lastSuccess.Add(time.Hour)
+		buf.Reset()
+		require.NoError(t, s.Write(&buf))
+		assert.Contains(t, buf.String(), "attrsync_last_success_timestamp_seconds 1.7000036e+09\n")
+
+		lastSuccess = time.Time{}
+		buf.Reset()
+		require.NoError(t, s.Write(&buf))
+		assert.Contains(t, buf.String(), "attrsync_last_success_timestamp_seconds 0\n", "no run has succeeded")
+	})
+}
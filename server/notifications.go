@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+// notifyAdmins sends message from the bot to each configured user and to the configured channel.
+// Failures are logged, as there is nobody else to tell.
+func (p *Plugin) notifyAdmins(message string) {
+	config := p.getConfiguration()
+
+	for _, username := range strings.Split(config.NotifyUsernames, ",") {
+		username = strings.TrimPrefix(strings.TrimSpace(username), "@")
+		if username == "" {
+			continue
+		}
+		user, err := p.client.User.GetByUsername(username)
+		if err != nil {
+			p.API.LogError("Failed to find user to notify", "username", username, "err", err)
+			continue
+		}
+		if err = p.client.Post.DM(p.botUserID, user.Id, &model.Post{Message: message}); err != nil {
+			p.API.LogError("Failed to send notification", "username", username, "err", err)
+		}
+	}
+
+	if config.NotifyChannelID != "" {
+		err := p.client.Post.CreatePost(&model.Post{
+			UserId:    p.botUserID,
+			ChannelId: config.NotifyChannelID,
+			Message:   message,
+		})
+		if err != nil {
+			p.API.LogError("Failed to post notification", "channel_id", config.NotifyChannelID, "err", err)
+		}
+	}
+}
+
+// notifyUser sends message from the bot to the user with userID. Failures are logged, as there is
+// nobody else to tell.
+func (p *Plugin) notifyUser(userID, message string) {
+	if err := p.client.Post.DM(p.botUserID, userID, &model.Post{Message: message}); err != nil {
+		p.API.LogError("Failed to send notification", "user_id", userID, "err", err)
+	}
+}
+
+// runDetailURL returns the REST endpoint describing a sync run.
+func (p *Plugin) runDetailURL(runID string) string {
+	siteURL := ""
+	if config := p.client.Configuration.GetConfig(); config.ServiceSettings.SiteURL != nil {
+		siteURL = strings.TrimSuffix(*config.ServiceSettings.SiteURL, "/")
+	}
+	return fmt.Sprintf("%s/plugins/%s/api/v1/sync/runs/%s", siteURL, p.API.GetPluginID(), runID)
+}
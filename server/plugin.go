@@ -1,11 +1,13 @@
 package main
 
 import (
+	"context"
 	"net/http"
 	"sync"
 	"time"
 
 	"github.com/mattermost/mattermost-plugin-starter-template/server/command"
+	"github.com/mattermost/mattermost-plugin-starter-template/server/metrics"
 	"github.com/mattermost/mattermost-plugin-starter-template/server/store/kvstore"
 	"github.com/mattermost/mattermost/server/public/model"
 	"github.com/mattermost/mattermost/server/public/plugin"
@@ -29,6 +31,23 @@ type Plugin struct {
 
 	backgroundJob *cluster.Job
 
+	// retentionJob deletes expired and orphaned plugin state once a day.
+	retentionJob *cluster.Job
+
+	// metrics collects the sync metrics of this node for the metrics endpoint.
+	metrics *metrics.Sync
+
+	// nodeID identifies this server in the cluster-wide sync lock.
+	nodeID string
+
+	// botUserID is the user ID of the bot that notifies admins about sync problems.
+	botUserID string
+
+	// jobContext is cancelled on deactivation so that an in-flight sync stops at the next page and
+	// is resumed from its checkpoint after the plugin restarts.
+	jobContext context.Context
+	cancelJob  context.CancelFunc
+
 	// configurationLock synchronizes access to the configuration.
 	configurationLock sync.RWMutex
 
@@ -40,10 +59,28 @@ type Plugin struct {
 // OnActivate is invoked when the plugin is activated. If an error is returned, the plugin will be deactivated.
 func (p *Plugin) OnActivate() error {
 	p.client = pluginapi.NewClient(p.API, p.Driver)
+	p.nodeID = nodeID()
 
 	p.kvstore = kvstore.NewKVStore(p.client)
+	if err := p.migrateKVStore(); err != nil {
+		return err
+	}
 
-	p.commandClient = command.NewCommandHandler(p.client)
+	p.commandClient = command.NewCommandHandler(p.client, p.kvstore, p)
+
+	p.metrics = metrics.NewSync(p.kvstore.GetLastSuccessAt)
+
+	botUserID, err := p.client.Bot.EnsureBot(&model.Bot{
+		Username:    "attrsync",
+		DisplayName: "Attribute Sync",
+		Description: "Notifies admins about user attribute sync problems.",
+	}, pluginapi.ProfileImagePath("assets/starter-template-icon.svg"))
+	if err != nil {
+		return errors.Wrap(err, "failed to ensure bot")
+	}
+	p.botUserID = botUserID
+
+	p.jobContext, p.cancelJob = context.WithCancel(context.Background())
 
 	job, err := cluster.Schedule(
 		p.API,
@@ -57,16 +94,56 @@ func (p *Plugin) OnActivate() error {
 
 	p.backgroundJob = job
 
+	retentionJob, err := cluster.Schedule(
+		p.API,
+		"RetentionJob",
+		cluster.MakeWaitForRoundedInterval(24*time.Hour),
+		p.runRetentionJob,
+	)
+	if err != nil {
+		return errors.Wrap(err, "failed to schedule retention job")
+	}
+
+	p.retentionJob = retentionJob
+
+	return nil
+}
+
+// migrateKVStore upgrades the stored data to the schema of this plugin version. Every node runs it
+// on activation, so a cluster mutex makes the others wait for the first one to finish.
+func (p *Plugin) migrateKVStore() error {
+	mutex, err := cluster.NewMutex(p.API, "kv_migrations")
+	if err != nil {
+		return errors.Wrap(err, "failed to create migration mutex")
+	}
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	from, to, err := p.kvstore.Migrate()
+	if err != nil {
+		return errors.Wrap(err, "failed to migrate stored data")
+	}
+	if from != to {
+		p.API.LogInfo("Migrated stored data", "from_version", from, "to_version", to)
+	}
 	return nil
 }
 
 // OnDeactivate is invoked when the plugin is deactivated.
 func (p *Plugin) OnDeactivate() error {
+	if p.cancelJob != nil {
+		p.cancelJob()
+	}
 	if p.backgroundJob != nil {
 		if err := p.backgroundJob.Close(); err != nil {
 			p.API.LogError("Failed to close background job", "err", err)
 		}
 	}
+	if p.retentionJob != nil {
+		if err := p.retentionJob.Close(); err != nil {
+			p.API.LogError("Failed to close retention job", "err", err)
+		}
+	}
 	return nil
 }
 
@@ -0,0 +1,271 @@
+// Package retention deletes plugin state that is no longer needed, so that KV usage does not grow
+// with every run.
+package retention
+
+import (
+	"context"
+	"slices"
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/pluginapi"
+	"github.com/pkg/errors"
+
+	"github.com/mattermost/mattermost-plugin-starter-template/server/attrsync"
+	"github.com/mattermost/mattermost-plugin-starter-template/server/store/kvstore"
+)
+
+// staleCheckpointAge is how long a checkpoint must have been left behind by its run before it is
+// deleted. A run records its checkpoint before marking itself active, so a young checkpoint that
+// is not active may belong to a run that is just starting.
+const staleCheckpointAge = 24 * time.Hour
+
+// UserService looks up Mattermost users. *pluginapi.UserService satisfies it.
+type UserService interface {
+	Get(userID string) (*model.User, error)
+}
+
+// Config holds the dependencies and settings of a Cleaner.
+type Config struct {
+	Store kvstore.KVStore
+	Users UserService
+	Log   attrsync.Logger
+
+	// RunMaxAge is how long finished runs are kept. Zero keeps them forever. The most recent
+	// scheduled run is always kept.
+	RunMaxAge time.Duration
+}
+
+// Cleaner deletes expired and orphaned plugin state.
+type Cleaner struct {
+	store     kvstore.KVStore
+	users     UserService
+	log       attrsync.Logger
+	runMaxAge time.Duration
+
+	// now is overridden in tests.
+	now func() time.Time
+}
+
+// Result summarizes a cleanup.
+type Result struct {
+	Runs        int
+	Checkpoints int
+
+	// UserStates counts the permanently deleted users whose owned values, sync failures or
+	// membership rule records were deleted.
+	UserStates int
+}
+
+// NewCleaner creates a Cleaner from cfg.
+func NewCleaner(cfg Config) *Cleaner {
+	return &Cleaner{
+		store:     cfg.Store,
+		users:     cfg.Users,
+		log:       cfg.Log,
+		runMaxAge: cfg.RunMaxAge,
+		now:       time.Now,
+	}
+}
+
+// Run deletes expired runs, checkpoints left behind by runs that are no longer active, and the
+// per-user state of permanently deleted users. Keys are collected before anything is deleted, as
+// deleting keys shifts the pages they are listed from.
+func (c *Cleaner) Run(ctx context.Context) (*Result, error) {
+	result := &Result{}
+	var err error
+	if result.Runs, err = c.deleteExpiredRuns(ctx); err != nil {
+		return result, err
+	}
+	if result.Checkpoints, err = c.deleteStaleCheckpoints(ctx); err != nil {
+		return result, err
+	}
+	if result.UserStates, err = c.deleteOrphanedUserState(ctx); err != nil {
+		return result, err
+	}
+	return result, nil
+}
+
+func (c *Cleaner) deleteExpiredRuns(ctx context.Context) (int, error) {
+	if c.runMaxAge <= 0 {
+		return 0, nil
+	}
+	lastRun, err := c.store.GetLastRun()
+	if err != nil {
+		return 0, err
+	}
+	runIDs, err := c.store.ListRunIDs()
+	if err != nil {
+		return 0, err
+	}
+
+	cutoff := c.now().Add(-c.runMaxAge)
+	var expired []string
+	for _, runID := range runIDs {
+		if lastRun != nil && runID == lastRun.ID {
+			continue
+		}
+		run, err := c.store.GetRun(runID)
+		if err != nil {
+			return 0, err
+		}
+		if run != nil && run.FinishedAt.Before(cutoff) {
+			expired = append(expired, runID)
+		}
+	}
+
+	for i, runID := range expired {
+		if err = ctx.Err(); err != nil {
+			return i, err
+		}
+		if err = c.store.DeleteRun(runID); err != nil {
+			return i, err
+		}
+	}
+	return len(expired), nil
+}
+
+func (c *Cleaner) deleteStaleCheckpoints(ctx context.Context) (int, error) {
+	checkpoints, err := c.store.ListCheckpoints()
+	if err != nil {
+		return 0, err
+	}
+
+	cutoff := c.now().Add(-staleCheckpointAge)
+	deleted := 0
+	for _, checkpoint := range checkpoints {
+		if err = ctx.Err(); err != nil {
+			return deleted, err
+		}
+		if !checkpoint.UpdatedAt.Before(cutoff) {
+			continue
+		}
+		active, err := c.store.GetActiveCheckpoint(checkpoint.Source)
+		if err != nil {
+			return deleted, err
+		}
+		if active != nil && active.RunID == checkpoint.RunID {
+			// Discarded by the engine once it is too old to resume.
+			continue
+		}
+		if err = c.store.DeleteStaleCheckpoint(checkpoint.RunID); err != nil {
+			return deleted, err
+		}
+		deleted++
+	}
+	return deleted, nil
+}
+
+// deleteOrphanedUserState deletes the source-owned values and sync failures of permanently deleted
+// users, and removes them from the members and admins recorded for membership rules. It returns
+// how many deleted users had state.
+func (c *Cleaner) deleteOrphanedUserState(ctx context.Context) (int, error) {
+	users := &deletedUsers{users: c.users, deleted: map[string]bool{}}
+	orphaned := map[string]bool{}
+
+	userIDs, err := c.store.ListOwnedValuesUserIDs()
+	if err != nil {
+		return 0, err
+	}
+	for _, userID := range userIDs {
+		if err = ctx.Err(); err != nil {
+			return len(orphaned), err
+		}
+		deleted, err := users.isDeleted(userID)
+		if err != nil {
+			return len(orphaned), err
+		}
+		if !deleted {
+			continue
+		}
+		if err = c.store.DeleteOwnedValues(userID); err != nil {
+			return len(orphaned), err
+		}
+		c.log.Debug("Deleted sync state of a deleted user", "user_id", userID)
+		orphaned[userID] = true
+	}
+
+	failures, err := c.store.ListUserFailures()
+	if err != nil {
+		return len(orphaned), err
+	}
+	for _, failure := range failures {
+		if err = ctx.Err(); err != nil {
+			return len(orphaned), err
+		}
+		// Records that never matched a user are left to the sync, which clears them once they do.
+		if failure.UserID == "" {
+			continue
+		}
+		deleted, err := users.isDeleted(failure.UserID)
+		if err != nil {
+			return len(orphaned), err
+		}
+		if !deleted {
+			continue
+		}
+		if err = c.store.DeleteUserFailure(failure.Key); err != nil {
+			return len(orphaned), err
+		}
+		c.log.Debug("Deleted sync failure of a deleted user", "user_id", failure.UserID, "key", failure.Key)
+		orphaned[failure.UserID] = true
+	}
+
+	rules, err := c.store.ListMembershipRules()
+	if err != nil {
+		return len(orphaned), err
+	}
+	for _, rule := range rules {
+		if err = ctx.Err(); err != nil {
+			return len(orphaned), err
+		}
+		members, err := c.store.GetRuleMembers(rule)
+		if err != nil {
+			return len(orphaned), err
+		}
+		admins, err := c.store.GetRuleAdmins(rule)
+		if err != nil {
+			return len(orphaned), err
+		}
+		var deletedIDs []string
+		for _, userID := range append(members, admins...) {
+			deleted, err := users.isDeleted(userID)
+			if err != nil {
+				return len(orphaned), err
+			}
+			if deleted && !slices.Contains(deletedIDs, userID) {
+				deletedIDs = append(deletedIDs, userID)
+			}
+		}
+		if len(deletedIDs) == 0 {
+			continue
+		}
+		if _, err = c.store.RemoveRuleUsers(rule, deletedIDs); err != nil {
+			return len(orphaned), err
+		}
+		c.log.Debug("Removed deleted users from a membership rule", "rule", rule, "user_ids", deletedIDs)
+		for _, userID := range deletedIDs {
+			orphaned[userID] = true
+		}
+	}
+	return len(orphaned), nil
+}
+
+// deletedUsers looks up whether users were permanently deleted, once per user.
+type deletedUsers struct {
+	users   UserService
+	deleted map[string]bool
+}
+
+func (d *deletedUsers) isDeleted(userID string) (bool, error) {
+	if deleted, ok := d.deleted[userID]; ok {
+		return deleted, nil
+	}
+	// Deactivated users keep their state, as they may be reactivated.
+	_, err := d.users.Get(userID)
+	if err != nil && !errors.Is(err, pluginapi.ErrNotFound) {
+		return false, errors.Wrapf(err, "failed to get user %s", userID)
+	}
+	d.deleted[userID] = err != nil
+	return err != nil, nil
+}
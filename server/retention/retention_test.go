@@ -0,0 +1,213 @@
+package retention
+
+import (
+	"context"
+	"slices"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/pluginapi"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mattermost/mattermost-plugin-starter-template/server/store/kvstore"
+)
+
+type fakeKVStore struct {
+	kvstore.KVStore
+	lastRunID   string
+	runs        map[string]*kvstore.SyncRun
+	checkpoints map[string]*kvstore.SyncCheckpoint
+	activeRuns  map[string]string
+	owned       map[string]*kvstore.OwnedValues
+	failures    map[string]*kvstore.UserFailure
+	ruleMembers map[string][]string
+	ruleAdmins  map[string][]string
+}
+
+func (f *fakeKVStore) GetLastRun() (*kvstore.SyncRun, error) {
+	return f.runs[f.lastRunID], nil
+}
+
+func (f *fakeKVStore) GetRun(runID string) (*kvstore.SyncRun, error) {
+	return f.runs[runID], nil
+}
+
+func (f *fakeKVStore) ListRunIDs() ([]string, error) {
+	return sortedKeys(f.runs), nil
+}
+
+func (f *fakeKVStore) DeleteRun(runID string) error {
+	delete(f.runs, runID)
+	return nil
+}
+
+func (f *fakeKVStore) ListCheckpoints() ([]*kvstore.SyncCheckpoint, error) {
+	var checkpoints []*kvstore.SyncCheckpoint
+	for _, runID := range sortedKeys(f.checkpoints) {
+		checkpoints = append(checkpoints, f.checkpoints[runID])
+	}
+	return checkpoints, nil
+}
+
+func (f *fakeKVStore) GetActiveCheckpoint(source string) (*kvstore.SyncCheckpoint, error) {
+	return f.checkpoints[f.activeRuns[source]], nil
+}
+
+func (f *fakeKVStore) DeleteStaleCheckpoint(runID string) error {
+	delete(f.checkpoints, runID)
+	return nil
+}
+
+func (f *fakeKVStore) ListOwnedValuesUserIDs() ([]string, error) {
+	return sortedKeys(f.owned), nil
+}
+
+func (f *fakeKVStore) DeleteOwnedValues(userID string) error {
+	delete(f.owned, userID)
+	return nil
+}
+
+func (f *fakeKVStore) ListUserFailures() ([]*kvstore.UserFailure, error) {
+	var failures []*kvstore.UserFailure
+	for _, key := range sortedKeys(f.failures) {
+		failures = append(failures, f.failures[key])
+	}
+	return failures, nil
+}
+
+func (f *fakeKVStore) DeleteUserFailure(key string) error {
+	delete(f.failures, key)
+	return nil
+}
+
+func (f *fakeKVStore) ListMembershipRules() ([]string, error) {
+	rules := sortedKeys(f.ruleMembers)
+	for _, rule := range sortedKeys(f.ruleAdmins) {
+		if !slices.Contains(rules, rule) {
+			rules = append(rules, rule)
+		}
+	}
+	return rules, nil
+}
+
+func (f *fakeKVStore) GetRuleMembers(rule string) ([]string, error) {
+	return f.ruleMembers[rule], nil
+}
+
+func (f *fakeKVStore) GetRuleAdmins(rule string) ([]string, error) {
+	return f.ruleAdmins[rule], nil
+}
+
+func (f *fakeKVStore) RemoveRuleUsers(rule string, userIDs []string) (int, error) {
+	removed := 0
+	for _, lists := range []map[string][]string{f.ruleMembers, f.ruleAdmins} {
+		if _, ok := lists[rule]; !ok {
+			continue
+		}
+		lists[rule] = slices.DeleteFunc(lists[rule], func(userID string) bool {
+			if slices.Contains(userIDs, userID) {
+				removed++
+				return true
+			}
+			return false
+		})
+	}
+	return removed, nil
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+type fakeUsers map[string]*model.User
+
+func (f fakeUsers) Get(userID string) (*model.User, error) {
+	if user, ok := f[userID]; ok {
+		return user, nil
+	}
+	return nil, pluginapi.ErrNotFound
+}
+
+type nopLogger struct{}
+
+func (nopLogger) Debug(string, ...any) {}
+func (nopLogger) Info(string, ...any)  {}
+func (nopLogger) Warn(string, ...any)  {}
+func (nopLogger) Error(string, ...any) {}
+
+func TestCleanerRun(t *testing.T) {
+	now := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	store := &fakeKVStore{
+		lastRunID: "old-last",
+		runs: map[string]*kvstore.SyncRun{
+			"expired":  {ID: "expired", FinishedAt: now.Add(-40 * 24 * time.Hour)},
+			"old-last": {ID: "old-last", FinishedAt: now.Add(-60 * 24 * time.Hour)},
+			"recent":   {ID: "recent", FinishedAt: now.Add(-time.Hour), Manual: true},
+		},
+		checkpoints: map[string]*kvstore.SyncCheckpoint{
+			"active":   {RunID: "active", Source: "hr", UpdatedAt: now.Add(-48 * time.Hour)},
+			"orphaned": {RunID: "orphaned", Source: "hr", UpdatedAt: now.Add(-48 * time.Hour)},
+			"starting": {RunID: "starting", Source: "hr", UpdatedAt: now.Add(-time.Minute)},
+		},
+		activeRuns: map[string]string{"hr": "active"},
+		owned: map[string]*kvstore.OwnedValues{
+			"u1": {},
+			"u2": {},
+			"u3": {},
+		},
+		failures: map[string]*kvstore.UserFailure{
+			"email:u1@example.com":  {Key: "email:u1@example.com", UserID: "u1"},
+			"email:u2@example.com":  {Key: "email:u2@example.com", UserID: "u2"},
+			"email:u4@example.com":  {Key: "email:u4@example.com", UserID: "u4"},
+			"email:new@example.com": {Key: "email:new@example.com"},
+		},
+		ruleMembers: map[string][]string{
+			"engineering": {"u1", "u2", "u3", "u5"},
+			"sales":       {"u1"},
+		},
+		ruleAdmins: map[string][]string{
+			"engineering": {"u5"},
+			"leads":       {"u5", "u1"},
+		},
+	}
+	users := fakeUsers{
+		"u1": {Id: "u1"},
+		"u3": {Id: "u3", DeleteAt: 1},
+	}
+	cleaner := NewCleaner(Config{
+		Store:     store,
+		Users:     users,
+		Log:       nopLogger{},
+		RunMaxAge: 30 * 24 * time.Hour,
+	})
+	cleaner.now = func() time.Time { return now }
+
+	result, err := cleaner.Run(context.Background())
+	require.NoError(t, err)
+
+	assert.Equal(t, &Result{Runs: 1, Checkpoints: 1, UserStates: 3}, result)
+	assert.Equal(t, []string{"old-last", "recent"}, sortedKeys(store.runs), "the last scheduled run is kept")
+	assert.Equal(t, []string{"active", "starting"}, sortedKeys(store.checkpoints))
+	assert.Equal(t, []string{"u1", "u3"}, sortedKeys(store.owned), "deactivated users keep their state")
+	assert.Equal(t, []string{"email:new@example.com", "email:u1@example.com"}, sortedKeys(store.failures), "failures without a user are kept")
+	assert.Equal(t, map[string][]string{"engineering": {"u1", "u3"}, "sales": {"u1"}}, store.ruleMembers)
+	assert.Equal(t, map[string][]string{"engineering": {}, "leads": {"u1"}}, store.ruleAdmins)
+
+	t.Run("keeps run history without a maximum age", func(t *testing.T) {
+		cleaner.runMaxAge = 0
+		store.runs["expired"] = &kvstore.SyncRun{ID: "expired"}
+
+		result, err := cleaner.Run(context.Background())
+		require.NoError(t, err)
+		assert.Equal(t, 0, result.Runs)
+		assert.Contains(t, store.runs, "expired")
+	})
+}
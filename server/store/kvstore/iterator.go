@@ -0,0 +1,79 @@
+package kvstore
+
+import (
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// KeyIterator pages through the keys starting with a prefix, fetching a page of keys at a time.
+//
+// Pages are addressed by offset, so deleting keys while iterating makes later keys shift into
+// pages already read. Callers deleting keys should collect them first.
+type KeyIterator struct {
+	kv      KVService
+	prefix  string
+	perPage int
+
+	page int
+	keys []string
+	last bool
+	key  string
+	err  error
+}
+
+// NewKeyIterator creates an iterator over the keys starting with prefix.
+func NewKeyIterator(kv KVService, prefix string) *KeyIterator {
+	return &KeyIterator{
+		kv:      kv,
+		prefix:  prefix,
+		perPage: listKeysPerPage,
+	}
+}
+
+// Next advances to the next key, fetching the next page if needed. It returns false once the keys
+// are exhausted or fetching a page failed, see Err.
+func (it *KeyIterator) Next() bool {
+	for len(it.keys) == 0 {
+		if it.last || it.err != nil {
+			return false
+		}
+		// The plugin API filters each page of all keys by prefix, so a short filtered page does
+		// not mean the last one. Filter here to know when the keys run out.
+		keys, err := it.kv.ListKeys(it.page, it.perPage)
+		if err != nil {
+			it.err = errors.Wrapf(err, "failed to list %s keys", it.prefix)
+			return false
+		}
+		it.page++
+		it.last = len(keys) < it.perPage
+		for _, key := range keys {
+			if strings.HasPrefix(key, it.prefix) {
+				it.keys = append(it.keys, key)
+			}
+		}
+	}
+
+	it.key, it.keys = it.keys[0], it.keys[1:]
+	return true
+}
+
+// Key returns the current key.
+func (it *KeyIterator) Key() string {
+	return it.key
+}
+
+// Err returns the error that stopped the iteration, if any.
+func (it *KeyIterator) Err() error {
+	return it.err
+}
+
+// listKeys returns every key starting with prefix.
+func listKeys(kv KVService, prefix string) ([]string, error) {
+	var keys []string
+	it := NewKeyIterator(kv, prefix)
+	for it.Next() {
+		keys = append(keys, it.Key())
+	}
+	return keys, it.Err()
+}
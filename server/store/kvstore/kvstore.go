@@ -1,6 +1,103 @@
 package kvstore
 
+import (
+	"time"
+)
+
 type KVStore interface {
 	// Define your methods here. This package is used to access the KVStore pluginapi methods.
 	GetTemplateData(userID string) (string, error)
+
+	// Migrate upgrades the stored data to the current schema version, returning the versions
+	// before and after.
+	Migrate() (from, to int, err error)
+	// Export returns every stored value except cluster locks and job schedules.
+	Export() (*Snapshot, error)
+	// Import replaces the stored values by those of a snapshot. Migrate must be run afterwards.
+	Import(snapshot *Snapshot) error
+
+	// GetSyncCursor returns the incremental sync state for a source, or nil if it has never synced.
+	GetSyncCursor(source string) (*SyncCursor, error)
+	// SaveSyncCursor persists the incremental sync state for a source.
+	SaveSyncCursor(source string, cursor *SyncCursor) error
+
+	// GetActiveCheckpoint returns the checkpoint of the unfinished run for a source, or nil if the
+	// last run completed.
+	GetActiveCheckpoint(source string) (*SyncCheckpoint, error)
+	// SaveCheckpoint persists a run's checkpoint and marks the run as active for its source.
+	SaveCheckpoint(checkpoint *SyncCheckpoint) error
+	// DeleteCheckpoint removes a run's checkpoint once it has completed.
+	DeleteCheckpoint(checkpoint *SyncCheckpoint) error
+	// ListCheckpoints returns every stored checkpoint, including those of runs no longer active.
+	ListCheckpoints() ([]*SyncCheckpoint, error)
+	// DeleteStaleCheckpoint removes the checkpoint of a run that is no longer active, leaving the
+	// active run of its source alone.
+	DeleteStaleCheckpoint(runID string) error
+
+	// GetRun returns a sync run by ID, or nil if it does not exist.
+	GetRun(runID string) (*SyncRun, error)
+	// GetLastRun returns the most recently finished sync run, or nil if none has run yet.
+	GetLastRun() (*SyncRun, error)
+	// GetLastSuccessAt returns when the most recent successful run that is not manual finished, or
+	// the zero time if none has succeeded yet.
+	GetLastSuccessAt() (time.Time, error)
+	// SaveRun persists a sync run and, unless it is manual, records it as the most recent one.
+	SaveRun(run *SyncRun) error
+	// ListRunIDs returns the IDs of every stored sync run.
+	ListRunIDs() ([]string, error)
+	// DeleteRun removes a sync run from the history.
+	DeleteRun(runID string) error
+
+	// ListUserFailures returns every record that failed to sync in its latest attempt.
+	ListUserFailures() ([]*UserFailure, error)
+	// GetUserFailure returns the failure state of a record, or nil if it is not failing.
+	GetUserFailure(key string) (*UserFailure, error)
+	// SaveUserFailure persists the failure state of a record.
+	SaveUserFailure(failure *UserFailure) error
+	// DeleteUserFailure clears the failure state of a record, releasing it from quarantine.
+	DeleteUserFailure(key string) error
+
+	// GetSyncLock returns the node recorded as holding the cluster-wide sync lock, or nil if none
+	// is. The record outlives nodes that crash while holding the lock, see SyncLock.Stale.
+	GetSyncLock() (*SyncLock, error)
+	// SaveSyncLock records the node holding the cluster-wide sync lock.
+	SaveSyncLock(lock *SyncLock) error
+	// DeleteSyncLock removes the record of the node holding the sync lock once it is released.
+	DeleteSyncLock() error
+	// GetSyncCancel returns the request to cancel a run, or nil if it was not asked to stop.
+	GetSyncCancel(runID string) (*SyncCancel, error)
+	// SaveSyncCancel asks a run in progress to stop.
+	SaveSyncCancel(cancel *SyncCancel) error
+	// DeleteSyncCancel removes the request to cancel a run once it has stopped.
+	DeleteSyncCancel(runID string) error
+
+	// GetSourceFields returns the names of the fields a source has reported so far.
+	GetSourceFields(source string) ([]string, error)
+	// SaveSourceFields persists the names of the fields a source has reported so far.
+	SaveSourceFields(source string, fields []string) error
+
+	// GetOwnedValues returns the source-owned attribute values last written to a user, or nil if
+	// none have been.
+	GetOwnedValues(userID string) (*OwnedValues, error)
+	// SaveOwnedValues persists the source-owned attribute values written to a user, keeping values
+	// from a later sync that were saved concurrently.
+	SaveOwnedValues(userID string, values *OwnedValues) error
+	// ListOwnedValuesUserIDs returns the IDs of the users with recorded source-owned values.
+	ListOwnedValuesUserIDs() ([]string, error)
+	// DeleteOwnedValues removes the source-owned values recorded for a user.
+	DeleteOwnedValues(userID string) error
+
+	// GetRuleMembers returns the IDs of the users a membership rule has added and still manages.
+	GetRuleMembers(rule string) ([]string, error)
+	// SaveRuleMembers persists the IDs of the users a membership rule manages.
+	SaveRuleMembers(rule string, userIDs []string) error
+	// GetRuleAdmins returns the IDs of the users a membership rule has granted the admin role to.
+	GetRuleAdmins(rule string) ([]string, error)
+	// SaveRuleAdmins persists the IDs of the users a membership rule has granted the admin role to.
+	SaveRuleAdmins(rule string, userIDs []string) error
+	// ListMembershipRules returns the names of the membership rules with recorded members or admins.
+	ListMembershipRules() ([]string, error)
+	// RemoveRuleUsers removes users from the members and admins recorded for a membership rule,
+	// keeping users recorded concurrently. It returns how many of the users were recorded.
+	RemoveRuleUsers(rule string, userIDs []string) (int, error)
 }
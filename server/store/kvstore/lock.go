@@ -0,0 +1,90 @@
+package kvstore
+
+import (
+	"time"
+)
+
+// syncLockKey stores the holder of the cluster-wide sync lock, and syncCancelNamespace the
+// requests to cancel the run holding it. Both are transient like the lock itself.
+const (
+	syncLockKey         = "sync_lock"
+	syncCancelNamespace = "sync_cancel"
+)
+
+// Triggers of the work holding the sync lock.
+const (
+	SyncTriggerScheduled = "scheduled"
+	SyncTriggerManual    = "manual"
+
+	// SyncTriggerImport is an archive import, which replaces the state runs work on.
+	SyncTriggerImport = "import"
+)
+
+// SyncLock describes the node holding the cluster-wide sync lock. The cluster mutex guarding
+// runs carries no data, so the holder records itself here for others to report.
+type SyncLock struct {
+	NodeID string `json:"node_id"`
+
+	// Trigger is what took the lock, one of the SyncTrigger values.
+	Trigger string `json:"trigger"`
+
+	// RunID is the ID of the run holding the lock, once it has started.
+	RunID string `json:"run_id,omitempty"`
+
+	StartedAt time.Time `json:"started_at"`
+
+	// HeartbeatAt is refreshed while the holder runs. A holder that stopped refreshing it has
+	// crashed without releasing the lock.
+	HeartbeatAt time.Time `json:"heartbeat_at"`
+}
+
+// Description describes what holds the lock, such as "a scheduled sync" or "an archive import".
+func (l *SyncLock) Description() string {
+	if l.Trigger == SyncTriggerImport {
+		return "an archive import"
+	}
+	return "a " + l.Trigger + " sync"
+}
+
+// Stale reports whether the holder has not refreshed its heartbeat for longer than timeout.
+func (l *SyncLock) Stale(now time.Time, timeout time.Duration) bool {
+	return now.Sub(l.HeartbeatAt) > timeout
+}
+
+// GetSyncLock returns the holder of the sync lock, or nil if none is recorded.
+func (kv Client) GetSyncLock() (*SyncLock, error) {
+	return kv.syncLock.Get()
+}
+
+// SaveSyncLock records the holder of the sync lock.
+func (kv Client) SaveSyncLock(lock *SyncLock) error {
+	return kv.syncLock.Set(lock)
+}
+
+// DeleteSyncLock removes the recorded holder of the sync lock.
+func (kv Client) DeleteSyncLock() error {
+	return kv.syncLock.Delete()
+}
+
+// SyncCancel is a request to stop a sync run in progress. The run checks for it between pages,
+// on whichever node it runs.
+type SyncCancel struct {
+	RunID       string    `json:"run_id"`
+	RequestedBy string    `json:"requested_by"`
+	RequestedAt time.Time `json:"requested_at"`
+}
+
+// GetSyncCancel returns the request to cancel the run with runID, or nil if there is none.
+func (kv Client) GetSyncCancel(runID string) (*SyncCancel, error) {
+	return kv.cancels.Get(runID)
+}
+
+// SaveSyncCancel stores a request to cancel a run.
+func (kv Client) SaveSyncCancel(cancel *SyncCancel) error {
+	return kv.cancels.Set(cancel.RunID, cancel)
+}
+
+// DeleteSyncCancel removes the request to cancel the run with runID.
+func (kv Client) DeleteSyncCancel(runID string) error {
+	return kv.cancels.Delete(runID)
+}
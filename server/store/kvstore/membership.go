@@ -0,0 +1,77 @@
+package kvstore
+
+import "slices"
+
+// GetRuleMembers returns the IDs of the users a membership rule has added.
+func (kv Client) GetRuleMembers(rule string) ([]string, error) {
+	return getList(kv.ruleMembers, rule)
+}
+
+// SaveRuleMembers stores the IDs of the users a membership rule has added.
+func (kv Client) SaveRuleMembers(rule string, userIDs []string) error {
+	return kv.ruleMembers.Set(rule, &userIDs)
+}
+
+// GetRuleAdmins returns the IDs of the users a membership rule has granted the admin role to.
+func (kv Client) GetRuleAdmins(rule string) ([]string, error) {
+	return getList(kv.ruleAdmins, rule)
+}
+
+// SaveRuleAdmins stores the IDs of the users a membership rule has granted the admin role to.
+func (kv Client) SaveRuleAdmins(rule string, userIDs []string) error {
+	return kv.ruleAdmins.Set(rule, &userIDs)
+}
+
+// ListMembershipRules returns the names of the membership rules with recorded members or admins.
+func (kv Client) ListMembershipRules() ([]string, error) {
+	rules, err := kv.ruleMembers.List()
+	if err != nil {
+		return nil, err
+	}
+	adminRules, err := kv.ruleAdmins.List()
+	if err != nil {
+		return nil, err
+	}
+	rules = append(rules, adminRules...)
+	slices.Sort(rules)
+	return slices.Compact(rules), nil
+}
+
+// RemoveRuleUsers removes users from the members and admins recorded for a membership rule. It
+// returns how many of the users were recorded as either.
+func (kv Client) RemoveRuleUsers(rule string, userIDs []string) (int, error) {
+	removed := map[string]bool{}
+	remove := func(current *[]string) (*[]string, error) {
+		if current == nil {
+			return nil, nil
+		}
+		kept := slices.DeleteFunc(slices.Clone(*current), func(userID string) bool {
+			return slices.Contains(userIDs, userID)
+		})
+		if len(kept) == len(*current) {
+			return nil, nil
+		}
+		for _, userID := range *current {
+			if slices.Contains(userIDs, userID) {
+				removed[userID] = true
+			}
+		}
+		return &kept, nil
+	}
+	if _, err := kv.ruleMembers.Update(rule, remove); err != nil {
+		return 0, err
+	}
+	if _, err := kv.ruleAdmins.Update(rule, remove); err != nil {
+		return 0, err
+	}
+	return len(removed), nil
+}
+
+// getList returns the list stored under id, or nil if there is none.
+func getList(r Repository[[]string], id string) ([]string, error) {
+	list, err := r.Get(id)
+	if err != nil || list == nil {
+		return nil, err
+	}
+	return *list, nil
+}
@@ -0,0 +1,42 @@
+package kvstore
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRemoveRuleUsers(t *testing.T) {
+	_, kv := newFakeKV()
+	store := Client{
+		kv:          kv,
+		ruleMembers: NewRepository[[]string](kv, "membership_rule", 1),
+		ruleAdmins:  NewRepository[[]string](kv, "membership_rule_admins", 1),
+	}
+	require.NoError(t, store.SaveRuleMembers("engineering", []string{"u1", "u2", "u3"}))
+	require.NoError(t, store.SaveRuleAdmins("engineering", []string{"u2"}))
+	require.NoError(t, store.SaveRuleAdmins("leads", []string{"u1"}))
+
+	rules, err := store.ListMembershipRules()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"engineering", "leads"}, rules)
+
+	removed, err := store.RemoveRuleUsers("engineering", []string{"u2", "u3", "u4"})
+	require.NoError(t, err)
+	assert.Equal(t, 2, removed)
+
+	members, err := store.GetRuleMembers("engineering")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"u1"}, members)
+	admins, err := store.GetRuleAdmins("engineering")
+	require.NoError(t, err)
+	assert.Empty(t, admins)
+
+	removed, err = store.RemoveRuleUsers("sales", []string{"u1"})
+	require.NoError(t, err)
+	assert.Zero(t, removed, "rules without records are left alone")
+	members, err = store.GetRuleMembers("sales")
+	require.NoError(t, err)
+	assert.Nil(t, members)
+}
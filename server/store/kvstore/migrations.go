@@ -0,0 +1,115 @@
+package kvstore
+
+import (
+	"encoding/json"
+
+	"github.com/pkg/errors"
+)
+
+// schemaVersionKey holds the version of the last migration applied to the stored data.
+const schemaVersionKey = "kv_schema_version"
+
+// Migration upgrades the stored data to a schema version. Migrations must be idempotent, as one
+// interrupted before its version is recorded runs again.
+type Migration struct {
+	Version int
+	Name    string
+	Migrate func(kv KVService) error
+}
+
+// migrations lists every migration in ascending version order. Once released, a migration must
+// not change: add a new one instead.
+var migrations = []Migration{
+	{Version: 1, Name: "wrap values in versioned envelopes", Migrate: wrapInEnvelopes},
+}
+
+// LatestSchemaVersion returns the schema version the stored data is migrated to.
+func LatestSchemaVersion() int {
+	return migrations[len(migrations)-1].Version
+}
+
+// Migrate applies the migrations newer than the stored schema version in order, recording the
+// version after each one. It returns the versions before and after. Callers must ensure a single
+// node migrates at a time.
+func (kv Client) Migrate() (from, to int, err error) {
+	return migrate(kv.kv, migrations)
+}
+
+func migrate(kv KVService, migrations []Migration) (from, to int, err error) {
+	schemaVersion := NewValue[int](kv, schemaVersionKey, 1)
+	stored, err := schemaVersion.Get()
+	if err != nil {
+		return 0, 0, err
+	}
+	if stored != nil {
+		from = *stored
+	}
+	to = from
+	if latest := migrations[len(migrations)-1].Version; from > latest {
+		return from, to, errors.Errorf("stored data has schema version %d, newer than the supported %d", from, latest)
+	}
+
+	for _, migration := range migrations {
+		if migration.Version <= to {
+			continue
+		}
+		if err = migration.Migrate(kv); err != nil {
+			return from, to, errors.Wrapf(err, "failed to migrate to schema version %d (%s)", migration.Version, migration.Name)
+		}
+		to = migration.Version
+		if err = schemaVersion.Set(&to); err != nil {
+			return from, to, err
+		}
+	}
+	return from, to, nil
+}
+
+// wrapInEnvelopes re-encodes the values written before versioned envelopes were introduced.
+func wrapInEnvelopes(kv KVService) error {
+	var keys []string
+	for _, prefix := range []string{
+		"template_key-",
+		"sync_cursor-",
+		"sync_checkpoint-",
+		"sync_active_run-",
+		"sync_source_fields-",
+		"sync_run-",
+		"sync_failure-",
+		"sync_owned_values-",
+		"membership_rule-",
+		"membership_rule_admins-",
+	} {
+		prefixKeys, err := listKeys(kv, prefix)
+		if err != nil {
+			return err
+		}
+		keys = append(keys, prefixKeys...)
+	}
+	keys = append(keys, "sync_last_run")
+
+	for _, key := range keys {
+		var raw []byte
+		if err := kv.Get(key, &raw); err != nil {
+			return errors.Wrapf(err, "failed to get %s", key)
+		}
+		if len(raw) == 0 {
+			continue
+		}
+		var value json.RawMessage
+		version, err := decode(raw, &value)
+		if err != nil {
+			return errors.Wrapf(err, "failed to decode %s", key)
+		}
+		if version > 0 {
+			continue
+		}
+		data, err := encode(1, value)
+		if err != nil {
+			return errors.Wrapf(err, "failed to encode %s", key)
+		}
+		if _, err = kv.Set(key, data); err != nil {
+			return errors.Wrapf(err, "failed to set %s", key)
+		}
+	}
+	return nil
+}
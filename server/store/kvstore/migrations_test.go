@@ -0,0 +1,79 @@
+package kvstore
+
+import (
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMigrate(t *testing.T) {
+	t.Run("applies pending migrations in order", func(t *testing.T) {
+		api, kv := newFakeKV()
+		var applied []int
+		testMigrations := []Migration{
+			{Version: 1, Migrate: func(KVService) error { applied = append(applied, 1); return nil }},
+			{Version: 2, Migrate: func(KVService) error { applied = append(applied, 2); return nil }},
+		}
+
+		from, to, err := migrate(kv, testMigrations)
+		require.NoError(t, err)
+		assert.Equal(t, 0, from)
+		assert.Equal(t, 2, to)
+		assert.Equal(t, []int{1, 2}, applied)
+		assert.JSONEq(t, `{"schema_version": 1, "data": 2}`, string(api.values[schemaVersionKey]))
+
+		from, to, err = migrate(kv, testMigrations)
+		require.NoError(t, err)
+		assert.Equal(t, 2, from)
+		assert.Equal(t, 2, to)
+		assert.Equal(t, []int{1, 2}, applied, "applied migrations do not run again")
+	})
+
+	t.Run("records progress up to a failing migration", func(t *testing.T) {
+		_, kv := newFakeKV()
+		testMigrations := []Migration{
+			{Version: 1, Migrate: func(KVService) error { return nil }},
+			{Version: 2, Name: "broken", Migrate: func(KVService) error { return errors.New("boom") }},
+		}
+
+		_, to, err := migrate(kv, testMigrations)
+		assert.ErrorContains(t, err, "failed to migrate to schema version 2 (broken): boom")
+		assert.Equal(t, 1, to)
+
+		from, _, _ := migrate(kv, testMigrations[:1])
+		assert.Equal(t, 1, from)
+	})
+
+	t.Run("refuses data from a newer plugin version", func(t *testing.T) {
+		api, kv := newFakeKV()
+		api.values[schemaVersionKey] = []byte(`{"schema_version": 1, "data": 5}`)
+
+		_, _, err := migrate(kv, migrations)
+		assert.ErrorContains(t, err, "schema version 5")
+	})
+
+	t.Run("wraps legacy values in envelopes", func(t *testing.T) {
+		api, kv := newFakeKV()
+		api.values["sync_last_run"] = []byte(`"r1"`)
+		api.values["sync_run-r1"] = []byte(`{"id":"r1","status":"succeeded"}`)
+		api.values["membership_rule-eng"] = []byte(`["u1","u2"]`)
+		api.values["sync_cursor-hr"] = []byte(`{"schema_version":1,"data":{"cursor":"c1"}}`)
+		api.values["mutex_other"] = []byte(`locked`)
+
+		_, to, err := migrate(kv, migrations)
+		require.NoError(t, err)
+		assert.Equal(t, 1, to)
+
+		assert.JSONEq(t, `{"schema_version":1,"data":"r1"}`, string(api.values["sync_last_run"]))
+		assert.JSONEq(t, `{"schema_version":1,"data":{"id":"r1","status":"succeeded"}}`, string(api.values["sync_run-r1"]))
+		assert.JSONEq(t, `{"schema_version":1,"data":["u1","u2"]}`, string(api.values["membership_rule-eng"]))
+		assert.JSONEq(t, `{"schema_version":1,"data":{"cursor":"c1"}}`, string(api.values["sync_cursor-hr"]))
+		assert.Equal(t, `locked`, string(api.values["mutex_other"]), "keys of other features are untouched")
+
+		members, err := NewRepository[[]string](kv, "membership_rule", 1).Get("eng")
+		require.NoError(t, err)
+		assert.Equal(t, []string{"u1", "u2"}, *members)
+	})
+}
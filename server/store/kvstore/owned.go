@@ -0,0 +1,39 @@
+package kvstore
+
+import (
+	"time"
+)
+
+// OwnedValues records the values of a user's source-owned attributes as last written by the sync,
+// so that edits made outside the sync can be detected and reverted.
+type OwnedValues struct {
+	Values   map[string]string `json:"values"`
+	SyncedAt time.Time         `json:"synced_at"`
+}
+
+// GetOwnedValues returns the source-owned values of a user, or nil if none have been recorded.
+func (kv Client) GetOwnedValues(userID string) (*OwnedValues, error) {
+	return kv.ownedValues.Get(userID)
+}
+
+// ListOwnedValuesUserIDs returns the IDs of the users with recorded source-owned values.
+func (kv Client) ListOwnedValuesUserIDs() ([]string, error) {
+	return kv.ownedValues.List()
+}
+
+// DeleteOwnedValues removes the source-owned values of a user.
+func (kv Client) DeleteOwnedValues(userID string) error {
+	return kv.ownedValues.Delete(userID)
+}
+
+// SaveOwnedValues stores the source-owned values of a user, unless values written by a more recent
+// sync were stored concurrently.
+func (kv Client) SaveOwnedValues(userID string, values *OwnedValues) error {
+	_, err := kv.ownedValues.Update(userID, func(current *OwnedValues) (*OwnedValues, error) {
+		if current != nil && current.SyncedAt.After(values.SyncedAt) {
+			return nil, nil
+		}
+		return values, nil
+	})
+	return err
+}
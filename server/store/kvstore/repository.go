@@ -0,0 +1,279 @@
+package kvstore
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/mattermost/mattermost/server/public/pluginapi"
+	"github.com/pkg/errors"
+)
+
+// KVService is the subset of the plugin KV API the repositories are built on.
+// *pluginapi.KVService satisfies it.
+type KVService interface {
+	Get(key string, o any) error
+	Set(key string, value any, options ...pluginapi.KVSetOption) (bool, error)
+	Delete(key string) error
+	ListKeys(page, count int, options ...pluginapi.ListKeysOption) ([]string, error)
+}
+
+const listKeysPerPage = 1000
+
+// updateAttempts bounds how often Update retries a value that keeps changing concurrently.
+const updateAttempts = 5
+
+// ErrConflict is returned by Update when the value kept being changed by others.
+var ErrConflict = errors.New("value was changed concurrently")
+
+// envelope wraps every stored value with the schema version it was encoded with, so that values
+// written by older plugin versions can be recognized and migrated.
+type envelope struct {
+	Version int             `json:"schema_version"`
+	Data    json.RawMessage `json:"data"`
+}
+
+// encode wraps value in an envelope of version and marshals it.
+func encode(version int, value any) ([]byte, error) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(envelope{Version: version, Data: data})
+}
+
+// decode unmarshals raw into value and returns the version it was stored with. Values stored
+// before envelopes were introduced are decoded as is and reported as version 0.
+func decode(raw []byte, value any) (int, error) {
+	var env envelope
+	if err := json.Unmarshal(raw, &env); err != nil || env.Version == 0 {
+		return 0, json.Unmarshal(raw, value)
+	}
+	return env.Version, json.Unmarshal(env.Data, value)
+}
+
+// Repository stores values of type T under the keys of a namespace, "<namespace>-<id>".
+type Repository[T any] struct {
+	kv        KVService
+	namespace string
+	version   int
+}
+
+// NewRepository creates a repository for the namespace whose values are encoded with the given
+// schema version.
+func NewRepository[T any](kv KVService, namespace string, version int) Repository[T] {
+	return Repository[T]{
+		kv:        kv,
+		namespace: namespace,
+		version:   version,
+	}
+}
+
+// Key returns the KV key of the value with id.
+func (r Repository[T]) Key(id string) string {
+	return r.namespace + "-" + id
+}
+
+// Get returns the value with id, or nil if there is none.
+func (r Repository[T]) Get(id string) (*T, error) {
+	return getValue[T](r.kv, r.Key(id), r.version)
+}
+
+// Set stores value under id.
+func (r Repository[T]) Set(id string, value *T) error {
+	return setValue(r.kv, r.Key(id), r.version, value)
+}
+
+// Update atomically replaces the value with id by the result of fn, which is passed the current
+// value, or nil if there is none. If fn returns nil, nothing is written. The write only succeeds
+// if the value is unchanged since it was read; otherwise fn is called again with the new value.
+// It returns the value stored once Update returns.
+func (r Repository[T]) Update(id string, fn func(current *T) (*T, error)) (*T, error) {
+	key := r.Key(id)
+	for range updateAttempts {
+		var raw []byte
+		if err := r.kv.Get(key, &raw); err != nil {
+			return nil, errors.Wrapf(err, "failed to get %s", key)
+		}
+		current, err := decodeValue[T](key, raw, r.version)
+		if err != nil {
+			return nil, err
+		}
+
+		value, err := fn(current)
+		if err != nil {
+			return nil, err
+		}
+		if value == nil {
+			return current, nil
+		}
+		data, err := encode(r.version, value)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to encode %s", key)
+		}
+		// A nil old value only matches a missing key.
+		written, err := r.kv.Set(key, data, pluginapi.SetAtomic(raw))
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to set %s", key)
+		}
+		if written {
+			return value, nil
+		}
+	}
+	return nil, errors.Wrapf(ErrConflict, "failed to update %s", key)
+}
+
+// SetIn adds a write of value under id to batch.
+func (r Repository[T]) SetIn(batch *Batch, id string, value *T) error {
+	return batch.set(r.Key(id), r.version, value)
+}
+
+// DeleteIn adds the removal of the value with id to batch.
+func (r Repository[T]) DeleteIn(batch *Batch, id string) {
+	batch.delete(r.Key(id))
+}
+
+// Delete removes the value with id. Deleting a missing value is not an error.
+func (r Repository[T]) Delete(id string) error {
+	if err := r.kv.Delete(r.Key(id)); err != nil {
+		return errors.Wrapf(err, "failed to delete %s", r.Key(id))
+	}
+	return nil
+}
+
+// List returns the IDs of every value in the namespace.
+func (r Repository[T]) List() ([]string, error) {
+	keys, err := listKeys(r.kv, r.Key(""))
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]string, 0, len(keys))
+	for _, key := range keys {
+		ids = append(ids, strings.TrimPrefix(key, r.Key("")))
+	}
+	return ids, nil
+}
+
+// Value stores a single value of type T under a fixed key.
+type Value[T any] struct {
+	kv      KVService
+	key     string
+	version int
+}
+
+// NewValue creates a Value stored under key and encoded with the given schema version.
+func NewValue[T any](kv KVService, key string, version int) Value[T] {
+	return Value[T]{
+		kv:      kv,
+		key:     key,
+		version: version,
+	}
+}
+
+// Get returns the stored value, or nil if there is none.
+func (v Value[T]) Get() (*T, error) {
+	return getValue[T](v.kv, v.key, v.version)
+}
+
+// Set stores value.
+func (v Value[T]) Set(value *T) error {
+	return setValue(v.kv, v.key, v.version, value)
+}
+
+// SetIn adds a write of value to batch.
+func (v Value[T]) SetIn(batch *Batch, value *T) error {
+	return batch.set(v.key, v.version, value)
+}
+
+// Delete removes the value. Deleting a missing value is not an error.
+func (v Value[T]) Delete() error {
+	if err := v.kv.Delete(v.key); err != nil {
+		return errors.Wrapf(err, "failed to delete %s", v.key)
+	}
+	return nil
+}
+
+func getValue[T any](kv KVService, key string, version int) (*T, error) {
+	var raw []byte
+	if err := kv.Get(key, &raw); err != nil {
+		return nil, errors.Wrapf(err, "failed to get %s", key)
+	}
+	return decodeValue[T](key, raw, version)
+}
+
+func decodeValue[T any](key string, raw []byte, version int) (*T, error) {
+	if len(raw) == 0 {
+		return nil, nil
+	}
+
+	value := new(T)
+	stored, err := decode(raw, value)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to decode %s", key)
+	}
+	if stored > version {
+		return nil, errors.Errorf("%s was stored with schema version %d, newer than the supported %d", key, stored, version)
+	}
+	return value, nil
+}
+
+func setValue[T any](kv KVService, key string, version int, value *T) error {
+	data, err := encode(version, value)
+	if err != nil {
+		return errors.Wrapf(err, "failed to encode %s", key)
+	}
+	if _, err = kv.Set(key, data); err != nil {
+		return errors.Wrapf(err, "failed to set %s", key)
+	}
+	return nil
+}
+
+// Batch collects writes to several keys, possibly of different repositories, and applies them in
+// the order they were added. The plugin KV API has no multi-key transactions, so writes that
+// others depend on, such as a value before the pointer to it, should be added first.
+type Batch struct {
+	kv     KVService
+	writes []batchWrite
+}
+
+type batchWrite struct {
+	key string
+
+	// data is nil for deletions.
+	data []byte
+}
+
+// NewBatch creates an empty batch writing to kv.
+func NewBatch(kv KVService) *Batch {
+	return &Batch{kv: kv}
+}
+
+func (b *Batch) set(key string, version int, value any) error {
+	data, err := encode(version, value)
+	if err != nil {
+		return errors.Wrapf(err, "failed to encode %s", key)
+	}
+	b.writes = append(b.writes, batchWrite{key: key, data: data})
+	return nil
+}
+
+func (b *Batch) delete(key string) {
+	b.writes = append(b.writes, batchWrite{key: key})
+}
+
+// Flush applies the collected writes and empties the batch. It stops at the first failing write,
+// reporting how many were applied.
+func (b *Batch) Flush() error {
+	for i, write := range b.writes {
+		var err error
+		if write.data == nil {
+			err = b.kv.Delete(write.key)
+		} else {
+			_, err = b.kv.Set(write.key, write.data)
+		}
+		if err != nil {
+			return errors.Wrapf(err, "failed to write %s after applying %d of %d batched writes", write.key, i, len(b.writes))
+		}
+	}
+	b.writes = nil
+	return nil
+}
@@ -0,0 +1,224 @@
+package kvstore
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/plugin"
+	"github.com/mattermost/mattermost/server/public/pluginapi"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeAPI keeps plugin KV values in memory, so that repositories are exercised through the real
+// pluginapi KV service.
+type fakeAPI struct {
+	plugin.API
+	values map[string][]byte
+
+	// beforeSet, if set, is called before each write.
+	beforeSet func(key string)
+}
+
+func newFakeKV() (*fakeAPI, KVService) {
+	api := &fakeAPI{values: map[string][]byte{}}
+	return api, &pluginapi.NewClient(api, nil).KV
+}
+
+func (f *fakeAPI) KVGet(key string) ([]byte, *model.AppError) {
+	return f.values[key], nil
+}
+
+func (f *fakeAPI) KVSetWithOptions(key string, value []byte, options model.PluginKVSetOptions) (bool, *model.AppError) {
+	if f.beforeSet != nil {
+		f.beforeSet(key)
+	}
+	if current, ok := f.values[key]; options.Atomic && (ok != (options.OldValue != nil) || !bytes.Equal(current, options.OldValue)) {
+		return false, nil
+	}
+	if value == nil {
+		delete(f.values, key)
+	} else {
+		f.values[key] = value
+	}
+	return true, nil
+}
+
+func (f *fakeAPI) KVList(page, perPage int) ([]string, *model.AppError) {
+	keys := make([]string, 0, len(f.values))
+	for key := range f.values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	start := min(page*perPage, len(keys))
+	return keys[start:min(start+perPage, len(keys))], nil
+}
+
+func TestRepository(t *testing.T) {
+	t.Run("round trips values in a versioned envelope", func(t *testing.T) {
+		api, kv := newFakeKV()
+		runs := NewRepository[SyncRun](kv, "sync_run", 1)
+
+		run, err := runs.Get("r1")
+		require.NoError(t, err)
+		assert.Nil(t, run)
+
+		require.NoError(t, runs.Set("r1", &SyncRun{ID: "r1", Status: RunStatusSucceeded}))
+		assert.JSONEq(t, `{"schema_version": 1, "data": {"id": "r1", "source": "", "status": "succeeded", "full": false, "resumed": false,
+			"stats": {"fetched": 0, "matched": 0, "updated": 0, "unmatched": 0, "failed": 0, "quarantined": 0, "excluded": 0, "reset": 0},
+			"started_at": "0001-01-01T00:00:00Z", "finished_at": "0001-01-01T00:00:00Z"}}`, string(api.values["sync_run-r1"]))
+
+		run, err = runs.Get("r1")
+		require.NoError(t, err)
+		assert.Equal(t, &SyncRun{ID: "r1", Status: RunStatusSucceeded}, run)
+
+		require.NoError(t, runs.Delete("r1"))
+		assert.Empty(t, api.values)
+	})
+
+	t.Run("reads values stored before envelopes", func(t *testing.T) {
+		api, kv := newFakeKV()
+		api.values["sync_last_run"] = []byte(`"r1"`)
+		api.values["sync_source_fields-hr"] = []byte(`["dept","title"]`)
+
+		runID, err := NewValue[string](kv, "sync_last_run", 1).Get()
+		require.NoError(t, err)
+		assert.Equal(t, "r1", *runID)
+
+		fields, err := NewRepository[[]string](kv, "sync_source_fields", 1).Get("hr")
+		require.NoError(t, err)
+		assert.Equal(t, []string{"dept", "title"}, *fields)
+	})
+
+	t.Run("rejects values from a newer schema", func(t *testing.T) {
+		api, kv := newFakeKV()
+		api.values["sync_cursor-hr"] = []byte(`{"schema_version": 2, "data": {}}`)
+
+		_, err := NewRepository[SyncCursor](kv, "sync_cursor", 1).Get("hr")
+		assert.ErrorContains(t, err, "schema version 2")
+	})
+
+	t.Run("lists the IDs of a namespace across pages", func(t *testing.T) {
+		api, kv := newFakeKV()
+		failures := NewRepository[UserFailure](kv, "sync_failure", 1)
+		for i := range listKeysPerPage + 5 {
+			api.values[fmt.Sprintf("other-%04d", i)] = []byte(`{}`)
+		}
+		require.NoError(t, failures.Set("id:1", &UserFailure{Key: "id:1"}))
+		require.NoError(t, failures.Set("id:2", &UserFailure{Key: "id:2"}))
+
+		ids, err := failures.List()
+		require.NoError(t, err)
+		assert.Equal(t, []string{"id:1", "id:2"}, ids)
+	})
+
+	t.Run("updates values atomically", func(t *testing.T) {
+		api, kv := newFakeKV()
+		owned := NewRepository[OwnedValues](kv, "sync_owned_values", 1)
+		require.NoError(t, owned.Set("u1", &OwnedValues{Values: map[string]string{"department": "Sales"}}))
+
+		// Another node writes the value after it was read, once.
+		raced := false
+		api.beforeSet = func(key string) {
+			if !raced {
+				raced = true
+				api.values[key] = []byte(`{"schema_version": 1, "data": {"values": {"department": "Legal"}}}`)
+			}
+		}
+		var seen []string
+		value, err := owned.Update("u1", func(current *OwnedValues) (*OwnedValues, error) {
+			seen = append(seen, current.Values["department"])
+			return &OwnedValues{Values: map[string]string{"department": current.Values["department"] + "!"}}, nil
+		})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"Sales", "Legal"}, seen)
+		assert.Equal(t, "Legal!", value.Values["department"])
+
+		writes := 0
+		api.beforeSet = func(key string) {
+			writes++
+			api.values[key] = fmt.Appendf(nil, `{"schema_version": 1, "data": {"values": {"n": "%d"}}}`, writes)
+		}
+		_, err = owned.Update("u1", func(*OwnedValues) (*OwnedValues, error) {
+			return &OwnedValues{}, nil
+		})
+		assert.ErrorIs(t, err, ErrConflict)
+	})
+
+	t.Run("only creates missing values when updating from nil", func(t *testing.T) {
+		api, kv := newFakeKV()
+		cursors := NewRepository[SyncCursor](kv, "sync_cursor", 1)
+		api.beforeSet = func(key string) {
+			api.values[key] = []byte(`{"schema_version": 1, "data": {"cursor": "theirs"}}`)
+			api.beforeSet = nil
+		}
+
+		value, err := cursors.Update("hr", func(current *SyncCursor) (*SyncCursor, error) {
+			if current != nil {
+				return nil, nil
+			}
+			return &SyncCursor{Cursor: "ours"}, nil
+		})
+		require.NoError(t, err)
+		assert.Equal(t, "theirs", value.Cursor)
+	})
+}
+
+func TestBatch(t *testing.T) {
+	api, kv := newFakeKV()
+	runs := NewRepository[SyncRun](kv, "sync_run", 1)
+	lastRun := NewValue[string](kv, "sync_last_run", 1)
+	require.NoError(t, runs.Set("old", &SyncRun{ID: "old"}))
+
+	batch := NewBatch(kv)
+	require.NoError(t, runs.SetIn(batch, "new", &SyncRun{ID: "new"}))
+	runID := "new"
+	require.NoError(t, lastRun.SetIn(batch, &runID))
+	runs.DeleteIn(batch, "old")
+	assert.Len(t, api.values, 1, "nothing is written before the batch is flushed")
+
+	require.NoError(t, batch.Flush())
+	stored, err := lastRun.Get()
+	require.NoError(t, err)
+	assert.Equal(t, "new", *stored)
+	ids, err := runs.List()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"new"}, ids)
+}
+
+func TestSaveOwnedValues(t *testing.T) {
+	_, kv := newFakeKV()
+	client := Client{kv: kv, ownedValues: NewRepository[OwnedValues](kv, "sync_owned_values", 1)}
+	now := time.Now()
+
+	require.NoError(t, client.SaveOwnedValues("u1", &OwnedValues{Values: map[string]string{"department": "Legal"}, SyncedAt: now}))
+	require.NoError(t, client.SaveOwnedValues("u1", &OwnedValues{Values: map[string]string{"department": "Sales"}, SyncedAt: now.Add(-time.Minute)}))
+
+	values, err := client.GetOwnedValues("u1")
+	require.NoError(t, err)
+	assert.Equal(t, "Legal", values.Values["department"], "values of an older sync do not overwrite newer ones")
+}
+
+func TestKeyIterator(t *testing.T) {
+	api, kv := newFakeKV()
+	for i := range 25 {
+		api.values[fmt.Sprintf("a-%02d", i)] = []byte(`{}`)
+		api.values[fmt.Sprintf("b-%02d", i)] = []byte(`{}`)
+	}
+
+	it := NewKeyIterator(kv, "b-")
+	it.perPage = 10
+	var keys []string
+	for it.Next() {
+		keys = append(keys, it.Key())
+	}
+	require.NoError(t, it.Err())
+	assert.Len(t, keys, 25)
+	assert.Equal(t, "b-00", keys[0])
+	assert.Equal(t, "b-24", keys[24])
+	assert.False(t, it.Next(), "an exhausted iterator stays exhausted")
+}
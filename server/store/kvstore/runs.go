@@ -0,0 +1,151 @@
+package kvstore
+
+import (
+	"time"
+)
+
+// Statuses of a finished sync run.
+const (
+	RunStatusSucceeded   = "succeeded"
+	RunStatusFailed      = "failed"
+	RunStatusInterrupted = "interrupted"
+	RunStatusAborted     = "aborted"
+	RunStatusCancelled   = "cancelled"
+)
+
+// RunStatusRunning is reported for a run that has not finished. Runs are only stored once they
+// finish, so it is never stored.
+const RunStatusRunning = "running"
+
+// SyncRun is the outcome of a sync run.
+type SyncRun struct {
+	ID      string    `json:"id"`
+	Source  string    `json:"source"`
+	Status  string    `json:"status"`
+	Error   string    `json:"error,omitempty"`
+	Full    bool      `json:"full"`
+	Resumed bool      `json:"resumed"`
+	Stats   SyncStats `json:"stats"`
+
+	// Manual runs were requested for the listed user IDs rather than scheduled.
+	Manual  bool     `json:"manual,omitempty"`
+	UserIDs []string `json:"user_ids,omitempty"`
+
+	StartedAt  time.Time `json:"started_at"`
+	FinishedAt time.Time `json:"finished_at"`
+}
+
+// UserFailure tracks a source record that could not be synced. Records failing in too many
+// consecutive runs are quarantined and skipped until released.
+type UserFailure struct {
+	// Key identifies the source record, see attrsync.RecordKey.
+	Key        string `json:"key"`
+	ExternalID string `json:"external_id,omitempty"`
+	Email      string `json:"email,omitempty"`
+	UserID     string `json:"user_id,omitempty"`
+
+	// Failures counts the consecutive runs in which the record failed.
+	Failures    int    `json:"failures"`
+	LastError   string `json:"last_error"`
+	LastRunID   string `json:"last_run_id"`
+	Quarantined bool   `json:"quarantined"`
+
+	FirstFailedAt time.Time `json:"first_failed_at"`
+	LastFailedAt  time.Time `json:"last_failed_at"`
+}
+
+// GetRun returns the run with the given ID, or nil if there is none.
+func (kv Client) GetRun(runID string) (*SyncRun, error) {
+	return kv.runs.Get(runID)
+}
+
+// GetLastRun returns the most recently saved run, or nil if there is none.
+func (kv Client) GetLastRun() (*SyncRun, error) {
+	runID, err := kv.lastRun.Get()
+	if err != nil || runID == nil {
+		return nil, err
+	}
+	return kv.GetRun(*runID)
+}
+
+// GetLastSuccessAt returns when the most recent successful run that is not manual finished, or
+// the zero time if there is none.
+func (kv Client) GetLastSuccessAt() (time.Time, error) {
+	finishedAt, err := kv.lastSuccess.Get()
+	if err != nil {
+		return time.Time{}, err
+	}
+	if finishedAt != nil {
+		return *finishedAt, nil
+	}
+	// Runs saved before the time was recorded only left their most recent run behind.
+	run, err := kv.GetLastRun()
+	if err != nil || run == nil || run.Status != RunStatusSucceeded {
+		return time.Time{}, err
+	}
+	return run.FinishedAt, nil
+}
+
+// SaveRun stores run and, unless it is a manual run, records it as the most recent run and, if it
+// succeeded, when it finished.
+func (kv Client) SaveRun(run *SyncRun) error {
+	batch := NewBatch(kv.kv)
+	if err := kv.runs.SetIn(batch, run.ID, run); err != nil {
+		return err
+	}
+	if !run.Manual {
+		if err := kv.lastRun.SetIn(batch, &run.ID); err != nil {
+			return err
+		}
+		if run.Status == RunStatusSucceeded {
+			if err := kv.lastSuccess.SetIn(batch, &run.FinishedAt); err != nil {
+				return err
+			}
+		}
+	}
+	return batch.Flush()
+}
+
+// ListRunIDs returns the IDs of every stored run.
+func (kv Client) ListRunIDs() ([]string, error) {
+	return kv.runs.List()
+}
+
+// DeleteRun removes the run with the given ID.
+func (kv Client) DeleteRun(runID string) error {
+	return kv.runs.Delete(runID)
+}
+
+// ListUserFailures returns all stored user failures.
+func (kv Client) ListUserFailures() ([]*UserFailure, error) {
+	keys, err := kv.failures.List()
+	if err != nil {
+		return nil, err
+	}
+	var failures []*UserFailure
+	for _, key := range keys {
+		failure, err := kv.failures.Get(key)
+		if err != nil {
+			return nil, err
+		}
+		if failure != nil {
+			failures = append(failures, failure)
+		}
+	}
+	return failures, nil
+}
+
+// GetUserFailure returns the failure stored for key, or nil if there is none.
+func (kv Client) GetUserFailure(key string) (*UserFailure, error) {
+	return kv.failures.Get(key)
+}
+
+// SaveUserFailure stores failure under its key.
+func (kv Client) SaveUserFailure(failure *UserFailure) error {
+	return kv.failures.Set(failure.Key, failure)
+}
+
+// DeleteUserFailure removes the failure stored for key.
+func (kv Client) DeleteUserFailure(key string) error {
+	return kv.failures.Delete(key)
+}
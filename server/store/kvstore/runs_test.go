@@ -0,0 +1,43 @@
+package kvstore
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetLastSuccessAt(t *testing.T) {
+	api, kv := newFakeKV()
+	store := Client{
+		kv:          kv,
+		runs:        NewRepository[SyncRun](kv, "sync_run", 1),
+		lastRun:     NewValue[string](kv, "sync_last_run", 1),
+		lastSuccess: NewValue[time.Time](kv, "sync_last_success", 1),
+	}
+	finishedAt := time.Date(2025, 3, 1, 9, 30, 0, 0, time.UTC)
+
+	lastSuccess, err := store.GetLastSuccessAt()
+	require.NoError(t, err)
+	assert.True(t, lastSuccess.IsZero())
+
+	t.Run("falls back to the last run saved before the time was recorded", func(t *testing.T) {
+		api.values["sync_run-r1"] = []byte(`{"schema_version": 1, "data": {"id": "r1", "status": "succeeded", "finished_at": "2025-03-01T09:30:00Z"}}`)
+		api.values["sync_last_run"] = []byte(`{"schema_version": 1, "data": "r1"}`)
+
+		lastSuccess, err := store.GetLastSuccessAt()
+		require.NoError(t, err)
+		assert.True(t, finishedAt.Equal(lastSuccess))
+	})
+
+	t.Run("keeps the last success over failed and manual runs", func(t *testing.T) {
+		require.NoError(t, store.SaveRun(&SyncRun{ID: "r2", Status: RunStatusSucceeded, FinishedAt: finishedAt.Add(time.Hour)}))
+		require.NoError(t, store.SaveRun(&SyncRun{ID: "r3", Status: RunStatusFailed, FinishedAt: finishedAt.Add(2 * time.Hour)}))
+		require.NoError(t, store.SaveRun(&SyncRun{ID: "r4", Status: RunStatusSucceeded, Manual: true, FinishedAt: finishedAt.Add(3 * time.Hour)}))
+
+		lastSuccess, err := store.GetLastSuccessAt()
+		require.NoError(t, err)
+		assert.True(t, finishedAt.Add(time.Hour).Equal(lastSuccess))
+	})
+}
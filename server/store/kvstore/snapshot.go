@@ -0,0 +1,106 @@
+package kvstore
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Snapshot holds the raw stored values of the plugin, as exported to an archive.
+type Snapshot struct {
+	// SchemaVersion is the schema version the values were stored with, see Migrate.
+	SchemaVersion int                        `json:"schema_version"`
+	Values        map[string]json.RawMessage `json:"values"`
+}
+
+// transientKeyPrefixes are the keys of cluster locks, their holders, cancel requests and job
+// schedules, which belong to the server they were taken on and are never exported. Keys starting
+// with mmi_ are reserved by the server, which stores the bot's user ID under mmi_botid as raw
+// bytes, and cannot be written by plugins.
+var transientKeyPrefixes = []string{"mmi_", "mutex_", "cron_", "once_", syncLockKey, syncCancelNamespace}
+
+func snapshotKey(key string) bool {
+	if key == schemaVersionKey {
+		return false
+	}
+	for _, prefix := range transientKeyPrefixes {
+		if strings.HasPrefix(key, prefix) {
+			return false
+		}
+	}
+	return true
+}
+
+// Export returns every stored value except reserved keys, cluster locks and job schedules.
+func (kv Client) Export() (*Snapshot, error) {
+	keys, err := listKeys(kv.kv, "")
+	if err != nil {
+		return nil, err
+	}
+	schemaVersion, err := NewValue[int](kv.kv, schemaVersionKey, 1).Get()
+	if err != nil {
+		return nil, err
+	}
+
+	snapshot := &Snapshot{Values: map[string]json.RawMessage{}}
+	if schemaVersion != nil {
+		snapshot.SchemaVersion = *schemaVersion
+	}
+	for _, key := range keys {
+		if !snapshotKey(key) {
+			continue
+		}
+		var raw []byte
+		if err = kv.kv.Get(key, &raw); err != nil {
+			return nil, errors.Wrapf(err, "failed to get %s", key)
+		}
+		if len(raw) == 0 {
+			continue
+		}
+		if !json.Valid(raw) {
+			return nil, errors.Errorf("%s does not hold a JSON value", key)
+		}
+		snapshot.Values[key] = raw
+	}
+	return snapshot, nil
+}
+
+// Import replaces the stored values by those of snapshot: values missing from it are deleted, and
+// the stored schema version becomes the snapshot's. Callers must run Migrate afterwards to bring
+// older snapshots up to date, and ensure nothing else writes to the store meanwhile.
+func (kv Client) Import(snapshot *Snapshot) error {
+	if latest := LatestSchemaVersion(); snapshot.SchemaVersion > latest {
+		return errors.Errorf("snapshot has schema version %d, newer than the supported %d", snapshot.SchemaVersion, latest)
+	}
+	for key, value := range snapshot.Values {
+		if !snapshotKey(key) {
+			return errors.Errorf("snapshot contains the reserved key %s", key)
+		}
+		if !json.Valid(value) {
+			return errors.Errorf("snapshot value of %s is not valid JSON", key)
+		}
+	}
+
+	keys, err := listKeys(kv.kv, "")
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		if _, ok := snapshot.Values[key]; ok || !snapshotKey(key) {
+			continue
+		}
+		if err = kv.kv.Delete(key); err != nil {
+			return errors.Wrapf(err, "failed to delete %s", key)
+		}
+	}
+
+	batch := NewBatch(kv.kv)
+	for key, value := range snapshot.Values {
+		batch.writes = append(batch.writes, batchWrite{key: key, data: value})
+	}
+	if err = batch.Flush(); err != nil {
+		return err
+	}
+	return NewValue[int](kv.kv, schemaVersionKey, 1).Set(&snapshot.SchemaVersion)
+}
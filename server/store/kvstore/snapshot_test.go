@@ -0,0 +1,60 @@
+package kvstore
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSnapshot(t *testing.T) {
+	t.Run("round trips values without cluster keys", func(t *testing.T) {
+		source, sourceKV := newFakeKV()
+		source.values["sync_runs-r1"] = []byte(`{"schema_version": 1, "data": {"id": "r1"}}`)
+		source.values["sync_last_run"] = []byte(`{"schema_version": 1, "data": "r1"}`)
+		source.values["mutex_sync"] = []byte{0x1}
+		source.values["cron_SyncJob"] = []byte(`{}`)
+		source.values["mmi_botid"] = []byte("a1b2c3d4e5f6g7h8i9j0k1l2m3")
+		source.values[schemaVersionKey] = []byte(`{"schema_version": 1, "data": 1}`)
+
+		snapshot, err := Client{kv: sourceKV}.Export()
+		require.NoError(t, err)
+		assert.Equal(t, 1, snapshot.SchemaVersion)
+		assert.Len(t, snapshot.Values, 2)
+		assert.Contains(t, snapshot.Values, "sync_runs-r1")
+		assert.Contains(t, snapshot.Values, "sync_last_run")
+
+		data, err := json.Marshal(snapshot)
+		require.NoError(t, err)
+		var decoded Snapshot
+		require.NoError(t, json.Unmarshal(data, &decoded))
+
+		target, targetKV := newFakeKV()
+		target.values["sync_runs-old"] = []byte(`{"schema_version": 1, "data": {"id": "old"}}`)
+		target.values["mutex_sync"] = []byte{0x2}
+		target.values["mmi_botid"] = []byte("z9y8x7w6v5u4t3s2r1q0p9o8n7")
+		require.NoError(t, Client{kv: targetKV}.Import(&decoded))
+
+		assert.JSONEq(t, string(source.values["sync_runs-r1"]), string(target.values["sync_runs-r1"]))
+		assert.JSONEq(t, string(source.values["sync_last_run"]), string(target.values["sync_last_run"]))
+		assert.NotContains(t, target.values, "sync_runs-old", "values missing from the snapshot are deleted")
+		assert.Equal(t, []byte{0x2}, target.values["mutex_sync"], "cluster keys are left alone")
+		assert.Equal(t, []byte("z9y8x7w6v5u4t3s2r1q0p9o8n7"), target.values["mmi_botid"], "the server's bot ID is left alone")
+		assert.JSONEq(t, `{"schema_version": 1, "data": 1}`, string(target.values[schemaVersionKey]))
+	})
+
+	t.Run("rejects newer schema versions", func(t *testing.T) {
+		api, kv := newFakeKV()
+		api.values["sync_runs-r1"] = []byte(`{}`)
+		err := Client{kv: kv}.Import(&Snapshot{SchemaVersion: LatestSchemaVersion() + 1})
+		require.Error(t, err)
+		assert.Contains(t, api.values, "sync_runs-r1", "nothing is changed")
+	})
+
+	t.Run("rejects reserved keys", func(t *testing.T) {
+		_, kv := newFakeKV()
+		err := Client{kv: kv}.Import(&Snapshot{Values: map[string]json.RawMessage{"mutex_sync": json.RawMessage(`{}`)}})
+		require.Error(t, err)
+	})
+}
@@ -1,29 +1,64 @@
 package kvstore
 
 import (
+	"time"
+
 	"github.com/mattermost/mattermost/server/public/pluginapi"
-	"github.com/pkg/errors"
 )
 
 // We expose our calls to the KVStore pluginapi methods through this interface for testability and stability.
 // This allows us to better control which values are stored with which keys.
 
 type Client struct {
-	client *pluginapi.Client
+	kv KVService
+
+	templateData Repository[string]
+
+	cursors      Repository[SyncCursor]
+	checkpoints  Repository[SyncCheckpoint]
+	activeRuns   Repository[string]
+	sourceFields Repository[[]string]
+	runs         Repository[SyncRun]
+	lastRun      Value[string]
+	lastSuccess  Value[time.Time]
+	failures     Repository[UserFailure]
+	ownedValues  Repository[OwnedValues]
+	syncLock     Value[SyncLock]
+	cancels      Repository[SyncCancel]
+
+	ruleMembers Repository[[]string]
+	ruleAdmins  Repository[[]string]
 }
 
 func NewKVStore(client *pluginapi.Client) KVStore {
+	kv := &client.KV
 	return Client{
-		client: client,
+		kv: kv,
+
+		templateData: NewRepository[string](kv, "template_key", 1),
+
+		cursors:      NewRepository[SyncCursor](kv, "sync_cursor", 1),
+		checkpoints:  NewRepository[SyncCheckpoint](kv, "sync_checkpoint", 1),
+		activeRuns:   NewRepository[string](kv, "sync_active_run", 1),
+		sourceFields: NewRepository[[]string](kv, "sync_source_fields", 1),
+		runs:         NewRepository[SyncRun](kv, "sync_run", 1),
+		lastRun:      NewValue[string](kv, "sync_last_run", 1),
+		lastSuccess:  NewValue[time.Time](kv, "sync_last_success", 1),
+		failures:     NewRepository[UserFailure](kv, "sync_failure", 1),
+		ownedValues:  NewRepository[OwnedValues](kv, "sync_owned_values", 1),
+		syncLock:     NewValue[SyncLock](kv, syncLockKey, 1),
+		cancels:      NewRepository[SyncCancel](kv, syncCancelNamespace, 1),
+
+		ruleMembers: NewRepository[[]string](kv, "membership_rule", 1),
+		ruleAdmins:  NewRepository[[]string](kv, "membership_rule_admins", 1),
 	}
 }
 
 // Sample method to get a key-value pair in the KV store
 func (kv Client) GetTemplateData(userID string) (string, error) {
-	var templateData string
-	err := kv.client.KV.Get("template_key-"+userID, &templateData)
-	if err != nil {
-		return "", errors.Wrap(err, "failed to get template data")
+	templateData, err := kv.templateData.Get(userID)
+	if err != nil || templateData == nil {
+		return "", err
 	}
-	return templateData, nil
+	return *templateData, nil
 }
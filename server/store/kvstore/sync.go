@@ -0,0 +1,126 @@
+package kvstore
+
+import (
+	"time"
+)
+
+// SyncCursor records how far a source has been synced.
+type SyncCursor struct {
+	// Cursor is the opaque watermark returned by the source after the last successful run.
+	Cursor string `json:"cursor"`
+
+	// LastFullSync is when the full directory was last reconciled.
+	LastFullSync time.Time `json:"last_full_sync"`
+
+	// UpdatedAt is when the cursor was last advanced.
+	UpdatedAt time.Time `json:"updated_at"`
+
+	// EditsCheckedAt is when users were last checked for edits to source-owned attributes.
+	EditsCheckedAt time.Time `json:"edits_checked_at"`
+}
+
+// GetSyncCursor returns the stored cursor for source, or nil if none has been saved.
+func (kv Client) GetSyncCursor(source string) (*SyncCursor, error) {
+	return kv.cursors.Get(source)
+}
+
+// SaveSyncCursor stores the cursor for source.
+func (kv Client) SaveSyncCursor(source string, cursor *SyncCursor) error {
+	return kv.cursors.Set(source, cursor)
+}
+
+// SyncStats counts what a run has done so far.
+type SyncStats struct {
+	Fetched     int `json:"fetched"`
+	Matched     int `json:"matched"`
+	Updated     int `json:"updated"`
+	Unmatched   int `json:"unmatched"`
+	Failed      int `json:"failed"`
+	Quarantined int `json:"quarantined"`
+	Excluded    int `json:"excluded"`
+	Reset       int `json:"reset"`
+}
+
+// SyncCheckpoint records the progress of a run after each page committed, so that a run
+// interrupted by a restart can be resumed.
+type SyncCheckpoint struct {
+	RunID  string `json:"run_id"`
+	Source string `json:"source"`
+
+	// Cursor is the cursor the run started from.
+	Cursor string `json:"cursor"`
+
+	// PageToken is the token of the next page to fetch.
+	PageToken string `json:"page_token"`
+
+	// Full reports whether the source is returning the full directory.
+	Full bool `json:"full"`
+
+	Stats SyncStats `json:"stats"`
+
+	StartedAt time.Time `json:"started_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// GetActiveCheckpoint returns the checkpoint of the run in progress for source, or nil if there is none.
+func (kv Client) GetActiveCheckpoint(source string) (*SyncCheckpoint, error) {
+	runID, err := kv.activeRuns.Get(source)
+	if err != nil || runID == nil {
+		return nil, err
+	}
+	return kv.checkpoints.Get(*runID)
+}
+
+// SaveCheckpoint stores checkpoint under its run ID and records it as the active run of its source.
+func (kv Client) SaveCheckpoint(checkpoint *SyncCheckpoint) error {
+	batch := NewBatch(kv.kv)
+	if err := kv.checkpoints.SetIn(batch, checkpoint.RunID, checkpoint); err != nil {
+		return err
+	}
+	if err := kv.activeRuns.SetIn(batch, checkpoint.Source, &checkpoint.RunID); err != nil {
+		return err
+	}
+	return batch.Flush()
+}
+
+// DeleteCheckpoint removes checkpoint and clears the active run of its source.
+func (kv Client) DeleteCheckpoint(checkpoint *SyncCheckpoint) error {
+	batch := NewBatch(kv.kv)
+	kv.activeRuns.DeleteIn(batch, checkpoint.Source)
+	kv.checkpoints.DeleteIn(batch, checkpoint.RunID)
+	return batch.Flush()
+}
+
+// ListCheckpoints returns every stored checkpoint.
+func (kv Client) ListCheckpoints() ([]*SyncCheckpoint, error) {
+	runIDs, err := kv.checkpoints.List()
+	if err != nil {
+		return nil, err
+	}
+	var checkpoints []*SyncCheckpoint
+	for _, runID := range runIDs {
+		checkpoint, err := kv.checkpoints.Get(runID)
+		if err != nil {
+			return nil, err
+		}
+		if checkpoint != nil {
+			checkpoints = append(checkpoints, checkpoint)
+		}
+	}
+	return checkpoints, nil
+}
+
+// DeleteStaleCheckpoint removes the checkpoint of a run that is no longer active.
+func (kv Client) DeleteStaleCheckpoint(runID string) error {
+	return kv.checkpoints.Delete(runID)
+}
+
+// GetSourceFields returns the field names stored for source.
+func (kv Client) GetSourceFields(source string) ([]string, error) {
+	return getList(kv.sourceFields, source)
+}
+
+// SaveSourceFields stores the field names seen for source.
+func (kv Client) SaveSourceFields(source string, fields []string) error {
+	return kv.sourceFields.Set(source, &fields)
+}
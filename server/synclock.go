@@ -0,0 +1,261 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/pluginapi/cluster"
+	"github.com/pkg/errors"
+
+	"github.com/mattermost/mattermost-plugin-starter-template/server/store/kvstore"
+)
+
+const (
+	// syncMutexKey names the cluster mutex held by every sync run.
+	syncMutexKey = "attrsync_run"
+
+	// syncLockWait bounds how long a run waits for the sync lock before reporting the run
+	// holding it. It only needs to cover a single locking attempt.
+	syncLockWait = 200 * time.Millisecond
+
+	// syncHeartbeatInterval is how often the lock holder refreshes its record.
+	syncHeartbeatInterval = 30 * time.Second
+
+	// syncLockStaleAfter is how long after its last heartbeat a lock holder is considered to have
+	// crashed.
+	syncLockStaleAfter = 3 * syncHeartbeatInterval
+)
+
+// syncRunningError is returned when the sync lock cannot be taken because another run or an
+// import holds it.
+type syncRunningError struct {
+	// holder is nil if the lock holder has not recorded itself yet.
+	holder *kvstore.SyncLock
+}
+
+func (e syncRunningError) Error() string {
+	if e.holder == nil {
+		return "a sync is already running"
+	}
+	return fmt.Sprintf("%s is already running on node %s since %s",
+		e.holder.Description(), e.holder.NodeID, e.holder.StartedAt.UTC().Format("2006-01-02 15:04:05 MST"))
+}
+
+// nodeID identifies this server among the cluster nodes in the sync lock.
+func nodeID() string {
+	hostname, err := os.Hostname()
+	if err != nil || hostname == "" {
+		return "unknown"
+	}
+	return hostname
+}
+
+// syncRun holds the cluster-wide sync lock for a single run, so that only one sync runs at a time
+// on the whole cluster. It implements attrsync.Canceller, letting the run be cancelled from any
+// node through the KV store.
+type syncRun struct {
+	p       *Plugin
+	trigger string
+	mutex   *cluster.Mutex
+
+	// mu guards holder, which the heartbeat refreshes while the run records its ID.
+	mu     sync.Mutex
+	holder *kvstore.SyncLock
+
+	// started is closed once the run has recorded its ID.
+	started   chan struct{}
+	startOnce sync.Once
+
+	stopHeartbeat chan struct{}
+	heartbeatDone chan struct{}
+}
+
+// newSyncRun prepares a run started by trigger, one of the kvstore.SyncTrigger values. It must be
+// locked before the run starts.
+func (p *Plugin) newSyncRun(trigger string) *syncRun {
+	return &syncRun{
+		p:       p,
+		trigger: trigger,
+		started: make(chan struct{}),
+	}
+}
+
+// lock takes the sync lock. It does not wait for a running sync to finish, but fails with a
+// syncRunningError naming it.
+//
+// The cluster mutex expires shortly after a node crashes while holding it, but the record of the
+// holder is left behind; it is reported as stale and replaced by the next run.
+func (r *syncRun) lock() error {
+	mutex, err := cluster.NewMutex(r.p.API, syncMutexKey)
+	if err != nil {
+		return errors.Wrap(err, "failed to create sync mutex")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), syncLockWait)
+	defer cancel()
+	if err = mutex.LockWithContext(ctx); err != nil {
+		holder, getErr := r.p.RunningSync()
+		if getErr != nil {
+			r.p.API.LogWarn("Failed to get the sync lock holder", "err", getErr)
+		}
+		return syncRunningError{holder: holder}
+	}
+	r.mutex = mutex
+
+	previous, err := r.p.kvstore.GetSyncLock()
+	if err != nil {
+		r.p.API.LogWarn("Failed to get the sync lock holder", "err", err)
+	} else if previous != nil {
+		r.p.API.LogWarn("Took over a stale sync lock, the node holding it stopped without releasing it",
+			"node_id", previous.NodeID,
+			"trigger", previous.Trigger,
+			"run_id", previous.RunID,
+			"started_at", previous.StartedAt,
+			"heartbeat_at", previous.HeartbeatAt,
+		)
+		r.deleteCancel(previous.RunID)
+	}
+
+	now := time.Now()
+	r.holder = &kvstore.SyncLock{
+		NodeID:      r.p.nodeID,
+		Trigger:     r.trigger,
+		StartedAt:   now,
+		HeartbeatAt: now,
+	}
+	r.saveHolder()
+
+	r.stopHeartbeat = make(chan struct{})
+	r.heartbeatDone = make(chan struct{})
+	go r.heartbeat()
+	return nil
+}
+
+func (r *syncRun) heartbeat() {
+	defer close(r.heartbeatDone)
+	ticker := time.NewTicker(syncHeartbeatInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			r.mu.Lock()
+			r.holder.HeartbeatAt = time.Now()
+			r.mu.Unlock()
+			r.saveHolder()
+		case <-r.stopHeartbeat:
+			return
+		}
+	}
+}
+
+// saveHolder records the holder of the lock. Failing to is only logged, as the lock itself is
+// held regardless.
+func (r *syncRun) saveHolder() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := r.p.kvstore.SaveSyncLock(r.holder); err != nil {
+		r.p.API.LogWarn("Failed to record the sync lock holder", "err", err)
+	}
+}
+
+// deleteCancel removes the request to cancel the run with runID, if any.
+func (r *syncRun) deleteCancel(runID string) {
+	if runID == "" {
+		return
+	}
+	if err := r.p.kvstore.DeleteSyncCancel(runID); err != nil {
+		r.p.API.LogWarn("Failed to remove the sync cancel request", "run_id", runID, "err", err)
+	}
+}
+
+// unlock releases the sync lock.
+func (r *syncRun) unlock() {
+	close(r.stopHeartbeat)
+	<-r.heartbeatDone
+	r.deleteCancel(r.holder.RunID)
+	if err := r.p.kvstore.DeleteSyncLock(); err != nil {
+		r.p.API.LogWarn("Failed to remove the sync lock holder", "err", err)
+	}
+	r.mutex.Unlock()
+}
+
+// RunStarted records the ID of the run with the lock, so that it can be cancelled by ID.
+func (r *syncRun) RunStarted(runID string) {
+	r.mu.Lock()
+	r.holder.RunID = runID
+	r.mu.Unlock()
+	r.saveHolder()
+	r.startOnce.Do(func() { close(r.started) })
+}
+
+// runID returns the ID recorded by RunStarted, or an empty string if the run has not started.
+func (r *syncRun) runID() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.holder.RunID
+}
+
+// CancelRequested reports whether the run with runID was asked to stop through CancelSync.
+func (r *syncRun) CancelRequested(runID string) (bool, error) {
+	cancel, err := r.p.kvstore.GetSyncCancel(runID)
+	if err != nil {
+		return false, err
+	}
+	return cancel != nil, nil
+}
+
+// RunningSync returns the holder of the sync lock, or nil if no sync is running. Holders that
+// stopped refreshing their heartbeat are ignored.
+func (p *Plugin) RunningSync() (*kvstore.SyncLock, error) {
+	holder, err := p.kvstore.GetSyncLock()
+	if err != nil {
+		return nil, err
+	}
+	if holder == nil || holder.Stale(time.Now(), syncLockStaleAfter) {
+		return nil, nil
+	}
+	return holder, nil
+}
+
+// runningSyncRun describes the run with runID while it holds the sync lock, or returns nil if it
+// is not running.
+func (p *Plugin) runningSyncRun(runID string) (*kvstore.SyncRun, error) {
+	running, err := p.RunningSync()
+	if err != nil || running == nil || running.RunID != runID {
+		return nil, err
+	}
+	return &kvstore.SyncRun{
+		ID:        running.RunID,
+		Status:    kvstore.RunStatusRunning,
+		Manual:    running.Trigger == kvstore.SyncTriggerManual,
+		StartedAt: running.StartedAt,
+	}, nil
+}
+
+// CancelSync asks the running sync to stop after the page it is working on, on whichever node it
+// runs. If runID is not empty, only the run with that ID is cancelled. It returns the holder of
+// the sync lock, or nil if no matching sync is running.
+func (p *Plugin) CancelSync(runID, userID string) (*kvstore.SyncLock, error) {
+	running, err := p.RunningSync()
+	if err != nil {
+		return nil, err
+	}
+	// Runs record their ID as soon as they start, so a holder without one has yet to do anything.
+	if running == nil || running.RunID == "" || (runID != "" && running.RunID != runID) {
+		return nil, nil
+	}
+
+	err = p.kvstore.SaveSyncCancel(&kvstore.SyncCancel{
+		RunID:       running.RunID,
+		RequestedBy: userID,
+		RequestedAt: time.Now(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	p.API.LogInfo("Requested to cancel the sync run", "run_id", running.RunID, "node_id", running.NodeID, "user_id", userID)
+	return running, nil
+}